@@ -7,13 +7,48 @@ import (
 )
 
 func NewAddCmd(opts *Options) *cobra.Command {
-	var up bool // --update
+	var (
+		up      bool // --update
+		encrypt bool
+		alt     []string
+	)
 	c := &cobra.Command{
 		Use:   "add <file...>",
 		Short: "Add new files",
 		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			g := opts.Git()
+			if err = cleanPaths(args); err != nil {
+				return err
+			}
+			if err = checkAddable(opts, args); err != nil {
+				return err
+			}
+			if len(alt) > 0 {
+				if err = copyAlternates(opts, args, alt); err != nil {
+					return err
+				}
+			}
+			// New files are moved to the checkout when installing with symlinks.
+			moved, err := adoptFiles(opts, g, args)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err == nil {
+					return
+				}
+				if e := unadoptFiles(moved); e != nil {
+					err = errors.Wrapf(err, "%v, could not move the files back", e)
+				}
+			}()
+			if encrypt {
+				attrs, err := encryptFiles(opts, g, args)
+				if err != nil {
+					return err
+				}
+				args = append(args, attrs)
+			}
 			if up {
 				updated, err := getUpdated(g, opts, nil)
 				if err != nil {
@@ -28,11 +63,13 @@ func NewAddCmd(opts *Options) *cobra.Command {
 		},
 	}
 	c.Flags().BoolVarP(&up, "update", "u", up, "update any changed files as well as add new ones")
+	c.Flags().BoolVar(&encrypt, "encrypt", encrypt, "encrypt the files when they are committed, see 'dots key'")
+	c.Flags().StringSliceVar(&alt, "alt", alt, "add the files as alternates for this machine's os, arch, class, hostname or user")
 	opts.addUserFlags(c.Flags())
 	return c
 }
 
-func add(opts *Options, git *git.Git, files []string) (err error) {
+func add(opts *Options, git git.Backend, files []string) (err error) {
 	if !git.Exists() {
 		err = git.InitBare()
 		if err != nil {
@@ -42,6 +79,10 @@ func add(opts *Options, git *git.Git, files []string) (err error) {
 	if err = cleanPaths(files); err != nil {
 		return err
 	}
+	if native, ok := nativeGit(git); ok {
+		_, err = native.CommitFiles(files, commitMessage("add", files), opts.identity())
+		return err
+	}
 	err = git.Add(files...)
 	if err != nil {
 		return err
@@ -49,3 +90,24 @@ func add(opts *Options, git *git.Git, files []string) (err error) {
 	opts.applyUserTo(git)
 	return git.Commit(commitMessage("add", files))
 }
+
+// checkAddable refuses to add the encryption key and the directories that
+// dots keeps the repository and the checkout in.
+func checkAddable(opts *Options, files []string) error {
+	var (
+		key      = opts.keyFile()
+		repo     = opts.repo()
+		checkout = opts.checkoutDir()
+	)
+	for _, f := range files {
+		switch {
+		case f == key || dirContainsPath(f, key):
+			return errors.Errorf("refusing to add %q which contains the encryption key", f)
+		case f == repo || dirContainsPath(f, repo) || dirContainsPath(repo, f):
+			return errors.Errorf("refusing to add %q which contains the repository", f)
+		case f == checkout || dirContainsPath(f, checkout):
+			return errors.Errorf("refusing to add %q which contains the checkout", f)
+		}
+	}
+	return nil
+}
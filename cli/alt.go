@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/harrybrwn/dots/git"
+)
+
+// altSep separates the name of an alternate file from its conditions like
+// "file##os.Linux,hostname.laptop". Installing a tree also writes the best
+// matching alternate to the plain name, see selectAlternates.
+const altSep = "##"
+
+type altCond struct {
+	kind, value string
+}
+
+// altWeights are the scores of each kind of condition where more specific
+// conditions score higher. Short names are the same as yadm's.
+var altWeights = map[string]int{
+	"default":  0,
+	"os":       1,
+	"o":        1,
+	"arch":     2,
+	"a":        2,
+	"class":    4,
+	"c":        4,
+	"hostname": 8,
+	"h":        8,
+	"user":     16,
+	"u":        16,
+}
+
+// parseAlt splits the name of an alternate into the plain name and its
+// conditions.
+func parseAlt(name string) (string, []altCond, bool) {
+	base, conds, ok := strings.Cut(name, altSep)
+	if !ok || base == "" {
+		return "", nil, false
+	}
+	res := make([]altCond, 0)
+	for c := range strings.SplitSeq(conds, ",") {
+		kind, value, _ := strings.Cut(c, ".")
+		res = append(res, altCond{kind: kind, value: value})
+	}
+	return base, res, true
+}
+
+// altScore returns the score of an alternate and false if any of its
+// conditions do not match.
+func altScore(conds []altCond, env *templateData) (int, bool) {
+	score := 0
+	for _, c := range conds {
+		w, ok := altWeights[c.kind]
+		if !ok {
+			return 0, false
+		}
+		if c.kind != "default" && !altMatches(c, env) {
+			return 0, false
+		}
+		score += w
+	}
+	return score, true
+}
+
+func altMatches(c altCond, env *templateData) bool {
+	switch c.kind {
+	case "os", "o":
+		return strings.EqualFold(c.value, env.OS)
+	case "arch", "a":
+		return strings.EqualFold(c.value, env.Arch) || strings.EqualFold(archAliases[c.value], env.Arch)
+	case "class", "c":
+		return c.value == altClass(env)
+	case "hostname", "h":
+		return c.value == env.Hostname || c.value == strings.Split(env.Hostname, ".")[0]
+	case "user", "u":
+		return c.value == env.User
+	}
+	return false
+}
+
+// archAliases maps the names printed by "uname -m" to GOARCH.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// unameOS maps GOOS to the names printed by "uname -s" which are used when
+// naming alternates so that they match yadm's.
+var unameOS = map[string]string{
+	"linux":   "Linux",
+	"darwin":  "Darwin",
+	"freebsd": "FreeBSD",
+	"openbsd": "OpenBSD",
+	"netbsd":  "NetBSD",
+}
+
+// altClass is the "class" of a machine from data.toml.
+func altClass(env *templateData) string {
+	class, _ := env.Data["class"].(string)
+	return class
+}
+
+// alternates maps the slash separated paths of the selected alternates to the
+// path they are installed to.
+type alternates map[string]string
+
+// selectAlternates picks the alternate with the highest score among the
+// alternates of each name in a list of slash separated tree paths.
+// Directories can be alternates too.
+func selectAlternates(paths []string, env *templateData) alternates {
+	type candidate struct {
+		path  string
+		score int
+	}
+	best := make(map[string]candidate)
+	for _, p := range paths {
+		parts := strings.Split(p, "/")
+		for i, part := range parts {
+			base, conds, ok := parseAlt(part)
+			if !ok {
+				continue
+			}
+			score, ok := altScore(conds, env)
+			if !ok {
+				continue
+			}
+			parent := path.Join(parts[:i]...)
+			raw := path.Join(parent, part)
+			key := path.Join(parent, base)
+			if c, ok := best[key]; !ok || score > c.score || (score == c.score && raw < c.path) {
+				best[key] = candidate{path: raw, score: score}
+			}
+		}
+	}
+	alts := make(alternates, len(best))
+	for _, c := range best {
+		base, _, _ := parseAlt(path.Base(c.path))
+		alts[c.path] = path.Join(path.Dir(c.path), base)
+	}
+	return alts
+}
+
+// target returns the path that a file is installed to when every alternate
+// in its path was selected. It returns false for paths without alternates.
+func (alts alternates) target(name string) (string, bool) {
+	var (
+		parts  = strings.Split(name, "/")
+		raw    string
+		mapped string
+		found  bool
+	)
+	for _, part := range parts {
+		raw = path.Join(raw, part)
+		if _, _, ok := parseAlt(part); !ok {
+			mapped = path.Join(mapped, part)
+			continue
+		}
+		target, ok := alts[raw]
+		if !ok {
+			return "", false
+		}
+		found = true
+		mapped = path.Join(mapped, path.Base(target))
+	}
+	if !found {
+		return "", false
+	}
+	return mapped, true
+}
+
+// altName returns the name of an alternate of a file with conditions such as
+// "os" or "hostname" using the values of the current machine. Conditions
+// with a value like "os.Darwin" are used as is.
+func altName(name string, kinds []string, env *templateData) (string, error) {
+	conds := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		if _, ok := altWeights[strings.SplitN(kind, ".", 2)[0]]; !ok {
+			return "", errors.Errorf("unknown alternate condition %q", kind)
+		}
+		if strings.Contains(kind, ".") || kind == "default" {
+			conds = append(conds, kind)
+			continue
+		}
+		var value string
+		switch kind {
+		case "os", "o":
+			value = env.OS
+			if name, ok := unameOS[value]; ok {
+				value = name
+			}
+		case "arch", "a":
+			value = env.Arch
+		case "class", "c":
+			value = altClass(env)
+		case "hostname", "h":
+			value = env.Hostname
+		case "user", "u":
+			value = env.User
+		}
+		if value == "" {
+			return "", errors.Errorf("no value for alternate condition %q", kind)
+		}
+		conds = append(conds, fmt.Sprintf("%s.%s", kind, value))
+	}
+	return name + altSep + strings.Join(conds, ","), nil
+}
+
+// copyAlternates copies files to the names of their alternates for this
+// machine and replaces each file with its alternate.
+func copyAlternates(opts *Options, files []string, kinds []string) error {
+	env, err := opts.templateData()
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		if strings.Contains(filepath.Base(f), altSep) {
+			return errors.Errorf("%q is already an alternate", f)
+		}
+		name, err := altName(f, kinds, env)
+		if err != nil {
+			return err
+		}
+		if err = copyPath(f, name); err != nil {
+			return errors.Wrapf(err, "could not copy %q to %q", f, name)
+		}
+		files[i] = name
+	}
+	return nil
+}
+
+// copyPath copies a file or directory.
+func copyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return copyFile(p, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// collectAlternates reads all the files of a tree and selects the
+// alternates that match this machine.
+func collectAlternates(opts *Options, files iter.Seq2[*git.TreeFile, error]) (alternates, []*git.TreeFile, error) {
+	var (
+		all   = make([]*git.TreeFile, 0)
+		paths = make([]string, 0)
+		found bool
+	)
+	for file, err := range files {
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not read file from repository")
+		}
+		all = append(all, file)
+		paths = append(paths, file.Path)
+		found = found || strings.Contains(file.Path, altSep)
+	}
+	if !found {
+		return nil, all, nil
+	}
+	env, err := opts.templateData()
+	if err != nil {
+		return nil, nil, err
+	}
+	return selectAlternates(paths, env), all, nil
+}
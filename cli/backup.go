@@ -0,0 +1,302 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const backupManifest = "manifest.json"
+
+func (o *Options) backupsDir() string {
+	return filepath.Join(o.ConfigDir, "backups")
+}
+
+// backupEntry is the state of a path before it was changed.
+type backupEntry struct {
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	// Target is the target of a symlink.
+	Target string `json:"target,omitempty"`
+	// Created is true if the path did not exist. Created paths are removed
+	// when rolling back but are not restored.
+	Created bool `json:"created,omitempty"`
+}
+
+func (e *backupEntry) isDir() bool     { return e.Mode.IsDir() }
+func (e *backupEntry) isSymlink() bool { return e.Mode&fs.ModeSymlink != 0 }
+
+// backup is a set of files saved before they were overwritten or removed.
+// It is only written to disk once a file that already existed is saved.
+type backup struct {
+	ID      string         `json:"id"`
+	Time    time.Time      `json:"time"`
+	Command string         `json:"command"`
+	Entries []*backupEntry `json:"entries"`
+
+	dir     string
+	saved   map[string]bool
+	dirty   bool // has entries that can be restored
+	created bool
+}
+
+func newBackup(opts *Options, command string) *backup {
+	now := time.Now()
+	return &backup{
+		ID:      now.Format("20060102T150405"),
+		Time:    now,
+		Command: command,
+		dir:     opts.backupsDir(),
+		saved:   make(map[string]bool),
+	}
+}
+
+func (b *backup) path() string { return filepath.Join(b.dir, b.ID) }
+
+// file is the path that the contents of a file are saved to.
+func (b *backup) file(path string) string {
+	return filepath.Join(b.path(), "files", strings.TrimPrefix(filepath.Clean(path), filepath.VolumeName(path)))
+}
+
+// save records the state of a path before it is changed and copies regular
+// files to the backup.
+func (b *backup) save(path string) error {
+	if b.saved[path] {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		b.saved[path] = true
+		b.Entries = append(b.Entries, &backupEntry{Path: path, Created: true})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	e := backupEntry{Path: path, Mode: info.Mode()}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		if e.Target, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if err = b.create(); err != nil {
+			return err
+		}
+		dst := b.file(path)
+		if err = os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err = copyFile(path, dst, info.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "could not back up %q", path)
+		}
+	}
+	b.saved[path] = true
+	b.Entries = append(b.Entries, &e)
+	if !e.isDir() {
+		b.dirty = true
+	}
+	return b.flush()
+}
+
+// create finds an unused directory for the backup.
+func (b *backup) create() error {
+	if b.created {
+		return nil
+	}
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return err
+	}
+	id := b.ID
+	for i := 2; ; i++ {
+		err := os.Mkdir(filepath.Join(b.dir, b.ID), 0700)
+		if err == nil {
+			b.created = true
+			return nil
+		} else if !os.IsExist(err) {
+			return err
+		}
+		b.ID = id + "-" + strconv.Itoa(i)
+	}
+}
+
+// flush writes the manifest if the backup has anything to restore.
+func (b *backup) flush() error {
+	if !b.dirty {
+		return nil
+	}
+	if err := b.create(); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(b.path(), backupManifest), append(raw, '\n'), 0600)
+}
+
+// restore puts a saved path back the way it was.
+func (b *backup) restore(e *backupEntry) error {
+	switch {
+	case e.Created:
+		err := os.Remove(e.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	case e.isDir():
+		return os.MkdirAll(e.Path, e.Mode.Perm())
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(e.Path); err == nil && !info.IsDir() {
+		if err = os.Remove(e.Path); err != nil {
+			return err
+		}
+	}
+	if e.isSymlink() {
+		return os.Symlink(e.Target, e.Path)
+	}
+	return copyFile(b.file(e.Path), e.Path, e.Mode.Perm())
+}
+
+// rollback undoes every change that the backup has saved, latest first.
+func (b *backup) rollback() error {
+	var errs []error
+	for _, e := range slices.Backward(b.Entries) {
+		if err := b.restore(e); err != nil {
+			errs = append(errs, errors.Wrapf(err, "could not restore %q", e.Path))
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// readBackups reads the manifests of all the backups, newest first.
+func readBackups(opts *Options) ([]*backup, error) {
+	dirs, err := os.ReadDir(opts.backupsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	backups := make([]*backup, 0, len(dirs))
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		b, err := readBackup(opts, d.Name())
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+func readBackup(opts *Options, id string) (*backup, error) {
+	if id == "" || id != filepath.Base(id) {
+		return nil, errors.Errorf("invalid backup id %q", id)
+	}
+	b := backup{dir: opts.backupsDir()}
+	raw, err := os.ReadFile(filepath.Join(b.dir, id, backupManifest))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = json.Unmarshal(raw, &b); err != nil {
+		return nil, errors.Wrapf(err, "invalid backup %q", id)
+	}
+	b.ID = id
+	return &b, nil
+}
+
+func NewBackupCmd(opts *Options) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "backup",
+		Short: "Manage the backups of files overwritten or removed by dots",
+		Long: `Manage the backups of files overwritten or removed by dots. Every install and
+uninstall saves the files it changes under the backups folder in the config
+directory.`,
+	}
+	c.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List backups, newest first",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				backups, err := readBackups(opts)
+				if err != nil {
+					return err
+				}
+				tab := NewTable(cmd.OutOrStdout())
+				tab.Head("ID", "DATE", "COMMAND", "FILES")
+				for _, b := range backups {
+					n := 0
+					for _, e := range b.Entries {
+						if !e.Created && !e.isDir() {
+							n++
+						}
+					}
+					tab.Add(b.ID, b.Time.Local().Format(time.DateTime), b.Command, strconv.Itoa(n))
+				}
+				return tab.Flush()
+			},
+		},
+		newBackupRestoreCmd(opts),
+	)
+	return c
+}
+
+func newBackupRestoreCmd(opts *Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <id> [paths...]",
+		Short: "Restore the files saved in a backup",
+		Long: `Restore the files saved in a backup. Only the files that were saved are
+restored, files created since the backup are left alone. Paths limit the
+files restored to those paths.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := readBackup(opts, args[0])
+			if os.IsNotExist(errors.Cause(err)) {
+				return errors.Errorf("no backup %q", args[0])
+			} else if err != nil {
+				return err
+			}
+			paths := args[1:]
+			if err = cleanPaths(paths); err != nil {
+				return err
+			}
+			restored := 0
+			for _, e := range b.Entries {
+				if e.Created || (len(paths) > 0 && !slices.ContainsFunc(paths, func(p string) bool {
+					return p == e.Path || dirContainsPath(p, e.Path)
+				})) {
+					continue
+				}
+				if err = b.restore(e); err != nil {
+					return errors.Wrapf(err, "could not restore %q", e.Path)
+				}
+				restored++
+			}
+			if restored == 0 && len(paths) > 0 {
+				return errors.New("no matching files in backup")
+			}
+			cmd.Printf("restored %d files from %s\n", restored, b.ID)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/harrybrwn/dots/git"
+)
+
+func NewBlameCmd(cli CLI) *cobra.Command {
+	var (
+		porcelain bool
+		rev       = "HEAD"
+	)
+	c := cobra.Command{
+		Use:   "blame <file>",
+		Short: "Show the commit that last changed each line of a file",
+		Long: `Show the commit that last changed each line of a tracked file along with
+the date and the name of the committer. Only the committed version of the file
+is shown, changes that have not been added with 'dots update' are left out.`,
+		Example: "  $ dots blame ~/.bashrc\n" +
+			"  $ dots blame --porcelain ~/.config/nvim/init.lua",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g := cli.Git()
+			paths, err := treePaths(g.WorkingTree(), args)
+			if err != nil {
+				return err
+			}
+			if len(paths) == 0 || strings.HasPrefix(paths[0], "../") {
+				return errors.Errorf("%q is not in the dotfiles tree", args[0])
+			}
+			lines, err := g.Blame(git.Ref(rev), paths[0])
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if porcelain {
+				err = writeBlamePorcelain(&buf, paths[0], lines)
+			} else {
+				err = writeBlame(&buf, lines)
+			}
+			if err != nil {
+				return err
+			}
+			return pageOutput(cmd.OutOrStdout(), &buf)
+		},
+		ValidArgsFunction: gitFilesCompletionFunc(cli),
+	}
+	f := c.Flags()
+	f.BoolVar(&porcelain, "porcelain", porcelain, "show the output in the same format as 'git blame --porcelain'")
+	f.StringVar(&rev, "rev", rev, "blame the file as of this commit, tag or branch")
+	return &c
+}
+
+// writeBlame writes each line with the commit hash, committer, date and line
+// number in the same layout as 'git blame'. Root commits are marked with '^'.
+func writeBlame(w io.Writer, lines []git.BlameLine) error {
+	var nameWidth int
+	for _, l := range lines {
+		name, _ := splitIdent(l.Commit.Commiter)
+		nameWidth = max(nameWidth, utf8.RuneCountInString(name))
+	}
+	numWidth := len(fmt.Sprint(len(lines)))
+	bw := bufio.NewWriter(w)
+	for _, l := range lines {
+		hash := l.Commit.Hash.String()[:8]
+		if l.Commit.IsRoot() {
+			hash = "^" + hash[:7]
+		}
+		name, _ := splitIdent(l.Commit.Commiter)
+		fmt.Fprintf(bw, "%s (%-*s %s %*d) %s\n",
+			hash,
+			nameWidth, name,
+			l.Commit.CommiterTime.Format(time.DateTime+" -0700"),
+			numWidth, l.FinalLine,
+			strings.TrimSuffix(l.Text, "\n"),
+		)
+	}
+	return bw.Flush()
+}
+
+// writeBlamePorcelain writes the lines in the format of
+// 'git blame --porcelain'. Consecutive lines from the same commit are grouped
+// and the details of each commit are only written the first time it is seen.
+func writeBlamePorcelain(w io.Writer, path string, lines []git.BlameLine) error {
+	var (
+		bw   = bufio.NewWriter(w)
+		seen = make(map[string]bool)
+	)
+	for i := 0; i < len(lines); {
+		l := &lines[i]
+		hash := l.Commit.Hash.String()
+		n := 1
+		for i+n < len(lines) && lines[i+n].Commit.Hash.Equal(l.Commit.Hash) &&
+			lines[i+n].OrigLine == l.OrigLine+n {
+			n++
+		}
+		fmt.Fprintf(bw, "%s %d %d %d\n", hash, l.OrigLine, l.FinalLine, n)
+		if !seen[hash] {
+			seen[hash] = true
+			writeIdent(bw, "author", l.Commit.Author, l.Commit.AuthorTime)
+			writeIdent(bw, "committer", l.Commit.Commiter, l.Commit.CommiterTime)
+			summary, _, _ := strings.Cut(l.Commit.Message, "\n")
+			fmt.Fprintf(bw, "summary %s\n", summary)
+			if l.Commit.IsRoot() {
+				bw.WriteString("boundary\n")
+			}
+			if l.Previous != nil {
+				fmt.Fprintf(bw, "previous %s %s\n", l.Previous, path)
+			}
+			fmt.Fprintf(bw, "filename %s\n", path)
+		}
+		for j := range n {
+			if j > 0 {
+				fmt.Fprintf(bw, "%s %d %d\n", hash, l.OrigLine+j, l.FinalLine+j)
+			}
+			text := lines[i+j].Text
+			bw.WriteByte('\t')
+			bw.WriteString(text)
+			if !strings.HasSuffix(text, "\n") {
+				bw.WriteByte('\n')
+			}
+		}
+		i += n
+	}
+	return bw.Flush()
+}
+
+func writeIdent(w *bufio.Writer, key, ident string, ts time.Time) {
+	name, mail := splitIdent(ident)
+	fmt.Fprintf(w, "%s %s\n", key, name)
+	fmt.Fprintf(w, "%s-mail %s\n", key, mail)
+	fmt.Fprintf(w, "%s-time %d\n", key, ts.Unix())
+	fmt.Fprintf(w, "%s-tz %s\n", key, ts.Format("-0700"))
+}
+
+// splitIdent splits a commit author like "Name <email> " into the name and
+// the email with its angle brackets.
+func splitIdent(ident string) (name, mail string) {
+	ident = strings.TrimSpace(ident)
+	i := strings.LastIndexByte(ident, '<')
+	if i < 0 {
+		return ident, ""
+	}
+	return strings.TrimSpace(ident[:i]), ident[i:]
+}
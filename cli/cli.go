@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/harrybrwn/dots/cli/dotfiles"
@@ -45,18 +47,33 @@ type Options struct {
 
 	user  string
 	email string
+
+	// backend replaces the repository when it is set so that commands can
+	// be tested without git.
+	backend git.Backend
 }
 
 func (o *Options) repo() string {
 	return filepath.Join(o.ConfigDir, repo)
 }
 
-func (o *Options) Git() *git.Git { return o.git() }
+// Git returns the backend used by commands that work without the git binary.
+func (o *Options) Git() git.Backend {
+	if o.backend != nil {
+		o.backend.SetFilter(filterName, &keyFilter{opts: o})
+		o.useCheckout(o.backend)
+		return o.backend
+	}
+	return o.git()
+}
 
 func (o *Options) NoColor() bool { return o.noColor }
 
 func (o *Options) git() *git.Git {
-	return git.New(o.repo(), o.Root)
+	g := git.New(o.repo(), o.Root)
+	g.SetFilter(filterName, &keyFilter{opts: o})
+	o.useCheckout(g)
+	return g
 }
 
 func (o *Options) HasReadme() bool {
@@ -74,6 +91,36 @@ func (o *Options) applyUserTo(g interface{ AppendPersistentArgs(...string) }) {
 	)
 }
 
+// identity returns the commit author used when committing without the git
+// binary.
+func (o *Options) identity() git.Identity {
+	return git.Identity{Name: o.user, Email: o.email}
+}
+
+// hasGit returns true if the git binary is installed. Commands that only
+// commit files fall back to writing objects natively when it is missing.
+func hasGit() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// nativeGit returns the repository if it needs to be committed to natively
+// because the git binary is missing.
+func nativeGit(b git.Backend) (*git.Git, bool) {
+	g, ok := b.(*git.Git)
+	return g, ok && !hasGit()
+}
+
+// execGit returns the repository for commands that pass their arguments
+// straight to git.
+func execGit(r dotfiles.Repo) (*git.Git, error) {
+	g, ok := r.Git().(*git.Git)
+	if !ok {
+		return nil, errors.New("command is not supported without the git binary")
+	}
+	return g, nil
+}
+
 func (o *Options) log() func(string, ...any) {
 	if o.verbose {
 		return func(f string, v ...any) { fmt.Printf(f+"\n", v...) }
@@ -133,7 +180,12 @@ git repo so that you don't have too.`,
 		NewUninstallCmd(&opts),
 		NewPullCmd(&opts),
 		NewDiffCmd(&opts),
+		NewBlameCmd(&opts),
 		NewGitCmd(&opts),
+		NewKeyCmd(&opts),
+		NewFilterCmd(&opts),
+		NewTemplateCmd(&opts),
+		NewBackupCmd(&opts),
 
 		NewUtilCmd(&opts),
 		NewVersionCmd(),
@@ -144,6 +196,8 @@ git repo so that you don't have too.`,
 		NewLSCmd(&opts),
 		NewSyncCmd(&opts),
 		NewUndoCmd(&opts),
+		NewReflogCmd(&opts),
+		NewSnapshotCmd(&opts),
 		NewAddCmd(&opts),
 		NewRemoveCmd(&opts),
 		NewUpdateCmd(&opts),
@@ -197,6 +251,10 @@ func NewRemoveCmd(opts *Options) *cobra.Command {
 				return err
 			}
 			g := opts.Git()
+			if native, ok := nativeGit(g); ok {
+				_, err := native.CommitRemoval(args, commitMessage("remove", args), opts.identity())
+				return err
+			}
 			err := g.Remove(args...)
 			if err != nil {
 				return err
@@ -231,10 +289,11 @@ func removeReadme(base string, files []string) []string {
 
 func NewGitCmd(r dotfiles.Repo) *cobra.Command {
 	fn := func(cmd *cobra.Command, a []string) error {
-		var (
-			g = r.Git()
-			c = g.Cmd(a...)
-		)
+		g, err := execGit(r)
+		if err != nil {
+			return err
+		}
+		c := g.Cmd(a...)
 		c.Stdout = cmd.OutOrStdout()
 		return execute(c)
 	}
@@ -249,45 +308,34 @@ func NewGitCmd(r dotfiles.Repo) *cobra.Command {
 func writeGitignore(opts *Options) error {
 	filename := opts.excludesFile()
 	// Entries in the global gitignore have to be relative for some reason.
-	ignored, err := filepath.Rel(opts.Root, opts.repo())
-	if err != nil {
-		return err
-	}
-	if !exists(filename) {
-		f, err := os.Create(filename)
+	ignored := make([]string, 0, 3)
+	for _, p := range []string{opts.repo(), opts.keyFile(), opts.checkoutDir()} {
+		rel, err := filepath.Rel(opts.Root, p)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-		_, err = fmt.Fprintf(f, "%s\n", ignored)
-		if err != nil {
-			return err
-		}
-	} else {
-		f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND, os.FileMode(0644))
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		found := false
-		buf := bufio.NewScanner(f)
-		for buf.Scan() {
-			if strings.Trim(buf.Text(), "\n\t ") == ignored {
-				found = true
-				break
-			}
+		ignored = append(ignored, rel)
+	}
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	found := make(map[string]bool, len(ignored))
+	buf := bufio.NewScanner(f)
+	for buf.Scan() {
+		found[strings.Trim(buf.Text(), "\n\t ")] = true
+	}
+	if err = buf.Err(); err != nil {
+		return err
+	}
+	for _, ignored := range ignored {
+		if found[ignored] {
+			continue
 		}
-		if err = buf.Err(); err != nil {
+		if _, err = fmt.Fprintf(f, "%s\n", ignored); err != nil {
 			return err
 		}
-		if !found {
-			if _, err = f.WriteString(ignored); err != nil {
-				return err
-			}
-			if _, err = f.Write([]byte{'\n'}); err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
@@ -355,7 +403,9 @@ func cleanPaths(files []string) error {
 		return err
 	}
 	for i := range files {
-		if !filepath.IsAbs(files[i]) {
+		if filepath.IsAbs(files[i]) {
+			files[i] = filepath.Clean(files[i])
+		} else {
 			files[i] = filepath.Join(cwd, files[i])
 		}
 	}
@@ -1,11 +1,23 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/matryer/is"
+	"github.com/spf13/cobra"
+
+	"github.com/harrybrwn/dots/crypt"
+	"github.com/harrybrwn/dots/git"
 )
 
 func TestClone(t *testing.T) {
@@ -30,6 +42,88 @@ func TestClone(t *testing.T) {
 	}
 }
 
+func TestMain(m *testing.M) {
+	// The filter commands configured for the git binary run the test binary
+	// so it acts like dots when git runs it.
+	if os.Getenv("DOTS_TEST_FILTER") == "1" {
+		if err := NewRootCmd().Execute(); err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCloneEncrypted(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("DOTS_TEST_FILTER", "1")
+	is := is.New(t)
+	tmp := t.TempDir()
+	newOpts := func(name string) *Options {
+		root := filepath.Join(tmp, name)
+		is.NoErr(os.MkdirAll(filepath.Join(root, ".ssh"), 0700))
+		return &Options{
+			Root:      root,
+			ConfigDir: filepath.Join(root, ".config/dots"),
+			user:      "jane",
+			email:     "jane@example.com",
+		}
+	}
+	mustRun := func(c *cobra.Command, args ...string) {
+		t.Helper()
+		out, err := run(c, "", args...)
+		if err != nil {
+			t.Log(out)
+		}
+		is.NoErr(err)
+	}
+	src := newOpts("src")
+	config := filepath.Join(src.Root, ".ssh/config")
+	is.NoErr(os.WriteFile(config, []byte("Host example.com\n"), 0600))
+	mustRun(NewKeyCmd(src), "init")
+	mustRun(NewAddCmd(src), "--encrypt", config)
+
+	dst := newOpts("dst")
+	is.NoErr(dst.writeKey(must(src.readKey())))
+	mustRun(NewInstallCmd(dst), "--yes", src.repo())
+	config = filepath.Join(dst.Root, ".ssh/config")
+	is.Equal(string(must(os.ReadFile(config))), "Host example.com\n")
+	clean, _ := must(dst.git().Config()).Get("filter.dots.clean")
+	is.True(len(clean) > 0) // should configure the filter for the git binary
+
+	is.NoErr(os.WriteFile(config, []byte("Host example.org\n"), 0600))
+	mustRun(NewUpdateCmd(dst))
+	g := dst.git()
+	is.Equal(len(must(g.Modifications())), 0)
+	var out bytes.Buffer
+	cmd := g.Cmd("cat-file", "blob", "HEAD:.ssh/config")
+	cmd.Stdout = &out
+	is.NoErr(cmd.Run())
+	is.True(crypt.IsEncrypted(out.Bytes())) // should be committed encrypted
+	out.Reset()
+	cmd = g.Cmd("show", "HEAD:.ssh/config")
+	cmd.Stdout = &out
+	is.NoErr(cmd.Run())
+	data, err := g.Smudge(".ssh/config", out.Bytes())
+	is.NoErr(err)
+	is.Equal(string(data), "Host example.org\n")
+
+	// Repositories cloned before the filter was configured on clone get it
+	// when they are installed, but not from commands that only read them.
+	for _, key := range []string{"clean", "smudge", "required"} {
+		is.NoErr(g.RunCmd("config", "--unset", "filter.dots."+key))
+	}
+	mustRun(NewLSCmd(dst))
+	_, ok := must(dst.git().Config()).Get("filter.dots.required")
+	is.True(!ok)
+	mustRun(NewInstallCmd(dst), "--yes")
+	required, _ := must(dst.git().Config()).Get("filter.dots.required")
+	is.Equal(required, "true")
+}
+
 func TestRemoveReadme(t *testing.T) {
 	is := is.New(t)
 	files := []string{
@@ -69,3 +163,727 @@ func TestDirContainsPath(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkflow(t *testing.T) {
+	is := is.New(t)
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "home")
+	is.NoErr(os.MkdirAll(filepath.Join(root, ".config/nvim"), 0755))
+	mem := git.NewMemory(root)
+	opts := &Options{
+		Root:      root,
+		ConfigDir: filepath.Join(tmp, "config"),
+		user:      "jane",
+		email:     "jane@example.com",
+		backend:   mem,
+	}
+	write := func(name, content string) {
+		t.Helper()
+		is.NoErr(os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+	write(".bashrc", "export A=1\n")
+	write(".vimrc", "set number\n")
+	write(".config/nvim/init.lua", "require('a')\n")
+
+	_, err := run(NewAddCmd(opts), "", filepath.Join(root, ".bashrc"), filepath.Join(root, ".vimrc"), filepath.Join(root, ".config"))
+	is.NoErr(err)
+	is.True(mem.Exists())
+	is.Equal(must(mem.LsFiles()), []string{".bashrc", ".config/nvim/init.lua", ".vimrc"})
+	head := must(mem.HeadCommit())
+	is.Equal(head.Message, "[add] .bashrc, .vimrc, .config")
+	is.Equal(head.Author, "jane <jane@example.com> ")
+
+	// Nothing to pull without a remote so update only commits.
+	write(".bashrc", "export A=2\n")
+	mods, err := modifiedSet(mem)
+	is.NoErr(err)
+	is.Equal(mods[".bashrc"], git.ModChanged)
+	out, err := run(NewDiffCmd(opts), "")
+	is.NoErr(err)
+	is.Equal(out, "diff --git a/.bashrc b/.bashrc\n--- a/.bashrc\n+++ b/.bashrc\n@@ -1 +1 @@\n-export A=1\n+export A=2\n")
+	out, err = run(NewDiffCmd(opts), "", "--stat", filepath.Join(root, ".config"))
+	is.NoErr(err)
+	is.Equal(out, "") // only .bashrc changed
+	out, err = run(NewDiffCmd(opts), "", "--stat")
+	is.NoErr(err)
+	is.Equal(out, " .bashrc | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)\n")
+	_, err = run(NewUpdateCmd(opts), "")
+	is.NoErr(err)
+	is.Equal(must(mem.HeadCommit()).Message, "[update] .bashrc")
+	is.Equal(len(must(mem.Modifications())), 0)
+
+	_, err = run(NewRemoveCmd(opts), "", filepath.Join(root, ".vimrc"))
+	is.NoErr(err)
+	is.Equal(must(mem.LsFiles()), []string{".bashrc", ".config/nvim/init.lua"})
+	is.True(exists(filepath.Join(root, ".vimrc"))) // only removed from the repo
+
+	_, err = run(NewSyncCmd(opts), "")
+	is.True(err != nil) // no remote
+	remote := git.NewMemory("")
+	mem.SetRemote("origin", remote)
+	_, err = run(NewSyncCmd(opts), "")
+	is.NoErr(err)
+	is.Equal(must(remote.LsFiles()), must(mem.LsFiles()))
+	write(".bashrc", "export A=3\n")
+	_, err = run(NewUpdateCmd(opts), "")
+	is.NoErr(err)
+	_, err = run(NewSyncCmd(opts), "")
+	is.NoErr(err)
+	is.Equal(must(remote.HeadCommit()).Hash, must(mem.HeadCommit()).Hash)
+
+	_, err = run(NewGitCmd(opts), "", "status")
+	is.True(err != nil) // needs the git binary
+
+	out, err = run(NewUninstallCmd(opts), "")
+	is.NoErr(err)
+	is.Equal(out, "uninstall successful\n")
+	is.True(!exists(filepath.Join(root, ".bashrc")))
+	is.True(!exists(filepath.Join(root, ".config/nvim")))
+	is.True(exists(filepath.Join(root, ".vimrc")))
+}
+
+func TestInstall(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	r.write(r.src, map[string]string{
+		".bashrc":         "export A=1\n",
+		".local/bin/run":  "#!/bin/sh\n",
+		".config/nvim/rc": "set a\n",
+	})
+	is.NoErr(os.Chmod(filepath.Join(r.src, ".local/bin/run"), 0755))
+	is.NoErr(os.Symlink(".bashrc", filepath.Join(r.src, ".profile")))
+	r.commit("init")
+
+	out, err := run(NewInstallCmd(r.opts), "", "--dry-run")
+	is.NoErr(err)
+	is.True(strings.HasSuffix(out, "8 actions: 3 create, 4 mkdir, 1 symlink\n"))
+	is.True(!exists(filepath.Join(r.dst, ".bashrc"))) // a dry run should not write anything
+	_, err = run(NewInstallCmd(r.opts), "", "--json")
+	is.Equal(err, errJSONWithoutDryRun)
+
+	_, err = run(NewInstallCmd(r.opts), "", "--yes")
+	is.NoErr(err)
+	is.Equal(r.read(".bashrc"), "export A=1\n")
+	info, err := os.Stat(filepath.Join(r.dst, ".local/bin/run"))
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0755))
+	target, err := os.Readlink(filepath.Join(r.dst, ".profile"))
+	is.NoErr(err)
+	is.Equal(target, ".bashrc")
+	is.Equal(must(r.opts.installMode(r.mem)), modeCopy)
+
+	// The mode is saved and used by the next install.
+	_, err = run(NewInstallCmd(r.opts), "", "--yes", "--mode", "symlink")
+	is.NoErr(err)
+	is.Equal(must(r.opts.installMode(r.mem)), modeSymlink)
+	is.Equal(linkStatus(r.dst, r.opts.checkoutDir(), ".bashrc"), linkOK)
+	is.NoErr(os.Remove(filepath.Join(r.dst, ".config/nvim/rc")))
+	_, err = run(NewInstallCmd(r.opts), "", "--yes")
+	is.NoErr(err)
+	is.Equal(linkStatus(r.dst, r.opts.checkoutDir(), ".config/nvim/rc"), linkOK)
+	_, err = run(NewInstallCmd(r.opts), "", "--mode", "hardlink")
+	is.True(err != nil)
+}
+
+func TestInstallRefreshIndex(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	is := is.New(t)
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "home")
+	is.NoErr(os.MkdirAll(root, 0755))
+	g := git.New(filepath.Join(tmp, "repo"), root)
+	g.SetPersistentArgs([]string{"-c", "user.name=jane", "-c", "user.email=jane@example.com"})
+	is.NoErr(g.InitBare())
+	opts := &Options{Root: root, ConfigDir: tmp, backend: g}
+	// The files are older than the index so that the installed files do not
+	// have the same stat data.
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{".bashrc", ".vimrc"} {
+		is.NoErr(os.WriteFile(filepath.Join(root, name), []byte(name+"\n"), 0644))
+		is.NoErr(os.Chtimes(filepath.Join(root, name), past, past))
+	}
+	is.NoErr(g.Add(root))
+	is.NoErr(g.Commit("init"))
+	is.NoErr(os.Remove(filepath.Join(root, ".bashrc")))
+	is.NoErr(os.Remove(filepath.Join(root, ".vimrc")))
+
+	_, err := run(NewInstallCmd(opts), "", "--yes")
+	is.NoErr(err)
+	// diff-files does not refresh the index so it lists the installed files
+	// unless their stat data was updated.
+	var out bytes.Buffer
+	cmd := g.Cmd("diff-files", "--name-only")
+	cmd.Stdout = &out
+	is.NoErr(cmd.Run())
+	is.Equal(out.String(), "")
+}
+
+func TestBlame(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	is := is.New(t)
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "home")
+	is.NoErr(os.MkdirAll(filepath.Join(root, ".config"), 0755))
+	g := git.New(filepath.Join(tmp, "repo"), root)
+	g.SetPersistentArgs([]string{"-c", "user.name=jane", "-c", "user.email=jane@example.com"})
+	is.NoErr(g.InitBare())
+	opts := &Options{Root: root, ConfigDir: tmp, backend: g}
+	commit := func(content, msg string) {
+		t.Helper()
+		is.NoErr(os.WriteFile(filepath.Join(root, ".config/rc"), []byte(content), 0644))
+		is.NoErr(g.Add(filepath.Join(root, ".config/rc")))
+		is.NoErr(g.Commit(msg))
+	}
+	commit("a\nb\nc\n", "add rc")
+	commit("a\nB\nc\nd", "change b\n\nwith a body")
+	commit("x\na\nB\nc\nd", "prepend x")
+	blame := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		c := NewBlameCmd(opts)
+		c.SetArgs(args)
+		c.SetOut(&out)
+		is.NoErr(c.Execute())
+		return out.String()
+	}
+
+	var exp bytes.Buffer
+	cmd := g.Cmd("blame", "--porcelain", "HEAD", "--", ".config/rc")
+	cmd.Stdout = &exp
+	is.NoErr(cmd.Run())
+	is.Equal(blame("--porcelain", filepath.Join(root, ".config/rc")), exp.String()) // should match git blame
+	lines := strings.Split(blame(filepath.Join(root, ".config/rc")), "\n")
+	is.Equal(len(lines), 6)
+	is.True(strings.HasPrefix(lines[1], "^"))
+	is.True(strings.Contains(lines[1], "(jane "))
+	is.True(strings.HasSuffix(lines[4], " 5) d"))
+
+	c := NewBlameCmd(opts)
+	c.SetArgs([]string{filepath.Join(tmp, "outside")})
+	c.SetOut(&bytes.Buffer{})
+	c.SetErr(&bytes.Buffer{})
+	is.True(c.Execute() != nil)
+}
+
+func TestEncrypt(t *testing.T) {
+	is := is.New(t)
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "home")
+	is.NoErr(os.MkdirAll(filepath.Join(root, ".ssh"), 0700))
+	mem := git.NewMemory(root)
+	opts := &Options{Root: root, ConfigDir: filepath.Join(root, ".config/dots"), backend: mem}
+	config := filepath.Join(root, ".ssh/config")
+	is.NoErr(os.WriteFile(config, []byte("Host example.com\n"), 0600))
+
+	_, err := run(NewAddCmd(opts), "", "--encrypt", config)
+	is.True(err != nil) // no key
+	_, err = run(NewKeyCmd(opts), "", "init")
+	is.NoErr(err)
+	_, err = run(NewKeyCmd(opts), "", "init")
+	is.True(err != nil) // already exists
+	_, err = run(NewAddCmd(opts), "", filepath.Join(root, ".config"))
+	is.True(err != nil) // contains the key
+	_, err = run(NewAddCmd(opts), "", "--encrypt", config)
+	is.NoErr(err)
+	is.Equal(must(mem.LsFiles()), []string{".gitattributes", ".ssh/config"})
+	attrs, err := os.ReadFile(filepath.Join(root, ".gitattributes"))
+	is.NoErr(err)
+	is.Equal(string(attrs), "/.ssh/config filter=dots\n")
+	required, _ := must(mem.Config()).Get("filter.dots.required")
+	is.Equal(required, "true")
+	for f, err := range mem.WalkTree("HEAD") {
+		is.NoErr(err)
+		if f.Path == ".ssh/config" {
+			obj, err := mem.OpenObject(git.Ref(f.Hash.String()))
+			is.NoErr(err)
+			is.True(crypt.IsEncrypted(obj.Data)) // should be stored encrypted
+		}
+	}
+	is.Equal(len(must(mem.Modifications())), 0)
+
+	is.NoErr(os.WriteFile(config, []byte("Host example.org\n"), 0600))
+	out, err := run(NewDiffCmd(opts), "")
+	is.NoErr(err)
+	is.True(strings.Contains(out, "-Host example.com\n+Host example.org\n"))
+	is.NoErr(os.Remove(config))
+	_, err = run(NewInstallCmd(opts), "", "--yes")
+	is.NoErr(err)
+	data, err := os.ReadFile(config)
+	is.NoErr(err)
+	is.Equal(string(data), "Host example.com\n")
+
+	// Move the key to another machine.
+	for _, in := range []string{"\n", ""} {
+		out, err := run(NewKeyCmd(opts), in, "export", "--passphrase")
+		is.True(err != nil) // an empty passphrase should be refused
+		is.True(!crypt.IsWrapped([]byte(out)))
+	}
+	wrapped, err := run(NewKeyCmd(opts), "correct horse\n", "export", "--passphrase")
+	is.NoErr(err)
+	is.True(crypt.IsWrapped([]byte(wrapped)))
+	is.NoErr(os.WriteFile(filepath.Join(tmp, "key"), []byte(wrapped), 0600))
+	other := &Options{Root: root, ConfigDir: filepath.Join(tmp, "other")}
+	_, err = run(NewKeyCmd(other), "wrong\n", "import", filepath.Join(tmp, "key"))
+	is.True(err != nil)
+	_, err = run(NewKeyCmd(other), "correct horse\n", "import", filepath.Join(tmp, "key"))
+	is.NoErr(err)
+	is.True(must(other.readKey()).Equal(must(opts.readKey())))
+	info, err := os.Stat(other.keyFile())
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0600))
+}
+
+func TestTemplate(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	r.write(r.src, map[string]string{
+		".gitconfig.tmpl": "[user]\n\temail = {{ .Data.email }}\n# {{ .OS }}\n",
+	})
+	r.commit("init")
+	opts, dst := r.opts, r.dst
+
+	is.NoErr(os.Mkdir(opts.ConfigDir, 0755))
+	_, err := run(NewInstallCmd(opts), "", "--yes")
+	is.True(err != nil) // data.toml is missing the email
+	is.NoErr(os.WriteFile(opts.dataFile(), []byte("email = \"jane@example.com\"\n"), 0644))
+	_, err = run(NewInstallCmd(opts), "", "--yes")
+	is.NoErr(err)
+	rendered := "[user]\n\temail = jane@example.com\n# " + runtime.GOOS + "\n"
+	is.Equal(r.read(".gitconfig"), rendered)
+	is.True(exists(filepath.Join(dst, ".gitconfig.tmpl")))
+	defer func() {
+		p, err := planUninstall(opts, dst, r.mem.WalkTree("HEAD"), "")
+		is.NoErr(err)
+		is.Equal(p.count(), map[actionKind]int{actRemove: 2})
+		is.NoErr(p.execute(opts, nil, &bytes.Buffer{}, nil, true))
+		is.True(!exists(filepath.Join(dst, ".gitconfig"))) // rendered file should be removed
+	}()
+
+	out, err := run(NewTemplateCmd(opts), "", "render", filepath.Join(dst, ".gitconfig"))
+	is.NoErr(err)
+	is.Equal(out, rendered)
+
+	var warn bytes.Buffer
+	is.NoErr(warnEditedTemplates(opts, &warn))
+	is.Equal(warn.String(), "")
+	is.NoErr(appendfile(filepath.Join(dst, ".gitconfig"), "edited\n"))
+	is.NoErr(warnEditedTemplates(opts, &warn))
+	is.True(strings.HasPrefix(warn.String(), "warning: \""+filepath.Join(dst, ".gitconfig")+"\" was edited"))
+}
+
+func TestAlternates(t *testing.T) {
+	is := is.New(t)
+	env := &templateData{OS: "linux", Arch: "amd64", Hostname: "laptop.lan", User: "jane", Data: map[string]any{"class": "work"}}
+	alts := selectAlternates([]string{
+		".xprofile##os.Linux",
+		".xprofile##os.Linux,hostname.laptop",
+		".xprofile##os.Darwin",
+		".xprofile##default",
+		".gitconfig##class.work",
+		".gitconfig##default",
+		".config/karabiner##os.Darwin/karabiner.json",
+		".config/alacritty##a.x86_64/alacritty.toml",
+		".config/alacritty##default/alacritty.toml",
+		".vimrc##user.bob",
+		".bashrc##unknown.x",
+	}, env)
+	for _, tt := range []struct {
+		path, target string
+	}{
+		{".xprofile##os.Linux,hostname.laptop", ".xprofile"},
+		{".xprofile##os.Linux", ""},
+		{".xprofile##default", ""},
+		{".gitconfig##class.work", ".gitconfig"},
+		{".config/karabiner##os.Darwin/karabiner.json", ""},
+		{".config/alacritty##a.x86_64/alacritty.toml", ".config/alacritty/alacritty.toml"},
+		{".config/alacritty##default/alacritty.toml", ""},
+		{".vimrc##user.bob", ""},
+		{".bashrc##unknown.x", ""},
+		{".bashrc", ""},
+	} {
+		target, _ := alts.target(tt.path)
+		is.Equal(target, tt.target) // wrong alternate
+	}
+	name, err := altName("/home/jane/.xprofile", []string{"os", "h", "class.home"}, env)
+	is.NoErr(err)
+	is.Equal(name, "/home/jane/.xprofile##os.Linux,h.laptop.lan,class.home")
+	_, err = altName("x", []string{"distro"}, env)
+	is.True(err != nil)
+
+	// Files are added from src and installed to dst.
+	r := newTestRepo(t)
+	opts, mem := r.opts, r.mem
+	opts.Root = r.src
+	r.write(r.src, map[string]string{".xprofile": "linux\n"})
+	_, err = run(NewAddCmd(opts), "", "--alt", "os,default", filepath.Join(r.src, ".xprofile"))
+	is.NoErr(err)
+	alt := ".xprofile##os." + unameOS[runtime.GOOS] + ",default"
+	is.Equal(must(mem.LsFiles()), []string{alt})
+	r.write(r.src, map[string]string{".xprofile##os.Plan9": "plan9\n"})
+	is.NoErr(mem.Add(filepath.Join(r.src, ".xprofile##os.Plan9")))
+	is.NoErr(mem.Commit("add plan9"))
+
+	_, err = run(NewInstallCmd(opts), "", "--yes", "--to", r.dst)
+	is.NoErr(err)
+	is.Equal(r.read(".xprofile"), "linux\n")
+	is.True(exists(filepath.Join(r.dst, alt)))
+	p, err := planUninstall(opts, r.dst, mem.WalkTree("HEAD"), "")
+	is.NoErr(err)
+	is.Equal(p.count(), map[actionKind]int{actRemove: 3})
+	is.NoErr(p.execute(opts, nil, &bytes.Buffer{}, nil, true))
+	is.True(!exists(filepath.Join(r.dst, ".xprofile")))
+	is.True(!exists(filepath.Join(r.dst, alt)))
+}
+
+func TestPlan(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	r.write(r.src, map[string]string{
+		".bashrc":               "export A=1\n",
+		".vimrc":                "set number\n",
+		".config/nvim/init.lua": "require('a')\n",
+	})
+	is.NoErr(os.Symlink(".bashrc", filepath.Join(r.src, ".profile")))
+	r.commit("init")
+	r.write(r.dst, map[string]string{
+		".bashrc": "export A=1\n",
+		".vimrc":  "set nonumber\n",
+	})
+	is.NoErr(os.Mkdir(filepath.Join(r.dst, ".config"), 0755))
+	opts, dst := r.opts, r.dst
+	opts.noColor = true
+
+	p, err := planInstall(opts, dst, r.mem.WalkTree("HEAD"), &templateRenderer{opts: opts})
+	is.NoErr(err)
+	var out bytes.Buffer
+	is.NoErr(p.print(&out, false))
+	is.Equal(out.String(), strings.ReplaceAll(`skip      DST/.bashrc
+skip      DST/.config
+mkdir     DST/.config/nvim
+create    DST/.config/nvim/init.lua
+overwrite DST/.vimrc
+symlink   DST/.profile -> .bashrc
+6 actions: 1 create, 1 mkdir, 1 overwrite, 2 skip, 1 symlink
+`, "DST", dst))
+	out.Reset()
+	is.NoErr(p.printJSON(&out))
+	is.True(strings.Contains(out.String(), `"action": "overwrite",`))
+	is.Equal(r.read(".vimrc"), "set nonumber\n") // planning should not write anything
+	is.True(!exists(filepath.Join(dst, ".config/nvim")))
+
+	is.NoErr(p.execute(opts, nil, &out, nil, true))
+	p, err = planInstall(opts, dst, r.mem.WalkTree("HEAD"), &templateRenderer{opts: opts})
+	is.NoErr(err)
+	is.Equal(p.count(), map[actionKind]int{actSkip: 6})
+
+	uninstall, err := run(NewUninstallCmd(opts), "", "--dry-run")
+	is.NoErr(err)
+	is.True(strings.HasSuffix(uninstall, "6 actions: 6 remove\n"))
+	is.True(exists(filepath.Join(dst, ".bashrc")))
+	_, err = run(NewUninstallCmd(opts), "", "--json")
+	is.Equal(err, errJSONWithoutDryRun) // json needs a dry run
+	is.True(exists(filepath.Join(dst, ".bashrc")))
+}
+
+func TestBackup(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	r.write(r.src, map[string]string{
+		".bashrc": "export A=1\n",
+		".vimrc":  "set number\n",
+	})
+	is.NoErr(os.Symlink(".bashrc", filepath.Join(r.src, ".profile")))
+	r.commit("init")
+	is.NoErr(os.MkdirAll(filepath.Join(r.dst, ".profile/dir"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(r.dst, ".vimrc"), []byte("mine\n"), 0600))
+	opts, dst := r.opts, r.dst
+	backup := func(args ...string) string {
+		t.Helper()
+		out, err := run(NewBackupCmd(opts), "", args...)
+		is.NoErr(err)
+		return out
+	}
+
+	// The symlink can't replace a directory so everything is undone.
+	_, err := run(NewInstallCmd(opts), "", "--yes")
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "rolled back"))
+	is.Equal(r.read(".vimrc"), "mine\n")
+	info, err := os.Stat(filepath.Join(dst, ".vimrc"))
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0600))
+	is.True(!exists(filepath.Join(dst, ".bashrc")))
+	is.True(exists(filepath.Join(dst, ".profile/dir")))
+
+	is.NoErr(os.RemoveAll(filepath.Join(dst, ".profile")))
+	_, err = run(NewInstallCmd(opts), "", "--yes")
+	is.NoErr(err)
+	is.Equal(r.read(".vimrc"), "set number\n")
+	backups, err := readBackups(opts)
+	is.NoErr(err)
+	is.Equal(len(backups), 2) // one from the failed install
+	id := backups[0].ID
+	is.True(strings.Contains(backup("list"), id+" "))
+
+	is.NoErr(os.WriteFile(filepath.Join(dst, ".bashrc"), []byte("changed\n"), 0644))
+	is.Equal(backup("restore", id, filepath.Join(dst, ".vimrc")), "restored 1 files from "+id+"\n")
+	is.Equal(r.read(".vimrc"), "mine\n")
+	is.Equal(r.read(".bashrc"), "changed\n") // created by the install so not restored
+
+	_, err = run(NewUninstallCmd(opts), "")
+	is.NoErr(err)
+	is.True(!exists(filepath.Join(dst, ".bashrc")))
+	backups, err = readBackups(opts)
+	is.NoErr(err)
+	is.Equal(backups[0].Command, "uninstall")
+	backup("restore", backups[0].ID)
+	is.Equal(r.read(".bashrc"), "changed\n")
+	target, err := os.Readlink(filepath.Join(dst, ".profile"))
+	is.NoErr(err)
+	is.Equal(target, ".bashrc")
+}
+
+func TestOverwritePrompt(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	for _, name := range []string{".a", ".b", ".c"} {
+		r.write(r.src, map[string]string{name: "one\ntwo\nthree\n"})
+		r.write(r.dst, map[string]string{name: "one\n2\nthree\n"})
+	}
+	r.write(r.src, map[string]string{".same": "same\n"})
+	r.write(r.dst, map[string]string{".same": "same\n"})
+	r.commit("init")
+	opts, dst := r.opts, r.dst
+	opts.noColor = true
+	prompt := func(input string) string {
+		t.Helper()
+		p, err := planInstall(opts, dst, r.mem.WalkTree("HEAD"), &templateRenderer{opts: opts})
+		is.NoErr(err)
+		var out bytes.Buffer
+		is.NoErr(p.execute(opts, strings.NewReader(input), &out, nil, false))
+		is.True(!strings.Contains(out.String(), ".same")) // identical files are skipped without asking
+		return out.String()
+	}
+
+	out := prompt("d\nn\nq\n")
+	is.True(strings.Contains(out, "-2\n+two\n"))
+	is.True(strings.Contains(out, `overwrite "`+filepath.Join(dst, ".b")+`"?`))
+	is.True(strings.Contains(out, "stopped"))
+	is.Equal(r.read(".a"), "one\n2\nthree\n")
+	is.Equal(r.read(".b"), "one\n2\nthree\n")
+
+	// Nothing was installed yet so every difference is a conflict.
+	t.Setenv("DOTS_EDITOR", "true")
+	prompt("m\ny\nn\n")
+	is.Equal(r.read(".a"), "one\n<<<<<<< local\n2\n=======\ntwo\n>>>>>>> dots\nthree\n")
+	is.Equal(r.read(".b"), "one\ntwo\nthree\n")
+	is.Equal(r.read(".c"), "one\n2\nthree\n")
+
+	out = prompt("?\na\n")
+	is.True(strings.Contains(out, "? - print help"))
+	is.Equal(r.read(".a"), "one\ntwo\nthree\n")
+	is.Equal(r.read(".c"), "one\ntwo\nthree\n")
+
+	// Files that were already overwritten are restored when the prompt fails.
+	r.write(dst, map[string]string{".a": "mine\n", ".b": "mine\n"})
+	p, err := planInstall(opts, dst, r.mem.WalkTree("HEAD"), &templateRenderer{opts: opts})
+	is.NoErr(err)
+	in := io.MultiReader(strings.NewReader("y\n"), iotest.ErrReader(io.ErrClosedPipe))
+	err = p.execute(opts, in, &bytes.Buffer{}, nil, false)
+	is.True(errors.Is(err, io.ErrClosedPipe))
+	is.Equal(r.read(".a"), "mine\n") // should be rolled back
+	is.Equal(r.read(".b"), "mine\n")
+
+	// The changes committed since the file was installed are merged with the
+	// local changes.
+	old := must(r.mem.HeadCommit()).Hash
+	r.write(r.src, map[string]string{".a": "one\ntwo\nTHREE\n"})
+	r.commit("three")
+	merge := func(local string) error {
+		t.Helper()
+		p, err := planInstall(opts, dst, r.mem.WalkTree(git.Ref(old.String())), &templateRenderer{opts: opts})
+		is.NoErr(err)
+		is.NoErr(p.execute(opts, nil, &bytes.Buffer{}, nil, true))
+		r.write(dst, map[string]string{".a": local})
+		p, err = planInstall(opts, dst, r.mem.WalkTree("HEAD"), &templateRenderer{opts: opts})
+		is.NoErr(err)
+		return p.execute(opts, strings.NewReader("m\nn\nn\n"), &bytes.Buffer{}, nil, false)
+	}
+	is.NoErr(merge("ONE\ntwo\nthree\n"))
+	is.Equal(r.read(".a"), "ONE\ntwo\nTHREE\n")
+	// The file is restored when the editor cannot resolve the conflicts.
+	t.Setenv("DOTS_EDITOR", "false")
+	err = merge("one\ntwo\n3\n")
+	is.True(err != nil)
+	is.Equal(r.read(".a"), "one\ntwo\n3\n")
+	t.Setenv("DOTS_EDITOR", "true")
+	is.NoErr(merge("one\ntwo\n3\n"))
+	is.Equal(r.read(".a"), "one\ntwo\n<<<<<<< local\n3\n=======\nTHREE\n>>>>>>> dots\n")
+}
+
+func TestSymlinkMode(t *testing.T) {
+	is := is.New(t)
+	r := newTestRepo(t)
+	r.write(r.src, map[string]string{
+		".bashrc":               "export A=1\n",
+		".config/nvim/init.lua": "require('a')\n",
+		".profile.tmpl":         "# {{ .OS }}\n",
+		".xprofile##default":    "x\n",
+		ReadMeName:              "# dotfiles\n",
+	})
+	r.commit("init")
+	r.write(r.dst, map[string]string{".bashrc": "mine\n"})
+	opts, dst, mem := r.opts, r.dst, r.mem
+	checkout := opts.checkoutDir()
+
+	out, err := run(NewInstallCmd(opts), "", "--dry-run", "--mode", "symlink")
+	is.NoErr(err)
+	is.True(strings.HasSuffix(out, "18 actions: 7 create, 5 mkdir, 6 symlink\n"))
+	is.True(!exists(checkout))
+	is.Equal(must(opts.installMode(mem)), modeCopy) // a dry run should not save the mode
+	_, err = run(NewInstallCmd(opts), "", "--yes", "--mode", "symlink")
+	is.NoErr(err)
+	is.Equal(must(opts.installMode(mem)), modeSymlink)
+	target, err := os.Readlink(filepath.Join(dst, ".config/nvim/init.lua"))
+	is.NoErr(err)
+	is.Equal(target, filepath.Join(checkout, ".config/nvim/init.lua"))
+	is.Equal(linkStatus(dst, checkout, ".bashrc"), linkOK)
+	is.True(!exists(filepath.Join(dst, ReadMeName)))
+	is.Equal(opts.Git().WorkingTree(), checkout)
+
+	// Edits through the links change the working tree.
+	is.NoErr(appendfile(filepath.Join(dst, ".bashrc"), "export B=2\n"))
+	is.Equal(must(mem.ModifiedFiles()), []string{".bashrc"})
+	_, err = run(NewUpdateCmd(opts), "", filepath.Join(dst, ".bashrc"))
+	is.NoErr(err)
+	is.Equal(must(mem.HeadCommit()).Message, "[update] .bashrc")
+
+	// New files are moved to the checkout.
+	r.write(dst, map[string]string{".config/nvim/plugins.lua": "return {}\n"})
+	_, err = run(NewAddCmd(opts), "", filepath.Join(dst, ".config/nvim"))
+	is.NoErr(err)
+	is.Equal(must(mem.LsFiles()), []string{".bashrc", ".config/nvim/init.lua", ".config/nvim/plugins.lua", ".profile.tmpl", ".xprofile##default", ReadMeName})
+	is.Equal(linkStatus(dst, checkout, ".config/nvim/plugins.lua"), linkOK)
+
+	// The config directory and the checkout are refused before anything is moved.
+	for _, dir := range []string{opts.ConfigDir, opts.repo(), checkout, filepath.Join(checkout, "..")} {
+		_, err = run(NewAddCmd(opts), "", dir)
+		is.True(err != nil)
+	}
+	is.Equal(linkStatus(dst, checkout, ".bashrc"), linkOK)
+
+	// Files are moved back when they cannot be added.
+	r.write(dst, map[string]string{".inputrc": "set a\n"})
+	_, err = run(NewAddCmd(opts), "", "--encrypt", filepath.Join(dst, ".inputrc"))
+	is.True(err != nil) // there is no key
+	info, err := os.Lstat(filepath.Join(dst, ".inputrc"))
+	is.NoErr(err)
+	is.True(info.Mode().IsRegular())
+	is.True(!exists(filepath.Join(checkout, ".inputrc")))
+	is.Equal(r.read(".inputrc"), "set a\n")
+
+	is.NoErr(os.Remove(filepath.Join(dst, ".bashrc")))
+	is.Equal(linkStatus(dst, checkout, ".bashrc"), linkMissing)
+	is.NoErr(linkMissingFiles(opts, opts.Git()))
+	is.Equal(linkStatus(dst, checkout, ".bashrc"), linkOK)
+
+	// Only the links are removed from the root.
+	is.NoErr(os.Remove(filepath.Join(dst, ".config/nvim/init.lua")))
+	r.write(dst, map[string]string{".config/nvim/init.lua": "local\n"})
+	is.Equal(linkStatus(dst, checkout, ".config/nvim/init.lua"), linkOther)
+	_, err = run(NewUninstallCmd(opts), "")
+	is.NoErr(err)
+	is.True(!exists(filepath.Join(dst, ".bashrc")))
+	is.True(!exists(filepath.Join(dst, ".config/nvim/plugins.lua")))
+	is.True(exists(filepath.Join(dst, ".config/nvim/init.lua")))
+	for _, name := range []string{".profile", ".profile.tmpl", ".xprofile", ".xprofile##default"} {
+		_, err = os.Lstat(filepath.Join(dst, name))
+		is.True(os.IsNotExist(err)) // links to alternates and templates should be removed
+	}
+	is.True(!exists(checkout))
+}
+
+func appendfile(name, content string) error {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(content)
+	return err
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// testRepo is a repository committed from src that is installed to dst.
+type testRepo struct {
+	t        *testing.T
+	is       *is.I
+	src, dst string
+	mem      *git.Memory
+	opts     *Options
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	is := is.New(t)
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "dst")
+	is.NoErr(os.Mkdir(src, 0755))
+	is.NoErr(os.Mkdir(dst, 0755))
+	mem := git.NewMemory(src)
+	is.NoErr(mem.InitBare())
+	return &testRepo{
+		t:    t,
+		is:   is,
+		src:  src,
+		dst:  dst,
+		mem:  mem,
+		opts: &Options{Root: dst, ConfigDir: filepath.Join(tmp, "config"), backend: mem},
+	}
+}
+
+// write writes files to dir, creating their parent directories.
+func (r *testRepo) write(dir string, files map[string]string) {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		r.is.NoErr(os.MkdirAll(filepath.Dir(path), 0755))
+		r.is.NoErr(os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// commit commits everything in src.
+func (r *testRepo) commit(message string) {
+	r.t.Helper()
+	r.is.NoErr(r.mem.Add(r.src))
+	r.is.NoErr(r.mem.Commit(message))
+}
+
+// read reads a file in dst.
+func (r *testRepo) read(name string) string {
+	r.t.Helper()
+	data, err := os.ReadFile(filepath.Join(r.dst, name))
+	r.is.NoErr(err)
+	return string(data)
+}
+
+// run executes a command with some input and returns what it printed.
+func run(c *cobra.Command, in string, args ...string) (string, error) {
+	var out bytes.Buffer
+	c.SetArgs(args)
+	c.SetIn(strings.NewReader(in))
+	c.SetOut(&out)
+	c.SetErr(&out)
+	err := c.Execute()
+	return out.String(), err
+}
@@ -17,7 +17,7 @@ func NewCloneCmd(opts *Options) *cobra.Command {
 		Short: "Clone a remote repository",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			git := opts.Git()
+			git := opts.git()
 			if git.Exists() {
 				return errors.New("git repository already exists here")
 			}
@@ -57,6 +57,12 @@ func clone(opts *Options, git *git.Git, repoSource string) error {
 	if err != nil {
 		return err
 	}
+	// The cloned files may be encrypted so the git binary needs the filter
+	// before anything is checked out or committed.
+	err = configureFilter(opts, git)
+	if err != nil {
+		return err
+	}
 
 	err = writeGitignore(opts)
 	if err != nil {
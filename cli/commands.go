@@ -5,11 +5,13 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/harrybrwn/dots/cli/dotfiles"
+	"github.com/harrybrwn/dots/git"
 	"github.com/harrybrwn/dots/pkg/stdio"
 	"github.com/harrybrwn/dots/tui"
 )
@@ -35,7 +37,7 @@ func NewInitCmd(opts *Options) *cobra.Command {
 			if err := os.Mkdir(opts.repo(), os.FileMode(0775)); err != nil {
 				return err
 			}
-			g := opts.Git()
+			g := opts.git()
 			g.SetErr(cmd.ErrOrStderr())
 			conf, err := g.Config()
 			if err != nil {
@@ -75,7 +77,7 @@ func NewUndoCmd(opts *Options) *cobra.Command {
 		Use:   "undo",
 		Short: "Undo the last add, rm, or update operation.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			g := opts.Git()
+			g := opts.git()
 			err := g.RunCmd("reset", "--soft", "HEAD~1")
 			if err != nil {
 				return err
@@ -90,40 +92,134 @@ func NewUndoCmd(opts *Options) *cobra.Command {
 	return &c
 }
 
-func NewPullCmd(r dotfiles.Repo) *cobra.Command {
+func NewReflogCmd(opts *Options) *cobra.Command {
+	var limit int
 	c := cobra.Command{
-		Use:   "pull",
-		Short: "Download changes from the git repo",
-		Long:  `Download changes from the git repo. Similar to 'git pull'.`,
-		Args:  cobra.NoArgs,
+		Use:     "reflog [ref]",
+		Short:   "Show the history of changes to the repo",
+		Aliases: []string{"history"},
+		Long: `Show the history of changes to the repo with the most recent first. This
+includes changes that are no longer reachable such as the commits removed by
+'dots undo' which can be restored using 'dots git reset <hash>'.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			g := r.Git()
-			g.SetErr(cmd.ErrOrStderr())
-			g.SetOut(cmd.OutOrStdout())
-			return g.Cmd("pull").Run()
+			ref := "HEAD"
+			if len(args) > 0 {
+				ref = args[0]
+			}
+			logs, err := opts.git().Reflog(ref)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return errors.Errorf("no history found for %q", ref)
+				}
+				return err
+			}
+			if limit > 0 && len(logs) > limit {
+				logs = logs[:limit]
+			}
+			tab := NewTable(cmd.OutOrStdout())
+			tab.Head("HASH", "REF", "DATE", "MESSAGE")
+			for i, l := range logs {
+				tab.Add(
+					l.Hash.String()[:7],
+					fmt.Sprintf("%s@{%d}", ref, i),
+					l.TimeStamp.Format(time.DateTime),
+					l.Message,
+				)
+			}
+			return tab.Flush()
 		},
 	}
+	c.Flags().IntVarP(&limit, "number", "n", limit, "limit the number of entries shown")
 	return &c
 }
 
-func NewDiffCmd(r dotfiles.Repo) *cobra.Command {
+func NewSnapshotCmd(opts *Options) *cobra.Command {
+	var (
+		message string
+		force   bool
+		list    bool
+	)
 	c := cobra.Command{
-		Use:   "diff",
-		Short: "Display a diff of the currently tracked files",
-		Long: `Display a diff of the currently tracked files by running 'git diff' under the
-hood.`,
+		Use:   "snapshot [name]",
+		Short: "Tag the current state of the repo",
+		Long: `Tag the current state of the repo so that it can be installed again later
+with 'dots install --rev <name>'. The name defaults to the current date and
+time.`,
+		Example: "  $ dots snapshot laptop-good\n" +
+			"  $ dots install --rev laptop-good\n" +
+			"  $ dots snapshot --list",
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			g := r.Git()
+			g := opts.git()
+			if list {
+				return listSnapshots(cmd, g)
+			}
+			now := time.Now()
+			name := now.Format("snapshot-20060102-150405")
+			if len(args) > 0 {
+				name = args[0]
+			}
+			if len(message) == 0 {
+				message = fmt.Sprintf("dots snapshot %s", name)
+			}
+			id := opts.identity()
+			id.When = now
+			hash, err := g.CreateTag(name, "HEAD", &git.TagOptions{
+				Message: message,
+				Tagger:  id,
+				Force:   force,
+			})
+			if err != nil {
+				return err
+			}
+			cmd.Printf("created snapshot %q (%s)\n", name, hash.String()[:7])
+			cmd.Printf("restore it with 'dots install --rev %s'\n", name)
+			return nil
+		},
+	}
+	f := c.Flags()
+	f.StringVarP(&message, "message", "m", message, "message stored with the snapshot")
+	f.BoolVarP(&force, "force", "f", force, "replace an existing snapshot with the same name")
+	f.BoolVarP(&list, "list", "l", list, "list all snapshots and tags")
+	opts.addUserFlags(f)
+	return &c
+}
+
+func listSnapshots(cmd *cobra.Command, g *git.Git) error {
+	refs, err := g.ListRefs("refs/tags/")
+	if err != nil {
+		return err
+	}
+	tab := NewTable(cmd.OutOrStdout())
+	tab.Head("NAME", "HASH", "DATE", "MESSAGE")
+	for _, r := range refs {
+		var date, message string
+		hash := r.Hash
+		if tag, err := g.OpenTag(r.Hash); err == nil {
+			date = tag.TaggerTime.Format(time.DateTime)
+			message, _, _ = strings.Cut(tag.Message, "\n")
+			hash = r.Peeled
+		}
+		tab.Add(strings.TrimPrefix(r.Name, "refs/tags/"), string(hash)[:7], date, message)
+	}
+	return tab.Flush()
+}
+
+func NewPullCmd(r dotfiles.Repo) *cobra.Command {
+	c := cobra.Command{
+		Use:   "pull",
+		Short: "Download changes from the git repo",
+		Long:  `Download changes from the git repo. Similar to 'git pull'.`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g, err := execGit(r)
+			if err != nil {
+				return err
+			}
 			g.SetErr(cmd.ErrOrStderr())
 			g.SetOut(cmd.OutOrStdout())
-			c := g.Cmd("diff")
-			for _, arg := range args {
-				if strings.HasPrefix("-", arg) {
-					return fmt.Errorf("you're not allowed to pass flags like %q to 'git diff'", arg)
-				}
-				c.Args = append(c.Args, arg)
-			}
-			return c.Run()
+			return g.CmdContext(cmd.Context(), "pull").Run()
 		},
 	}
 	return &c
@@ -134,10 +230,13 @@ func NewStatusCmd(r dotfiles.Repo) *cobra.Command {
 		Use:   "status",
 		Short: "Show the status of files being tracked",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			g := r.Git()
+			g, err := execGit(r)
+			if err != nil {
+				return err
+			}
 			g.SetErr(cmd.ErrOrStderr())
 			g.SetOut(cmd.OutOrStdout())
-			err := g.Cmd(
+			err = g.Cmd(
 				"--no-pager",
 				"-c", "color.status=always",
 				"diff", "--stat",
@@ -154,6 +253,44 @@ func NewStatusCmd(r dotfiles.Repo) *cobra.Command {
 	return c
 }
 
+func NewFsckCmd(opts *Options) *cobra.Command {
+	dangling := true
+	c := cobra.Command{
+		Use:   "fsck",
+		Short: "Check the repository for corruption",
+		Long: `Check the integrity of the dotfiles repository. Every object is hashed and
+compared with its name, the pack files and the index are compared with their
+checksums, and the objects used by refs, reflogs and the index are checked to
+exist. Dangling objects are not referenced by anything and are harmless.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := opts.git().Fsck()
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			problems := 0
+			for _, p := range report.Problems {
+				if p.Kind == git.FsckDangling {
+					if dangling {
+						fmt.Fprintln(out, p.String())
+					}
+					continue
+				}
+				problems++
+				fmt.Fprintln(out, p.String())
+			}
+			if problems > 0 {
+				return errors.Errorf("found %d problems in %d objects", problems, report.Objects)
+			}
+			fmt.Fprintf(out, "checked %d objects, no problems found\n", report.Objects)
+			return nil
+		},
+	}
+	c.Flags().BoolVar(&dangling, "dangling", dangling, "show objects that are not referenced by anything")
+	return &c
+}
+
 func NewTUILogsCmd(cli *Options) *cobra.Command {
 	c := cobra.Command{
 		Use:   "tui-logs",
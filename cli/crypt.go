@@ -0,0 +1,419 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/harrybrwn/dots/crypt"
+	"github.com/harrybrwn/dots/git"
+)
+
+// filterName is the name of the filter used in .gitattributes to mark files
+// as encrypted.
+const filterName = "dots"
+
+func (o *Options) keyFile() string {
+	return filepath.Join(o.ConfigDir, "key")
+}
+
+func (o *Options) readKey() (*crypt.Key, error) {
+	raw, err := os.ReadFile(o.keyFile())
+	if os.IsNotExist(err) {
+		return nil, errors.New("no encryption key, run 'dots key init' or 'dots key import'")
+	} else if err != nil {
+		return nil, err
+	}
+	return crypt.ParseKey(raw)
+}
+
+func (o *Options) writeKey(key *crypt.Key) error {
+	text, err := key.MarshalText()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(o.ConfigDir, 0755); err != nil {
+		return err
+	}
+	if err = os.WriteFile(o.keyFile(), text, 0600); err != nil {
+		return err
+	}
+	return writeGitignore(o)
+}
+
+// keyFilter encrypts files marked with the dots filter when they are
+// committed and decrypts them when they are installed. The key is only read
+// once a file needs it.
+type keyFilter struct {
+	opts *Options
+	key  *crypt.Key
+}
+
+func (f *keyFilter) load() (*crypt.Key, error) {
+	if f.key != nil {
+		return f.key, nil
+	}
+	key, err := f.opts.readKey()
+	if err != nil {
+		return nil, err
+	}
+	f.key = key
+	return key, nil
+}
+
+func (f *keyFilter) Clean(_ string, data []byte) ([]byte, error) {
+	if crypt.IsEncrypted(data) {
+		return data, nil
+	}
+	key, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return key.Encrypt(data), nil
+}
+
+func (f *keyFilter) Smudge(_ string, data []byte) ([]byte, error) {
+	// Files committed before they were marked are not encrypted.
+	if !crypt.IsEncrypted(data) {
+		return data, nil
+	}
+	key, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return key.Decrypt(data)
+}
+
+// filterConfig returns the config that sets the commands the git binary runs
+// for the dots filter.
+func filterConfig(opts *Options) (map[string]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	command := fmt.Sprintf("%s --config %s filter", shellQuote(exe), shellQuote(opts.ConfigDir))
+	return map[string]string{
+		"filter." + filterName + ".clean":    command + " clean %f",
+		"filter." + filterName + ".smudge":   command + " smudge %f",
+		"filter." + filterName + ".required": "true",
+	}, nil
+}
+
+// configureFilter sets the commands that the git binary runs for the dots
+// filter.
+func configureFilter(opts *Options, g git.Backend) error {
+	config, err := filterConfig(opts)
+	if err != nil {
+		return err
+	}
+	for key, value := range config {
+		if err = g.ConfigLocalSet(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFilter configures the filter when the attributes of the working tree
+// mark files with it but the repository config does not run this executable
+// for it, such as after the repository was cloned by hand or dots was moved.
+func checkFilter(opts *Options, g git.Backend) error {
+	if !g.Exists() {
+		return nil
+	}
+	raw, err := os.ReadFile(filepath.Join(g.WorkingTree(), git.AttributesFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	attrs, err := git.ParseAttributes(bytes.NewReader(raw))
+	if err != nil || !attrs.Uses("filter", filterName) {
+		return err
+	}
+	want, err := filterConfig(opts)
+	if err != nil {
+		return err
+	}
+	config, err := g.Config()
+	if err != nil {
+		return err
+	}
+	for key, value := range want {
+		if v, _ := config.Get(key); v != value {
+			return configureFilter(opts, g)
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// encryptFiles marks files as encrypted in the .gitattributes file at the
+// root of the working tree and configures the filter for the git binary. It
+// returns the path of the attributes file.
+func encryptFiles(opts *Options, g git.Backend, files []string) (string, error) {
+	if _, err := opts.readKey(); err != nil {
+		return "", err
+	}
+	if !g.Exists() {
+		if err := g.InitBare(); err != nil {
+			return "", err
+		}
+	}
+	if err := configureFilter(opts, g); err != nil {
+		return "", err
+	}
+	file := filepath.Join(g.WorkingTree(), git.AttributesFile)
+	raw, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	lines := strings.Split(string(raw), "\n")
+	var buf bytes.Buffer
+	buf.Write(raw)
+	if len(raw) > 0 && raw[len(raw)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	for _, f := range files {
+		rel, err := filepath.Rel(g.WorkingTree(), f)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errors.Errorf("%q is outside of %q", f, g.WorkingTree())
+		}
+		pattern := "/" + filepath.ToSlash(rel)
+		if info, err := os.Stat(f); err == nil && info.IsDir() {
+			pattern += "/**"
+		}
+		line := pattern + " filter=" + filterName
+		if !slices.ContainsFunc(lines, func(l string) bool { return strings.TrimSpace(l) == line }) {
+			buf.WriteString(line + "\n")
+			lines = append(lines, line)
+		}
+	}
+	return file, os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+func NewKeyCmd(opts *Options) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "key",
+		Short: "Manage the key used to encrypt files",
+		Long: `Manage the key used to encrypt files. Files added with 'dots add --encrypt'
+are encrypted with this key when they are committed and decrypted when they are
+installed. The key is never committed, copy it to other machines with
+'dots key export --passphrase' and 'dots key import'.`,
+	}
+	c.AddCommand(
+		newKeyInitCmd(opts),
+		newKeyExportCmd(opts),
+		newKeyImportCmd(opts),
+	)
+	return c
+}
+
+func newKeyInitCmd(opts *Options) *cobra.Command {
+	var force bool
+	c := &cobra.Command{
+		Use:   "init",
+		Short: "Generate a new encryption key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if exists(opts.keyFile()) && !force {
+				return errors.Errorf("%q already exists, files encrypted with it could not be decrypted if it was replaced", opts.keyFile())
+			}
+			key, err := crypt.NewKey()
+			if err != nil {
+				return err
+			}
+			if err = opts.writeKey(key); err != nil {
+				return err
+			}
+			cmd.Printf("wrote key to %q\n", opts.keyFile())
+			// Encrypted files that are already tracked need the filter.
+			return errors.Wrap(checkFilter(opts, opts.Git()), "could not configure the filter")
+		},
+	}
+	c.Flags().BoolVarP(&force, "force", "f", force, "replace an existing key")
+	return c
+}
+
+func newKeyExportCmd(opts *Options) *cobra.Command {
+	var (
+		passphrase bool
+		output     string
+	)
+	c := &cobra.Command{
+		Use:   "export",
+		Short: "Print the encryption key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := opts.readKey()
+			if err != nil {
+				return err
+			}
+			var text []byte
+			if passphrase {
+				pass, err := readPassphrase(cmd, "passphrase: ", true)
+				if err != nil {
+					return err
+				}
+				text, err = key.Wrap(pass)
+				if err != nil {
+					return err
+				}
+			} else if text, err = key.MarshalText(); err != nil {
+				return err
+			}
+			if output == "" || output == "-" {
+				_, err = cmd.OutOrStdout().Write(text)
+				return err
+			}
+			return os.WriteFile(output, text, 0600)
+		},
+	}
+	f := c.Flags()
+	f.BoolVarP(&passphrase, "passphrase", "p", passphrase, "encrypt the key with a passphrase")
+	f.StringVarP(&output, "output", "o", output, "write the key to a file")
+	return c
+}
+
+func newKeyImportCmd(opts *Options) *cobra.Command {
+	var force bool
+	c := &cobra.Command{
+		Use:   "import <file|->",
+		Short: "Import an encryption key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				raw []byte
+				err error
+			)
+			if args[0] == "-" {
+				raw, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				raw, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			var key *crypt.Key
+			if crypt.IsWrapped(raw) {
+				pass, err := readPassphrase(cmd, "passphrase: ", false)
+				if err != nil {
+					return err
+				}
+				key, err = crypt.Unwrap(raw, pass)
+				if err != nil {
+					return err
+				}
+			} else if key, err = crypt.ParseKey(raw); err != nil {
+				return err
+			}
+			if old, err := opts.readKey(); err == nil && !old.Equal(key) && !force {
+				return errors.Errorf("a different key already exists at %q", opts.keyFile())
+			}
+			if err = opts.writeKey(key); err != nil {
+				return err
+			}
+			cmd.Printf("wrote key to %q\n", opts.keyFile())
+			// Encrypted files that are already tracked need the filter.
+			return errors.Wrap(checkFilter(opts, opts.Git()), "could not configure the filter")
+		},
+	}
+	c.Flags().BoolVarP(&force, "force", "f", force, "replace an existing key")
+	return c
+}
+
+// readPassphrase reads a passphrase from the terminal without echoing it or
+// reads a line from stdin when it is not a terminal. The passphrase is only
+// confirmed when it is typed into a terminal.
+func readPassphrase(cmd *cobra.Command, prompt string, confirm bool) (string, error) {
+	in := cmd.InOrStdin()
+	f, ok := in.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", errors.Wrap(err, "could not read passphrase")
+		}
+		return checkPassphrase(strings.TrimRight(line, "\r\n"))
+	}
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(cmd.ErrOrStderr(), prompt)
+		b, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(cmd.ErrOrStderr())
+		return string(b), err
+	}
+	pass, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if pass, err = checkPassphrase(pass); err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := read("confirm " + prompt)
+		if err != nil {
+			return "", err
+		}
+		if again != pass {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return pass, nil
+}
+
+func checkPassphrase(pass string) (string, error) {
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return pass, nil
+}
+
+// NewFilterCmd is run by git to encrypt and decrypt files, see
+// gitattributes(5).
+func NewFilterCmd(opts *Options) *cobra.Command {
+	return &cobra.Command{
+		Use:       "filter <clean|smudge> [path]",
+		Short:     "Encrypt or decrypt stdin for git",
+		Hidden:    true,
+		Args:      cobra.RangeArgs(1, 2),
+		ValidArgs: []string{"clean", "smudge"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				f    = keyFilter{opts: opts}
+				path string
+				run  func(string, []byte) ([]byte, error)
+			)
+			switch args[0] {
+			case "clean":
+				run = f.Clean
+			case "smudge":
+				run = f.Smudge
+			default:
+				return errors.Errorf("unknown filter %q", args[0])
+			}
+			if len(args) > 1 {
+				path = args[1]
+			}
+			data, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			if data, err = run(path, data); err != nil {
+				return errors.Wrap(err, path)
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+}
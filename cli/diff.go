@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/harrybrwn/dots/diff"
+	"github.com/harrybrwn/dots/git"
+	"github.com/harrybrwn/dots/pkg/stdio"
+)
+
+func NewDiffCmd(cli CLI) *cobra.Command {
+	var (
+		stat    bool
+		words   bool
+		context = diff.DefaultContext
+	)
+	c := cobra.Command{
+		Use:   "diff [files...]",
+		Short: "Display a diff of the currently tracked files",
+		Long: `Display the changes made to tracked files since they were last added or
+updated. The output is the same as 'git diff'.`,
+		Example: "  $ dots diff\n" +
+			"  $ dots diff --stat\n" +
+			"  $ dots diff --word-diff ~/.bashrc",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			g := cli.Git()
+			paths, err := treePaths(g.WorkingTree(), args)
+			if err != nil {
+				return err
+			}
+			files, err := diffFiles(g, context, paths...)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fd, tty := terminalFd(out)
+			p := diff.Printer{Color: tty && !cli.NoColor(), WordDiff: words}
+			var buf bytes.Buffer
+			if stat {
+				width := 0
+				if tty {
+					width, _, _ = term.GetSize(fd)
+				}
+				err = p.Stat(&buf, width, files...)
+			} else {
+				err = p.Print(&buf, files...)
+			}
+			if err != nil {
+				return err
+			}
+			return pageOutput(out, &buf)
+		},
+		ValidArgsFunction: modifiedCompletionFunc(cli),
+	}
+	f := c.Flags()
+	f.BoolVar(&stat, "stat", stat, "show the number of changed lines in each file")
+	f.BoolVar(&words, "word-diff", words, "show the words that changed instead of whole lines")
+	f.IntVarP(&context, "unified", "U", context, "number of unchanged lines shown around each change")
+	return &c
+}
+
+// diffFiles compares the HEAD commit with the working tree. Paths are slash
+// separated paths relative to the working tree that limit the files compared.
+func diffFiles(g git.Backend, context int, paths ...string) ([]*diff.File, error) {
+	mods, err := g.Modifications()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*diff.File, 0, len(mods))
+	for _, mod := range mods {
+		if len(paths) > 0 && !slices.ContainsFunc(paths, func(p string) bool {
+			return mod.Name == p || strings.HasPrefix(mod.Name, p+"/")
+		}) {
+			continue
+		}
+		var old *diff.Blob
+		if mod.Src.Mode != 0 {
+			obj, err := g.OpenObject(git.Ref(mod.Src.Hash))
+			if err != nil {
+				return nil, err
+			}
+			data := obj.Data
+			if mod.Src.Mode != 0120000 {
+				// Show the decrypted contents of encrypted files.
+				if data, err = g.Smudge(mod.Name, data); err != nil {
+					return nil, err
+				}
+			}
+			old = &diff.Blob{Mode: mod.Src.Mode, Data: data}
+		}
+		new, err := readBlob(filepath.Join(g.WorkingTree(), filepath.FromSlash(mod.Name)))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, diff.NewFile(mod.Name, old, new, context))
+	}
+	return files, nil
+}
+
+// readBlob reads a file the same way that git stores it, symlinks are stored
+// as their target. It returns nil if the file does not exist.
+func readBlob(path string) (*diff.Blob, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.IsDir():
+		return nil, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		return &diff.Blob{Mode: 0120000, Data: []byte(target)}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mode := 0100644
+	if info.Mode().Perm()&0111 != 0 {
+		mode = 0100755
+	}
+	return &diff.Blob{Mode: mode, Data: data}, nil
+}
+
+// treePaths converts command line arguments to paths relative to the working
+// tree. Arguments that are the root of the tree are left out.
+func treePaths(root string, args []string) ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		path := arg
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	return paths, nil
+}
+
+// terminalFd returns the file descriptor of a writer if it is a terminal.
+func terminalFd(w io.Writer) (int, bool) {
+	f, ok := w.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
+
+// pageOutput writes the output through the pager if it does not fit in the
+// terminal.
+func pageOutput(out io.Writer, buf *bytes.Buffer) error {
+	fd, tty := terminalFd(out)
+	pager := stdio.FindPager()
+	if tty && pager != "" {
+		_, height, err := term.GetSize(fd)
+		if err == nil && bytes.Count(buf.Bytes(), []byte{'\n'}) > height {
+			return stdio.Page(pager, out, buf)
+		}
+	}
+	_, err := io.Copy(out, buf)
+	return err
+}
@@ -3,7 +3,7 @@ package dotfiles
 import "github.com/harrybrwn/dots/git"
 
 type Repo interface {
-	Git() *git.Git
+	Git() git.Backend
 }
 
 type ReadmeFlag interface {
@@ -1,12 +1,8 @@
 package cli
 
 import (
-	"archive/tar"
-	"container/list"
-	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -18,83 +14,110 @@ func NewInstallCmd(opts *Options) *cobra.Command {
 	var (
 		yes    bool
 		to     string
+		rev    = "HEAD"
 		dryRun bool
+		asJSON bool
+		mode   string
 	)
 	c := &cobra.Command{
 		Use:   "install [source]",
 		Short: "Copy all of the tracked files to the current root",
 		Long: `Copy all of the tracked files to the current root (will overwrite existing
 files). Also optionally clone from a remove source before installing.
+
+With --mode=symlink the files are checked out to the checkout folder in the
+config directory and the root gets symlinks to them instead, so that files
+edited in place are picked up by 'dots update' and 'dots diff' right away. The
+mode is saved in the repository config and used by later installs.
 `,
 		Aliases: []string{"i"},
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			var (
-				git = opts.Git()
-				c   = git.Cmd("archive", "--format=tar", "HEAD")
-			)
+			if asJSON && !dryRun {
+				return errJSONWithoutDryRun
+			}
+			g := opts.Git()
 			if len(args) > 0 {
-				if git.Exists() {
+				if g.Exists() {
 					return errors.New("git repository already exists here")
 				}
-				err := clone(opts, git, args[0])
-				if err != nil {
+				native, ok := g.(*git.Git)
+				if !ok {
+					return errors.New("cloning is not supported by this repository")
+				}
+				if err = clone(opts, native, args[0]); err != nil {
 					return err
 				}
 			}
-			pipe, err := c.StdoutPipe()
+			current, err := opts.installMode(g)
 			if err != nil {
 				return err
 			}
-			defer pipe.Close()
-			if err = c.Start(); err != nil {
-				return err
+			m := current
+			if len(mode) > 0 {
+				if m, err = parseInstallMode(mode); err != nil {
+					return err
+				}
 			}
-			dest := git.WorkingTree()
+			dest := opts.Root
 			if len(to) > 0 {
 				dest = to
 			}
+			var (
+				templates = templateRenderer{opts: opts}
+				p         *plan
+			)
+			if m == modeSymlink {
+				setWorkingTree(g, opts.checkoutDir())
+				p, err = planLinkedInstall(opts, dest, g.WalkTree(git.Ref(rev)), &templates)
+			} else {
+				setWorkingTree(g, opts.Root)
+				p, err = planInstall(opts, dest, g.WalkTree(git.Ref(rev)), &templates)
+			}
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return p.printJSON(cmd.OutOrStdout())
+			} else if dryRun {
+				return p.print(cmd.OutOrStdout(), !opts.NoColor())
+			}
 
 			defer func() {
-				e := c.Wait()
-				if e != nil && err == nil {
-					err = e
-					return
-				}
-				env := map[string]string{
-					"GIT_CONFIG_NOSYSTEM": "1", // skip the global config
-				}
-				e = git.RunCmdWithEnv(env, "restore", "--staged", opts.Root)
-				if e != nil && err == nil {
+				if e := resetIndex(opts, g); e != nil && err == nil {
 					err = e
-					return
-				}
-				if opts.HasReadme() {
-					e = restoreReadMe(git)
-					if e != nil && err == nil {
-						err = errors.Wrap(e, "failed to restore repo's base README.md")
-						return
-					}
-				}
-				e = git.RunCmdWithEnv(env, "update-index", "--refresh")
-				if e != nil && err == nil {
-					err = errors.Wrap(err, "failed to refresh index")
 				}
 			}()
 			cmd.Printf("installing to %q\n", dest)
-			err = install(opts, dest, tar.NewReader(pipe), yes)
+			err = p.execute(opts, cmd.InOrStdin(), cmd.OutOrStdout(), &templates, yes)
+			if e := templates.close(); e != nil && err == nil {
+				err = errors.Wrap(e, "could not save rendered templates")
+			}
 			if err != nil {
 				return err
 			}
-			branch, err := git.CurrentBranch()
+			// Repositories that were cloned by hand get the filter once the
+			// attributes are installed.
+			if err = checkFilter(opts, g); err != nil {
+				return errors.Wrap(err, "could not configure the filter")
+			}
+			if m != current {
+				if err = g.ConfigLocalSet(installModeKey, string(m)); err != nil {
+					return err
+				}
+				if err = writeGitignore(opts); err != nil {
+					return err
+				}
+			}
+			branch, err := g.CurrentBranch()
 			if err != nil {
 				return err
 			}
-			head, err := git.Head()
+			head, err := g.Head()
 			if err != nil {
 				return err
 			}
 			// Create remote ref so that it shows up in git logs.
-			err = git.CreateRemoteRef("origin", branch, head)
+			err = g.CreateRemoteRef("origin", branch, head)
 			if err != nil {
 				return err
 			}
@@ -104,109 +127,63 @@ files). Also optionally clone from a remove source before installing.
 	f := c.Flags()
 	f.BoolVarP(&yes, "yes", "y", yes, "set all yes-or-no prompts to yes")
 	f.StringVar(&to, "to", "", "install to an alternate location")
-	f.BoolVar(&dryRun, "dry-run", dryRun, "run the install without writing anything to disk")
+	f.StringVar(&rev, "rev", rev, "install the files from a commit, branch or tag")
+	f.BoolVar(&dryRun, "dry-run", dryRun, "print the changes that would be made without writing anything to disk")
+	f.BoolVar(&asJSON, "json", asJSON, "print the changes as json, requires --dry-run")
+	f.StringVar(&mode, "mode", mode, `install by "copy" or "symlink", defaults to the mode of the last install`)
 	return c
 }
 
-type link struct {
-	sym bool
-	dst string
-	src string
-}
-
-func install(opts *Options, dest string, archive *tar.Reader, yes bool) error {
-	symlinks := list.New()
-	log := opts.log()
-	for {
-		header, err := archive.Next()
-		switch err {
-		case nil:
-		case io.EOF:
-			goto finish
-		default:
-			return errors.Wrap(err, "could not get next tar header")
-		}
-		p := filepath.Join(dest, header.Name)
-		if rel, err := filepath.Rel(opts.Root, p); err == nil && rel == ReadMeName {
-			p = filepath.Join(opts.ConfigDir, ReadMeName)
-		}
-		if !yes && existsAndIsNotDir(p) {
-			if !yesOrNo(
-				os.Stdin, os.Stdout,
-				fmt.Sprintf("would you like to overwrite %q", p),
-			) {
-				continue
-			}
+// resetIndex unstages the installed files and refreshes the stat data of the
+// index so that the rewritten files are not reported as changed. Only the
+// index of the git binary needs to be reset.
+func resetIndex(opts *Options, b git.Backend) error {
+	g, ok := b.(*git.Git)
+	if !ok {
+		return nil
+	}
+	env := map[string]string{
+		"GIT_CONFIG_NOSYSTEM": "1", // skip the global config
+	}
+	if err := g.RunCmdWithEnv(env, "restore", "--staged", g.WorkingTree()); err != nil {
+		return err
+	}
+	if opts.HasReadme() {
+		if err := restoreReadMe(g); err != nil {
+			return errors.Wrap(err, "failed to restore repo's base README.md")
 		}
-		perm := header.FileInfo().Mode().Perm()
+	}
+	if err := g.RefreshIndex(); err != nil {
+		return errors.Wrap(err, "failed to refresh index")
+	}
+	return nil
+}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			err = os.MkdirAll(p, perm)
-			if err != nil {
-				if os.IsExist(err) {
-					continue
-				}
-				return errors.Wrap(err, "could not create directory")
-			}
-			log("created directory %q", p)
-		case tar.TypeReg:
-			f, err := os.OpenFile(p, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, perm)
-			if err != nil {
-				return err
-			}
-			_, err = io.Copy(f, archive)
-			if err != nil {
-				f.Close()
-				return errors.Wrap(err, "failed to copy file")
-			}
-			if err = f.Close(); err != nil {
-				return errors.Wrap(err, "failed to close file")
-			}
-			log("wrote file %q", p)
-		case tar.TypeSymlink:
-			l := link{
-				sym: true,
-				src: p,
-				dst: header.Linkname,
-			}
-			symlinks.PushBack(l)
-		case tar.TypeLink:
-			l := link{
-				src: p,
-				dst: header.Linkname,
-			}
-			symlinks.PushBack(l)
-		case tar.TypeBlock:
-			return errors.New("cannot handle file type 'block'")
-		case tar.TypeFifo:
-			return errors.New("cannot handle file type 'fifo'")
-		}
+func writeTreeFile(p string, file *git.TreeFile, perm os.FileMode) error {
+	r, err := file.Open()
+	if err != nil {
+		return err
 	}
+	defer r.Close()
+	return writeFile(p, r, perm)
+}
 
-finish:
-	var err error
-	for symlinks.Len() > 0 {
-		l := symlinks.Remove(symlinks.Front()).(link)
-		base, lnname := filepath.Split(l.src)
-		ln, msg := os.Link, "link"
-		if l.sym {
-			ln = os.Symlink
-			msg = "symlink"
-		}
-		e := changeDir(base, func() error {
-			return ln(l.dst, lnname)
-		})
-		if e != nil {
-			if err == nil {
-				err = errors.Wrap(e, "could not create symbolic link")
-			}
-			fmt.Fprintf(os.Stderr, "error: failed to create %s %q -> %q\n", msg, l.src, l.dst)
-			continue
-		}
-		log("created %s %q -> %q", msg, l.src, l.dst)
+func writeFile(p string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(p, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to copy file")
 	}
-	return err
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	// OpenFile does not change the mode of existing files and is subject to
+	// the umask.
+	return os.Chmod(p, perm)
 }
 
 func restoreReadMe(g *git.Git) error {
@@ -227,26 +204,3 @@ func restoreReadMe(g *git.Git) error {
 	}
 	return nil
 }
-
-func changeDir(dst string, fn func() error) (err error) {
-	var prev string
-	prev, err = os.Getwd()
-	if err != nil {
-		return err
-	}
-	err = os.Chdir(dst)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		e := os.Chdir(prev)
-		if e != nil && err == nil {
-			err = e
-		}
-	}()
-	err = fn()
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,307 @@
+package cli
+
+import (
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/harrybrwn/dots/git"
+)
+
+// installModeKey is the repository config key that stores how the tracked
+// files were installed.
+const installModeKey = "dots.installmode"
+
+type installMode string
+
+const (
+	// modeCopy copies the tracked files to the root.
+	modeCopy installMode = "copy"
+	// modeSymlink checks the tracked files out to a directory in the config
+	// directory and links to them from the root like GNU stow. Files edited
+	// in place are then changed in the working tree right away.
+	modeSymlink installMode = "symlink"
+)
+
+func parseInstallMode(s string) (installMode, error) {
+	switch mode := installMode(s); mode {
+	case modeCopy, modeSymlink:
+		return mode, nil
+	}
+	return "", errors.Errorf("unknown install mode %q, expected %q or %q", s, modeCopy, modeSymlink)
+}
+
+// checkoutDir is the working tree of repositories installed with symlinks.
+func (o *Options) checkoutDir() string {
+	return filepath.Join(o.ConfigDir, "checkout")
+}
+
+// installMode reads the install mode from the repository config.
+func (o *Options) installMode(g git.Backend) (installMode, error) {
+	c, err := g.Config()
+	if err != nil {
+		return modeCopy, err
+	}
+	value, ok := c.Get(installModeKey)
+	if !ok {
+		return modeCopy, nil
+	}
+	return parseInstallMode(value)
+}
+
+// useCheckout makes the checkout the working tree of repositories that were
+// installed with symlinks.
+func (o *Options) useCheckout(g git.Backend) {
+	if mode, err := o.installMode(g); err != nil || mode != modeSymlink {
+		return
+	}
+	setWorkingTree(g, o.checkoutDir())
+}
+
+// setWorkingTree changes the working tree of backends that support it.
+func setWorkingTree(g git.Backend, dir string) {
+	if wt, ok := g.(interface{ SetWorkingTree(string) }); ok {
+		wt.SetWorkingTree(dir)
+	}
+}
+
+// checkoutPaths converts absolute paths in the root to the same paths in the
+// checkout when it is the working tree.
+func (o *Options) checkoutPaths(g git.Backend, paths []string) {
+	checkout := o.checkoutDir()
+	if g.WorkingTree() != checkout {
+		return
+	}
+	for i, p := range paths {
+		if p == checkout || dirContainsPath(checkout, p) {
+			continue
+		}
+		if rel, err := filepath.Rel(o.Root, p); err == nil && !isOutside(rel) {
+			paths[i] = filepath.Join(checkout, rel)
+		}
+	}
+}
+
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// linksInto returns true if path is a symlink to a file in dir.
+func linksInto(path, dir string) bool {
+	target, err := os.Readlink(path)
+	return err == nil && dirContainsPath(dir, target)
+}
+
+// planLinkedInstall finds the changes needed to check the files of a tree out
+// to the checkout and link to them from dest.
+func planLinkedInstall(opts *Options, dest string, files iter.Seq2[*git.TreeFile, error], templates *templateRenderer) (*plan, error) {
+	checkout := opts.checkoutDir()
+	p, err := planInstall(opts, checkout, files, templates)
+	if err != nil {
+		return nil, err
+	}
+	root := action{Kind: actMkdir, Path: checkout, dir: true}
+	if info, err := os.Stat(checkout); err == nil && info.IsDir() {
+		root.Kind = actSkip
+	}
+	p.Actions = append([]*action{&root}, p.Actions...)
+	p.link(checkout, dest)
+	return p, nil
+}
+
+// link adds the directories and symlinks in root that point at the files
+// written to the checkout by the plan.
+func (p *plan) link(checkout, root string) {
+	links := make([]*action, 0)
+	for _, a := range p.Actions {
+		rel, err := filepath.Rel(checkout, a.Path)
+		// The README is left in the checkout instead of the root.
+		if err != nil || rel == "." || rel == ReadMeName || isOutside(rel) {
+			continue
+		}
+		l := action{Path: filepath.Join(root, rel), Source: a.Source, file: a.file}
+		if a.dir {
+			l.Kind, l.dir = actMkdir, true
+			if info, err := os.Stat(l.Path); err == nil && info.IsDir() {
+				l.Kind = actSkip
+			}
+			links = append(links, &l)
+			continue
+		}
+		l.Kind, l.Target = actSymlink, a.Path
+		if target, err := os.Readlink(l.Path); err == nil && target == a.Path {
+			l.Kind = actSkip
+		} else if _, err = os.Lstat(l.Path); err == nil {
+			l.replace = true
+		}
+		links = append(links, &l)
+	}
+	p.Actions = append(p.Actions, links...)
+}
+
+// planUnlink finds the changes needed to uninstall a tree that was installed
+// with symlinks. Only the symlinks that point at the checkout are removed from
+// the root, including the links to alternates and rendered templates.
+func planUnlink(opts *Options, g git.Backend) (*plan, error) {
+	checkout := opts.checkoutDir()
+	p, err := planUninstall(opts, opts.Root, g.WalkTree("HEAD"), checkout)
+	if err != nil {
+		return nil, err
+	}
+	files, err := planUninstall(opts, checkout, g.WalkTree("HEAD"), "")
+	if err != nil {
+		return nil, err
+	}
+	p.Actions = append(p.Actions, files.Actions...)
+	root := action{Kind: actRemove, Path: checkout, dir: true}
+	if !exists(checkout) {
+		root.Kind = actSkip
+	}
+	p.add(&root)
+	return p, nil
+}
+
+type linkState string
+
+const (
+	linkOK      linkState = "linked"
+	linkMissing linkState = "missing"
+	// linkOther means that the file in the root is not a link to the checkout.
+	linkOther linkState = "not linked"
+)
+
+// linkStatus checks that the file in the root links to the checkout for a
+// slash separated path in the tree.
+func linkStatus(root, checkout, name string) linkState {
+	path := filepath.Join(root, filepath.FromSlash(name))
+	target, err := os.Readlink(path)
+	switch {
+	case err == nil && target == filepath.Join(checkout, filepath.FromSlash(name)):
+		return linkOK
+	case errors.Is(err, fs.ErrNotExist):
+		return linkMissing
+	}
+	return linkOther
+}
+
+// linkMissingFiles links the tracked files that are missing from the root to
+// the checkout, such as files that were just pulled.
+func linkMissingFiles(opts *Options, g git.Backend) error {
+	checkout := opts.checkoutDir()
+	if g.WorkingTree() != checkout {
+		return nil
+	}
+	files, err := g.LsFiles()
+	if err != nil {
+		return err
+	}
+	log := opts.log()
+	for _, name := range files {
+		if name == ReadMeName || linkStatus(opts.Root, checkout, name) != linkMissing {
+			continue
+		}
+		path := filepath.Join(opts.Root, filepath.FromSlash(name))
+		target := filepath.Join(checkout, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err = os.Symlink(target, path); err != nil {
+			return errors.Wrapf(err, "could not link %q", path)
+		}
+		log("created symlink %q -> %q", path, target)
+	}
+	return nil
+}
+
+// adopted is a file that was moved from the root to the checkout.
+type adopted struct {
+	path, moved string
+}
+
+// adoptFiles moves files in the root to the checkout and replaces them with
+// symlinks so that they can be added to the working tree. The paths are
+// replaced with their paths in the checkout. It returns the files that were
+// moved so that they can be moved back with [unadoptFiles]. Nothing is moved
+// if it fails.
+func adoptFiles(opts *Options, g git.Backend, files []string) (moved []adopted, err error) {
+	checkout := opts.checkoutDir()
+	if g.WorkingTree() != checkout {
+		return nil, nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if e := unadoptFiles(moved); e != nil {
+			err = errors.Wrapf(err, "%v, could not move the files back", e)
+		}
+		moved = nil
+	}()
+	for i, f := range files {
+		if f == checkout || dirContainsPath(checkout, f) {
+			continue
+		}
+		rel, err := filepath.Rel(opts.Root, f)
+		if err != nil || isOutside(rel) {
+			return moved, errors.Errorf("%q is outside of %q", f, opts.Root)
+		}
+		err = filepath.WalkDir(f, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(opts.Root, p)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(checkout, rel)
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			switch {
+			case d.IsDir():
+				return os.MkdirAll(dst, info.Mode().Perm())
+			case linksInto(p, checkout):
+				return nil // already installed
+			}
+			if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			if err = copyPath(p, dst); err != nil {
+				return err
+			}
+			if err = os.Remove(p); err != nil {
+				return err
+			}
+			moved = append(moved, adopted{path: p, moved: dst})
+			return os.Symlink(dst, p)
+		})
+		if err != nil {
+			return moved, errors.Wrapf(err, "could not move %q to %q", f, checkout)
+		}
+		files[i] = filepath.Join(checkout, rel)
+	}
+	return moved, nil
+}
+
+// unadoptFiles moves files back from the checkout to the root, the latest
+// first.
+func unadoptFiles(moved []adopted) error {
+	for _, m := range slices.Backward(moved) {
+		if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := copyPath(m.moved, m.path); err != nil {
+			return err
+		}
+		if err := os.Remove(m.moved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,12 +6,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 
 	"github.com/harrybrwn/dots/cli/dotfiles"
+	"github.com/harrybrwn/dots/diff"
 	"github.com/harrybrwn/dots/git"
 	"github.com/harrybrwn/dots/pkg/stdio"
 	"github.com/harrybrwn/dots/tree"
@@ -29,6 +31,28 @@ type lsFlags struct {
 	noPager    bool
 	untracked  bool
 	changed    bool
+	// alts are the selected alternates of the tracked files.
+	alts alternates
+	// root and checkout are set when the files are installed as symlinks.
+	root, checkout string
+}
+
+// altSuffix shows the file that an alternate is installed to if it was
+// selected for this machine.
+func (f *lsFlags) altSuffix(path string) string {
+	target, ok := f.alts.target(path)
+	if !ok {
+		return ""
+	}
+	return " -> " + target
+}
+
+// linkSuffix shows whether a file in the root links to the checkout.
+func (f *lsFlags) linkSuffix(path string) string {
+	if f.checkout == "" {
+		return ""
+	}
+	return " [" + string(linkStatus(f.root, f.checkout, path)) + "]"
 }
 
 func NewLSCmd(cli *Options) *cobra.Command {
@@ -47,6 +71,10 @@ func NewLSCmd(cli *Options) *cobra.Command {
 				if len(args) > 0 {
 					dir = args[0]
 				}
+				g, err := execGit(cli)
+				if err != nil {
+					return err
+				}
 				return untracked(
 					cmd.OutOrStdout(),
 					g,
@@ -60,26 +88,21 @@ func NewLSCmd(cli *Options) *cobra.Command {
 				return err
 			}
 			tr := tree.New(files)
+			if flags.alts, err = activeAlternates(cli, files); err != nil {
+				return err
+			}
+			if g.WorkingTree() == cli.checkoutDir() {
+				flags.root, flags.checkout = cli.Root, cli.checkoutDir()
+			}
 
 			if len(args) > 0 {
-				cwd, err := os.Getwd()
-				if err != nil {
+				if err = cleanPaths(args); err != nil {
 					return err
 				}
-				filter := make([]string, 0)
-				for _, arg := range args {
-					path := arg
-					if !filepath.IsAbs(path) {
-						path = filepath.Join(cwd, path)
-					}
-					rel, err := filepath.Rel(g.WorkingTree(), path)
-					if err != nil {
-						return err
-					}
-					if rel == "." {
-						continue
-					}
-					filter = append(filter, rel)
+				cli.checkoutPaths(g, args)
+				filter, err := treePaths(g.WorkingTree(), args)
+				if err != nil {
+					return err
 				}
 				tr = tr.FilterBy(filter...)
 			}
@@ -106,7 +129,13 @@ func NewLSCmd(cli *Options) *cobra.Command {
 			return tui.Run(
 				cmd.Context(),
 				tree,
-				tui.NewExecPreview(g.Cmd("-c", "delta.paging=always", "diff"), mods),
+				tui.NewDiffPreview(func(path string) (*diff.File, error) {
+					files, err := diffFiles(g, diff.DefaultContext, path)
+					if err != nil || len(files) == 0 {
+						return nil, err
+					}
+					return files[0], nil
+				}, mods),
 			)
 		},
 		ValidArgsFunction: lsCompletionFunc(cli),
@@ -129,9 +158,28 @@ func listTree(out io.Writer, tr *tree.Node, mods modSet, flags *lsFlags) error {
 		fmt.Fprintf(os.Stderr, "Could not get terminal size: %v\n", err)
 		return err
 	}
-	fn := mods.treeColor
+	color := mods.treeColor
 	if flags.NoColor() {
-		fn = mods.treeNoColor
+		color = mods.treeNoColor
+	}
+	fn := func(n *tree.Node) string {
+		prefix := color(n)
+		if n.Type != tree.LeafNode || prefix != "" {
+			return prefix
+		}
+		name := filepath.Join(n.Path(), n.Name)[1:]
+		if flags.checkout != "" && linkStatus(flags.root, flags.checkout, name) != linkOK {
+			if flags.NoColor() {
+				return "! "
+			}
+			return "\x1b[01;31m! \x1b[0m"
+		}
+		if _, ok := flags.alts.target(name); !ok {
+			return prefix
+		} else if flags.NoColor() {
+			return "* "
+		}
+		return "\x1b[01;36m* \x1b[0m"
 	}
 	pager := stdio.FindPager()
 	if pager == "" {
@@ -157,7 +205,7 @@ func listFlat(out io.Writer, files []string, flags *lsFlags) error {
 		if f[0] == '/' {
 			f = f[1:]
 		}
-		fmt.Fprintf(&buf, "%s\n", f)
+		fmt.Fprintf(&buf, "%s%s%s\n", f, flags.altSuffix(f), flags.linkSuffix(f))
 	}
 	pager := stdio.FindPager()
 	if pager == "" {
@@ -260,7 +308,19 @@ func lsCompletionFunc(
 	}
 }
 
-func modifiedSet(g *git.Git) (modSet, error) {
+// activeAlternates selects the alternates of the tracked files.
+func activeAlternates(opts *Options, files []string) (alternates, error) {
+	if !slices.ContainsFunc(files, func(f string) bool { return strings.Contains(f, altSep) }) {
+		return nil, nil
+	}
+	env, err := opts.templateData()
+	if err != nil {
+		return nil, err
+	}
+	return selectAlternates(files, env), nil
+}
+
+func modifiedSet(g git.Backend) (modSet, error) {
 	m := make(modSet)
 	files, err := g.Modifications()
 	if err != nil {
@@ -0,0 +1,479 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/harrybrwn/dots/git"
+)
+
+// actionKind is a change that a plan makes to a file.
+type actionKind string
+
+const (
+	actCreate    actionKind = "create"
+	actOverwrite actionKind = "overwrite"
+	actSkip      actionKind = "skip" // the file is already identical
+	actSymlink   actionKind = "symlink"
+	actMkdir     actionKind = "mkdir"
+	actRemove    actionKind = "remove"
+)
+
+var actionColors = map[actionKind]int{
+	actCreate:    32,
+	actOverwrite: 33,
+	actSkip:      90,
+	actSymlink:   36,
+	actMkdir:     34,
+	actRemove:    31,
+}
+
+// action is a single change to a file on disk.
+type action struct {
+	Kind actionKind `json:"action"`
+	Path string     `json:"path"`
+	// Source is the path of the file in the repository. It is different from
+	// the base of Path for alternates, templates and the README.
+	Source string `json:"source,omitempty"`
+	// Target is the target of a symlink.
+	Target string      `json:"target,omitempty"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+	// Template is true for files rendered from a template.
+	Template bool `json:"template,omitempty"`
+
+	file    *git.TreeFile
+	data    []byte // rendered template
+	dir     bool
+	replace bool // the existing file is removed first
+}
+
+// plan is the list of changes made by install or uninstall so that they can
+// be shown before anything is written.
+type plan struct {
+	Actions []*action `json:"actions"`
+
+	op string // the command making the changes
+}
+
+func (p *plan) add(a *action) { p.Actions = append(p.Actions, a) }
+
+// count returns the number of actions of each kind.
+func (p *plan) count() map[actionKind]int {
+	counts := make(map[actionKind]int)
+	for _, a := range p.Actions {
+		counts[a.Kind]++
+	}
+	return counts
+}
+
+// print writes the plan as a list of actions followed by a summary.
+func (p *plan) print(w io.Writer, color bool) error {
+	var buf bytes.Buffer
+	for _, a := range p.Actions {
+		name := fmt.Sprintf("%-9s", a.Kind)
+		if color {
+			name = fmt.Sprintf("\x1b[01;%dm%s\x1b[0m", actionColors[a.Kind], name)
+		}
+		fmt.Fprintf(&buf, "%s %s", name, a.Path)
+		if a.Kind == actSymlink {
+			fmt.Fprintf(&buf, " -> %s", a.Target)
+		}
+		if a.Template {
+			fmt.Fprintf(&buf, " (rendered from %s)", a.Source)
+		}
+		buf.WriteByte('\n')
+	}
+	counts := p.count()
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(&buf, "%d actions", len(p.Actions))
+	for i, kind := range kinds {
+		if i == 0 {
+			buf.WriteString(": ")
+		} else {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%d %s", counts[actionKind(kind)], kind)
+	}
+	buf.WriteByte('\n')
+	_, err := io.Copy(w, &buf)
+	return err
+}
+
+// errJSONWithoutDryRun is returned when the plan is printed as json but would
+// also be executed, which would mix prompts and logs into the json.
+var errJSONWithoutDryRun = errors.New("--json can only be used with --dry-run")
+
+func (p *plan) printJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// planInstall finds the changes needed to install the files of a tree to
+// dest.
+func planInstall(opts *Options, dest string, files iter.Seq2[*git.TreeFile, error], templates *templateRenderer) (*plan, error) {
+	alts, all, err := collectAlternates(opts, files)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		p        = plan{op: "install"}
+		symlinks = make([]*action, 0)
+	)
+	add := func(file *git.TreeFile, path string) error {
+		a := action{Path: path, Source: file.Path, file: file}
+		switch {
+		case file.IsDir():
+			a.Kind, a.dir = actMkdir, true
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				a.Kind = actSkip
+			}
+			p.add(&a)
+			return nil
+		case file.IsSymlink():
+			a.Kind, a.Target = actSymlink, file.Target
+			if target, err := os.Readlink(path); err == nil && target == file.Target {
+				a.Kind = actSkip
+			} else if _, err = os.Lstat(path); err == nil {
+				a.replace = true
+			}
+			symlinks = append(symlinks, &a)
+			return nil
+		}
+		a.Mode = file.FileMode().Perm()
+		kind, err := fileAction(path, file, a.Mode)
+		if err != nil {
+			return err
+		}
+		a.Kind = kind
+		a.replace = linksInto(path, opts.checkoutDir())
+		p.add(&a)
+
+		target, ok := templateTarget(path)
+		if !ok {
+			return nil
+		}
+		data, err := readTreeFile(file)
+		if err != nil {
+			return err
+		}
+		out, err := templates.render(file.Path, data)
+		if err != nil {
+			return err
+		}
+		p.add(&action{
+			Kind:     dataAction(target, out, a.Mode),
+			Path:     target,
+			Source:   file.Path,
+			Mode:     a.Mode,
+			Template: true,
+			data:     out,
+			replace:  linksInto(target, opts.checkoutDir()),
+		})
+		return nil
+	}
+	for _, file := range all {
+		path := filepath.Join(dest, filepath.FromSlash(file.Path))
+		if rel, err := filepath.Rel(opts.Root, path); err == nil && rel == ReadMeName {
+			path = filepath.Join(opts.ConfigDir, ReadMeName)
+		}
+		if err = add(file, path); err != nil {
+			return nil, err
+		}
+		// The selected alternate is also installed under the plain name.
+		if target, ok := alts.target(file.Path); ok {
+			if err = add(file, filepath.Join(dest, filepath.FromSlash(target))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Symlinks are created last so that their targets exist.
+	p.Actions = append(p.Actions, symlinks...)
+	return &p, nil
+}
+
+// fileAction returns the action that installs a file from a tree. Files are
+// hashed like git hashes blobs so that identical files are found without
+// reading the blob. The blob is only compared with the file when the hashes
+// are different since filters may change the contents of the blob.
+func fileAction(path string, file *git.TreeFile, perm os.FileMode) (actionKind, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return actCreate, nil
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != perm {
+		return actOverwrite, nil
+	}
+	if sameBlob(path, file.Hash) {
+		return actSkip, nil
+	}
+	data, err := readTreeFile(file)
+	if err != nil {
+		return "", err
+	}
+	return dataAction(path, data, perm), nil
+}
+
+// sameBlob returns true if a file hashes to a SHA-1 blob hash.
+func sameBlob(path string, hash git.Hash) bool {
+	if len(hash) != 20 {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	obj, err := git.NewObjectFromFile(f)
+	return err == nil && obj.Hash == hash.String()
+}
+
+func readTreeFile(file *git.TreeFile) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// dataAction returns the action that writes data to a file.
+func dataAction(path string, data []byte, perm os.FileMode) actionKind {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return actCreate
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != perm {
+		return actOverwrite
+	}
+	current, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(current, data) {
+		return actOverwrite
+	}
+	return actSkip
+}
+
+// planUninstall finds the files to remove when uninstalling a tree from
+// root. The plain names of the selected alternates and the files rendered from
+// templates are removed along with the tracked files. Directories are removed
+// last and only if they are empty. If checkout is set, only the files that
+// link to the same file in checkout are removed.
+func planUninstall(opts *Options, root string, files iter.Seq2[*git.TreeFile, error], checkout string) (*plan, error) {
+	alts, all, err := collectAlternates(opts, files)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		p    = plan{op: "uninstall"}
+		dirs = make([]*action, 0)
+		seen = make(map[string]bool)
+	)
+	remove := func(file *git.TreeFile, name string) error {
+		a := action{Kind: actRemove, Path: filepath.Join(root, filepath.FromSlash(name)), Source: file.Path, file: file}
+		if seen[a.Path] {
+			return nil
+		}
+		seen[a.Path] = true
+		info, err := os.Lstat(a.Path)
+		if os.IsNotExist(err) {
+			a.Kind = actSkip
+		} else if err != nil {
+			return err
+		}
+		if file.IsDir() || (info != nil && info.IsDir()) {
+			a.dir = true
+			dirs = append(dirs, &a)
+			return nil
+		}
+		if checkout != "" && linkStatus(root, checkout, name) != linkOK {
+			a.Kind = actSkip
+		}
+		p.add(&a)
+		return nil
+	}
+	for _, file := range all {
+		names := []string{file.Path}
+		if target, ok := alts.target(file.Path); ok {
+			names = append(names, target)
+		}
+		for _, name := range names {
+			if err = remove(file, name); err != nil {
+				return nil, err
+			}
+			if target, ok := templateTarget(name); ok && !file.IsDir() {
+				if err = remove(file, target); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// Sorted so that child directories are removed before parents
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path > dirs[j].Path })
+	p.Actions = append(p.Actions, dirs...)
+	return &p, nil
+}
+
+// execute applies the plan. Overwriting files asks for confirmation unless
+// yes is true. Every file that is changed is saved to a backup first and all
+// the changes are rolled back if one of them fails.
+func (p *plan) execute(opts *Options, in io.Reader, out io.Writer, templates *templateRenderer, yes bool) error {
+	var (
+		log    = opts.log()
+		b      = newBackup(opts, p.op)
+		_, tty = terminalFd(out)
+		prompt = overwritePrompt{in: bufio.NewReader(in), out: out, color: tty && !opts.NoColor(), all: yes}
+	)
+	installed, err := opts.readInstalled()
+	if err != nil {
+		return err
+	}
+	// fail undoes the actions that were already applied.
+	fail := func(err error) error {
+		if e := b.rollback(); e != nil {
+			return errors.Wrapf(err, "%v, could not roll back", e)
+		}
+		if templates != nil {
+			templates.rendered = nil // nothing new was rendered
+		}
+		return errors.Wrap(err, "all changes were rolled back")
+	}
+	for _, a := range p.Actions {
+		if a.Kind == actSkip {
+			installed.record(a)
+			continue
+		}
+		answer := answerYes
+		if a.Kind == actOverwrite || a.replace {
+			var err error
+			if answer, err = prompt.ask(a); err != nil {
+				return fail(err)
+			}
+		}
+		if answer == answerNo {
+			continue
+		} else if answer == answerQuit {
+			fmt.Fprintln(out, "stopped, the remaining files were not changed")
+			break
+		}
+		err := b.save(a.Path)
+		if err == nil && answer == answerMerge {
+			err = a.merge(opts.Git(), installed, log, templates)
+		} else if err == nil {
+			err = a.apply(out, log, templates)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		installed.record(a)
+	}
+	if b.dirty {
+		log("saved the previous files to backup %s", b.ID)
+	}
+	return opts.writeInstalled(installed)
+}
+
+// installedFiles maps the files written by install to the hash of the blob
+// they were written from so that the installed version can be used as the
+// base when merging.
+type installedFiles map[string]string
+
+func (o *Options) installedFile() string {
+	return filepath.Join(o.ConfigDir, "installed.json")
+}
+
+func (o *Options) readInstalled() (installedFiles, error) {
+	files := make(installedFiles)
+	raw, err := os.ReadFile(o.installedFile())
+	if os.IsNotExist(err) {
+		return files, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return files, json.Unmarshal(raw, &files)
+}
+
+func (o *Options) writeInstalled(files installedFiles) error {
+	raw, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(o.ConfigDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(o.installedFile(), append(raw, '\n'), 0644)
+}
+
+// record updates the blob that a file was installed from after an action was
+// applied. Directories and symlinks are not recorded.
+func (files installedFiles) record(a *action) {
+	switch {
+	case a.Kind == actRemove:
+		delete(files, a.Path)
+	case a.file == nil || a.dir || len(a.Target) > 0:
+	case a.Kind == actCreate || a.Kind == actOverwrite || a.Kind == actSkip:
+		files[a.Path] = a.file.Hash.String()
+	}
+}
+
+func (a *action) apply(out io.Writer, log func(string, ...any), templates *templateRenderer) error {
+	switch a.Kind {
+	case actMkdir:
+		perm := os.FileMode(0755)
+		if a.file != nil {
+			perm = a.file.FileMode().Perm()
+		}
+		if err := os.MkdirAll(a.Path, perm); err != nil {
+			return errors.Wrap(err, "could not create directory")
+		}
+		log("created directory %q", a.Path)
+	case actSymlink:
+		if err := os.Remove(a.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(a.Target, a.Path); err != nil {
+			return errors.Wrapf(err, "could not create symbolic link %q -> %q", a.Path, a.Target)
+		}
+		log("created symlink %q -> %q", a.Path, a.Target)
+	case actCreate, actOverwrite:
+		if a.replace {
+			if err := os.Remove(a.Path); err != nil {
+				return err
+			}
+		}
+		if a.Template {
+			if err := writeFile(a.Path, bytes.NewReader(a.data), a.Mode); err != nil {
+				return err
+			}
+			log("rendered template %q", a.Path)
+			return templates.written(a.Path, a.data)
+		}
+		if err := writeTreeFile(a.Path, a.file, a.Mode); err != nil {
+			return err
+		}
+		log("wrote file %q", a.Path)
+	case actRemove:
+		err := os.Remove(a.Path)
+		if a.dir && errors.Is(err, syscall.ENOTEMPTY) {
+			fmt.Fprintf(out, "%q is not empty, skipping\n", a.Path)
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to uninstall file %q", a.Path)
+		}
+		log("removed %q", a.Path)
+	}
+	return nil
+}
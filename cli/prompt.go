@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/harrybrwn/dots/diff"
+	"github.com/harrybrwn/dots/git"
+	"github.com/harrybrwn/dots/pkg/stdio"
+)
+
+// answer is a response to the overwrite prompt.
+type answer int
+
+const (
+	answerYes answer = iota
+	answerNo
+	answerQuit
+	answerMerge
+)
+
+const overwriteHelp = `y - overwrite this file
+n - keep this file
+d - show the changes that would be made
+a - overwrite this file and all the remaining files
+q - stop without changing this file or any of the remaining files
+m - merge the changes into this file and open the conflicts in an editor
+? - print help
+`
+
+// overwritePrompt asks before files that already exist are overwritten.
+type overwritePrompt struct {
+	in    *bufio.Reader
+	out   io.Writer
+	color bool
+	all   bool // every file is overwritten without asking
+}
+
+func (p *overwritePrompt) ask(a *action) (answer, error) {
+	if p.all {
+		return answerYes, nil
+	}
+	for {
+		fmt.Fprintf(p.out, "overwrite %q? [y,n,d,a,q,m,?] ", a.Path)
+		line, err := p.in.ReadString('\n')
+		if err == io.EOF {
+			fmt.Fprintln(p.out)
+			return answerNo, nil
+		} else if err != nil {
+			return answerNo, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return answerYes, nil
+		case "n", "no":
+			return answerNo, nil
+		case "a", "all":
+			p.all = true
+			return answerYes, nil
+		case "q", "quit":
+			return answerQuit, nil
+		case "d", "diff":
+			if err = p.diff(a); err != nil {
+				return answerNo, err
+			}
+		case "m", "merge":
+			if a.canMerge() {
+				return answerMerge, nil
+			}
+			fmt.Fprintf(p.out, "%q cannot be merged\n", a.Path)
+		default:
+			fmt.Fprint(p.out, overwriteHelp)
+		}
+	}
+}
+
+// diff prints the changes that overwriting a file would make.
+func (p *overwritePrompt) diff(a *action) error {
+	old, err := readBlob(a.Path)
+	if err != nil {
+		return err
+	}
+	new, err := a.blob()
+	if err != nil {
+		return err
+	}
+	printer := diff.Printer{Color: p.color}
+	return printer.Print(p.out, diff.NewFile(a.Source, old, new, diff.DefaultContext))
+}
+
+// blob returns the contents that an action writes the same way as readBlob.
+func (a *action) blob() (*diff.Blob, error) {
+	if a.Kind == actSymlink {
+		return &diff.Blob{Mode: 0120000, Data: []byte(a.Target)}, nil
+	}
+	data := a.data
+	if !a.Template {
+		var err error
+		if data, err = readTreeFile(a.file); err != nil {
+			return nil, err
+		}
+	}
+	mode := 0100644
+	if a.Mode&0111 != 0 {
+		mode = 0100755
+	}
+	return &diff.Blob{Mode: mode, Data: data}, nil
+}
+
+// base returns the contents that the file was last installed with. It returns
+// false if the installed version is not known or is the same as the new one
+// since there are no changes to merge from it.
+func (a *action) base(g git.Backend, installed installedFiles, templates *templateRenderer) ([]byte, bool, error) {
+	hash, ok := installed[a.Path]
+	if !ok || a.file == nil || hash == a.file.Hash.String() {
+		return nil, false, nil
+	}
+	obj, err := g.OpenObject(git.Ref(hash))
+	if err != nil {
+		return nil, false, nil // the installed version is no longer in the repository
+	}
+	data, err := g.Smudge(a.Source, obj.Data)
+	if err == nil && a.Template {
+		data, err = templates.render(a.Source, data)
+	}
+	return data, err == nil, err
+}
+
+// canMerge returns true if both the existing file and the new file are text.
+func (a *action) canMerge() bool {
+	if a.Kind != actOverwrite {
+		return false
+	}
+	old, err := readBlob(a.Path)
+	if err != nil || old == nil || old.Mode == 0120000 || diff.IsBinary(old.Data) {
+		return false
+	}
+	new, err := a.blob()
+	return err == nil && !diff.IsBinary(new.Data)
+}
+
+// merge merges the changes made to the existing file since it was installed
+// with the changes to the new file. The version that was last installed is
+// used as the common ancestor when it is known, otherwise every difference
+// between the two files is a conflict. Lines that were changed on both sides
+// are written between conflict markers and the file is opened in an editor to
+// resolve them.
+func (a *action) merge(g git.Backend, installed installedFiles, log func(string, ...any), templates *templateRenderer) error {
+	old, err := os.ReadFile(a.Path)
+	if err != nil {
+		return err
+	}
+	new, err := a.blob()
+	if err != nil {
+		return err
+	}
+	base, ok, err := a.base(g, installed, templates)
+	if err != nil {
+		return errors.Wrap(err, "could not read the installed version")
+	}
+	var (
+		merged   []byte
+		conflict bool
+	)
+	if ok {
+		merged, conflict = diff.Merge3(base, old, new.Data, "local", "dots")
+	} else {
+		merged, conflict = diff.Merge(old, new.Data, "local", "dots")
+	}
+	if err = writeFile(a.Path, bytes.NewReader(merged), a.Mode); err != nil {
+		return err
+	}
+	log("merged %q", a.Path)
+	if conflict {
+		if err = stdio.Edit(stdio.FindEditor(), a.Path); err != nil {
+			return errors.Wrapf(err, "could not resolve the conflicts in %q", a.Path)
+		}
+	}
+	if a.Template {
+		data, err := os.ReadFile(a.Path)
+		if err != nil {
+			return err
+		}
+		return templates.written(a.Path, data)
+	}
+	return nil
+}
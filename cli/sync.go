@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/harrybrwn/dots/cli/dotfiles"
 	"github.com/harrybrwn/dots/git"
@@ -11,34 +13,48 @@ import (
 )
 
 func NewSyncCmd(r dotfiles.Repo) *cobra.Command {
+	var timeout time.Duration
 	c := &cobra.Command{
 		Use:   "sync",
 		Short: "Sync with the remote repository",
 		Long:  "Download updates in the remote repo and push local updates to the remote repo.",
-		RunE: func(*cobra.Command, []string) error {
-			return sync(r.Git())
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			g := r.Git()
+			if t, ok := g.(interface{ SetTimeout(time.Duration) }); ok {
+				t.SetTimeout(timeout)
+			}
+			return sync(cmd.Context(), g)
 		},
 	}
+	c.Flags().DurationVar(&timeout, "timeout", timeout, "give up on a pull or push that takes longer than this (0 for no limit)")
 	return c
 }
 
-func sync(g *git.Git) error {
-	var (
-		err error
-	)
+func sync(ctx context.Context, g git.Backend) error {
 	if !g.HasRemote() {
 		return errors.New("repo does not have a remote repo")
 	}
-	if err = execute(g.Cmd("pull")); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
-	}
 	branch, err := g.CurrentBranch()
 	if err != nil {
 		return err
 	}
-	err = execute(g.Cmd("push", "origin", branch))
-	if err != nil {
-		return err
+	push := []string{"origin", branch}
+	err = g.Pull(ctx)
+	switch {
+	case err == nil:
+	case errors.Is(err, git.ErrNoUpstream):
+		// Nothing has been pushed yet so track the remote branch after
+		// pushing.
+		push = append([]string{"--set-upstream"}, push...)
+	case errors.Is(err, git.ErrMergeConflict):
+		return errors.New("pulling caused merge conflicts, resolve them using 'dots git' and then sync again")
+	case errors.Is(err, git.ErrAuthFailed), errors.Is(err, context.DeadlineExceeded):
+		return remoteError("pull", err)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+	if err = g.Push(ctx, push...); err != nil {
+		return remoteError("push", err)
 	}
 	head, err := g.Head()
 	if err != nil {
@@ -50,3 +66,16 @@ func sync(g *git.Git) error {
 	}
 	return nil
 }
+
+// remoteError explains why talking to the remote failed.
+func remoteError(op string, err error) error {
+	switch {
+	case errors.Is(err, git.ErrAuthFailed):
+		return errors.Wrapf(err, "could not %s: authentication with the remote failed", op)
+	case errors.Is(err, context.DeadlineExceeded):
+		return errors.Errorf("could not %s: timed out waiting for the remote", op)
+	case errors.Is(err, context.Canceled):
+		return errors.Errorf("%s was canceled", op)
+	}
+	return err
+}
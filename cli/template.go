@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// templateExt marks tracked files as templates. Installing "file.tmpl" also
+// writes the rendered template to "file".
+const templateExt = ".tmpl"
+
+// templateTarget returns the path that a template is rendered to.
+func templateTarget(path string) (string, bool) {
+	target, ok := strings.CutSuffix(path, templateExt)
+	if !ok || target == "" || strings.HasSuffix(target, string(filepath.Separator)) {
+		return "", false
+	}
+	return target, true
+}
+
+// templateData is the data that templates are executed with.
+type templateData struct {
+	Hostname string
+	OS       string
+	Arch     string
+	User     string
+	Home     string
+	Env      map[string]string
+	// Data is read from the data.toml file in the config directory which
+	// holds values that are specific to a machine.
+	Data map[string]any
+}
+
+func (o *Options) dataFile() string {
+	return filepath.Join(o.ConfigDir, "data.toml")
+}
+
+func (o *Options) templateData() (*templateData, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	d := templateData{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		User:     os.Getenv("USER"),
+		Home:     o.Root,
+		Env:      make(map[string]string),
+		Data:     make(map[string]any),
+	}
+	if u, err := user.Current(); err == nil {
+		d.User = u.Username
+	}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			d.Env[k] = v
+		}
+	}
+	if _, err = toml.DecodeFile(o.dataFile(), &d.Data); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "could not read %q", o.dataFile())
+	}
+	return &d, nil
+}
+
+func renderTemplate(name string, src []byte, data *templateData) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderedFiles maps the files written by rendering templates to the sha256
+// of their contents so that files edited after they were rendered can be
+// found.
+type renderedFiles map[string]string
+
+func (o *Options) renderedFile() string {
+	return filepath.Join(o.ConfigDir, "rendered.json")
+}
+
+func (o *Options) readRendered() (renderedFiles, error) {
+	files := make(renderedFiles)
+	raw, err := os.ReadFile(o.renderedFile())
+	if os.IsNotExist(err) {
+		return files, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return files, json.Unmarshal(raw, &files)
+}
+
+func (o *Options) writeRendered(files renderedFiles) error {
+	raw, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(o.ConfigDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(o.renderedFile(), append(raw, '\n'), 0644)
+}
+
+func (rf renderedFiles) add(path string, data []byte) {
+	sum := sha256.Sum256(data)
+	rf[path] = hex.EncodeToString(sum[:])
+}
+
+// edited returns the rendered files that were changed since they were
+// written.
+func (rf renderedFiles) edited() ([]string, error) {
+	edited := make([]string, 0)
+	for path, hash := range rf {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != hash {
+			edited = append(edited, path)
+		}
+	}
+	sort.Strings(edited)
+	return edited, nil
+}
+
+// templateRenderer renders templates while installing files.
+type templateRenderer struct {
+	opts     *Options
+	data     *templateData
+	rendered renderedFiles
+}
+
+// render executes a template with the data of this machine.
+func (r *templateRenderer) render(name string, src []byte) ([]byte, error) {
+	if r.data == nil {
+		data, err := r.opts.templateData()
+		if err != nil {
+			return nil, err
+		}
+		r.data = data
+	}
+	out, err := renderTemplate(filepath.Base(name), src, r.data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not render template %q", name)
+	}
+	return out, nil
+}
+
+// written records a file written by rendering a template so that edits to it
+// can be found later.
+func (r *templateRenderer) written(target string, data []byte) error {
+	if r.rendered == nil {
+		rendered, err := r.opts.readRendered()
+		if err != nil {
+			return err
+		}
+		r.rendered = rendered
+	}
+	r.rendered.add(target, data)
+	return nil
+}
+
+func (r *templateRenderer) close() error {
+	if r.rendered == nil {
+		return nil
+	}
+	return r.opts.writeRendered(r.rendered)
+}
+
+// warnEditedTemplates prints a warning for each rendered template that was
+// edited directly since those changes are not tracked.
+func warnEditedTemplates(opts *Options, w io.Writer) error {
+	rendered, err := opts.readRendered()
+	if err != nil {
+		return err
+	}
+	edited, err := rendered.edited()
+	if err != nil {
+		return err
+	}
+	for _, path := range edited {
+		fmt.Fprintf(w, "warning: %q was edited after it was rendered, edit %q instead or the changes will be lost on the next install\n", path, path+templateExt)
+	}
+	return nil
+}
+
+func NewTemplateCmd(opts *Options) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "template",
+		Short: "Work with templates",
+		Long: `Tracked files ending in "` + templateExt + `" are text/template templates that
+are rendered by 'dots install' to the same path without the extension.
+Templates are executed with the fields:
+
+  .Hostname  .OS  .Arch  .User  .Home
+  .Env       environment variables
+  .Data      values from the data.toml file in the config directory`,
+	}
+	c.AddCommand(newTemplateRenderCmd(opts))
+	return c
+}
+
+func newTemplateRenderCmd(opts *Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "render <file>",
+		Short: "Print a rendered template",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src := args[0]
+			if _, ok := templateTarget(src); !ok {
+				src += templateExt
+			}
+			raw, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			data, err := opts.templateData()
+			if err != nil {
+				return err
+			}
+			out, err := renderTemplate(filepath.Base(src), raw, data)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+}
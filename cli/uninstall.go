@@ -1,57 +1,46 @@
 package cli
 
 import (
-	"os"
-	"path/filepath"
-	"sort"
-	"syscall"
-
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 func NewUninstallCmd(opts *Options) *cobra.Command {
+	var dryRun, asJSON bool
 	c := &cobra.Command{
 		Use:   "uninstall",
 		Short: "Remove all managed files",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if asJSON && !dryRun {
+				return errJSONWithoutDryRun
+			}
 			g := opts.Git()
-			objects, err := g.Files()
+			mode, err := opts.installMode(g)
 			if err != nil {
 				return err
 			}
-			dirs := make([]string, 0)
-			for _, obj := range objects {
-				f := filepath.Join(opts.Root, obj.Name)
-				info, err := os.Stat(f)
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					dirs = append(dirs, f)
-					continue
-				}
-				err = os.Remove(f)
-				if err != nil {
-					return errors.Wrapf(err, "failed to uninstall file %q", f)
-				}
+			var p *plan
+			if mode == modeSymlink {
+				p, err = planUnlink(opts, g)
+			} else {
+				p, err = planUninstall(opts, opts.Root, g.WalkTree("HEAD"), "")
 			}
-			directories := sort.StringSlice(dirs)
-			// Sorted so that child directories are removed before parents
-			sort.Sort(sort.Reverse(directories))
-			for _, d := range dirs {
-				err = os.Remove(d)
-				if err != nil {
-					if errors.Is(err, syscall.ENOTEMPTY) {
-						cmd.Printf("%q is not empty, skipping\n", d)
-						continue
-					}
-					return err
-				}
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return p.printJSON(cmd.OutOrStdout())
+			} else if dryRun {
+				return p.print(cmd.OutOrStdout(), !opts.NoColor())
+			}
+			if err = p.execute(opts, cmd.InOrStdin(), cmd.OutOrStdout(), nil, true); err != nil {
+				return err
 			}
 			cmd.Println("uninstall successful")
 			return nil
 		},
 	}
+	f := c.Flags()
+	f.BoolVar(&dryRun, "dry-run", dryRun, "print the files that would be removed without removing them")
+	f.BoolVar(&asJSON, "json", asJSON, "print the changes as json, requires --dry-run")
 	return c
 }
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/harrybrwn/dots/cli/dotfiles"
 	"github.com/harrybrwn/dots/git"
@@ -23,7 +26,10 @@ func NewUpdateCmd(opts *Options) *cobra.Command {
 			"  $ dots update ~/.bashrc",
 		SuggestFor: []string{"add"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return update(opts, args)
+			if err := warnEditedTemplates(opts, cmd.ErrOrStderr()); err != nil {
+				return err
+			}
+			return update(cmd.Context(), opts, args)
 		},
 		ValidArgsFunction: modifiedCompletionFunc(opts),
 	}
@@ -31,37 +37,54 @@ func NewUpdateCmd(opts *Options) *cobra.Command {
 	return &c
 }
 
-func update(opts *Options, updated []string) (err error) {
-	g := opts.git()
-	err = g.Cmd("pull").Run()
-	if err != nil {
-		return errors.Wrap(err, "failed to pull before updating")
+func update(ctx context.Context, opts *Options, updated []string) (err error) {
+	g := opts.Git()
+	native, ok := nativeGit(g)
+	if !ok {
+		err = g.Pull(ctx)
+		// Repos without an upstream have nothing to pull.
+		if err != nil && !errors.Is(err, git.ErrNoUpstream) {
+			return errors.Wrap(remoteError("pull", err), "failed to pull before updating")
+		}
+	}
+	if err = linkMissingFiles(opts, g); err != nil {
+		return err
 	}
 	updated, err = getUpdated(g, opts, updated)
 	if err != nil {
 		return err
 	}
+	if ok {
+		// There is no way to pull without git so only commit locally.
+		_, err = native.CommitFiles(updated, commitMessage("update", updated), opts.identity())
+		return err
+	}
 	err = g.Add(updated...)
 	if err != nil {
 		return err
 	}
 	opts.applyUserTo(g)
-	g.SetOut(os.Stdout)
+	if out, ok := g.(interface{ SetOut(io.Writer) }); ok {
+		out.SetOut(os.Stdout)
+	}
 	return g.Commit(commitMessage("update", updated))
 }
 
-func getUpdated(g *git.Git, opts *Options, updated []string) ([]string, error) {
-	if len(updated) > 0 {
-		for i, f := range updated {
+func getUpdated(g git.Backend, opts *Options, updated []string) ([]string, error) {
+	for i, f := range updated {
+		if !filepath.IsAbs(f) {
 			updated[i] = filepath.Join(g.WorkingTree(), f)
 		}
 	}
+	opts.checkoutPaths(g, updated)
 	objects, err := g.Modifications()
 	if err != nil {
 		return nil, err
 	}
 	for _, o := range objects {
-		updated = append(updated, filepath.Join(g.WorkingTree(), o.Name))
+		if path := filepath.Join(g.WorkingTree(), o.Name); !slices.Contains(updated, path) {
+			updated = append(updated, path)
+		}
 	}
 	if opts.HasReadme() {
 		updated = removeReadme(opts.Root, updated)
@@ -29,6 +29,7 @@ func NewUtilCmd(opts *Options) *cobra.Command {
 		NewSetSSHKeyCmd(opts),
 		NewTUILogsCmd(opts),
 		NewStatusCmd(opts),
+		NewFsckCmd(opts),
 	)
 	c.AddCommand(newUtilCommands(opts)...)
 	return c
@@ -204,10 +205,19 @@ func newUtilCommands(opts *Options) []*cobra.Command {
 				if err != nil {
 					return err
 				}
-				for k, v := range config {
-					fmt.Println(k, v)
+				tab := NewTable(cmd.OutOrStdout())
+				tab.Head("SCOPE", "ORIGIN", "KEY", "VALUE")
+				for _, e := range config.Entries() {
+					tab.Add(e.Scope.String(), e.ShowOrigin(), e.Key, e.Value)
+				}
+				if err = tab.Flush(); err != nil {
+					return err
 				}
-				fmt.Println("default branch:", config["init.defaultbranch"])
+				branch, ok := config.Get("init.defaultBranch")
+				if !ok {
+					branch = DefaultBranch
+				}
+				fmt.Println("default branch:", branch)
 				return nil
 			},
 		},
@@ -240,8 +250,7 @@ func newUtilCommands(opts *Options) []*cobra.Command {
 				if err != nil {
 					return err
 				}
-				_, ok := conf["init.defaultBranch"]
-				if !ok {
+				if !conf.Exists("init.defaultBranch") {
 					err = g.ConfigGlobalSet("init.defaultBranch", DefaultBranch)
 					if err != nil {
 						return err
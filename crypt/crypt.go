@@ -0,0 +1,213 @@
+// Package crypt encrypts the contents of tracked files with AES-256-GCM. The
+// nonce is derived from the plaintext so that git's clean filter gives the same
+// output for a file that has not changed.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// KeySize is the size of the secret in a key.
+	KeySize = 32
+
+	// magic starts every encrypted file. The NUL byte makes git treat the
+	// files as binary.
+	magic     = "\x00DOTSENC"
+	version   = 1
+	nonceSize = 12
+	saltSize  = 16
+
+	// iterations is the PBKDF2 work factor for wrapped keys. Wrapped keys
+	// with fewer than minIterations are too weak to trust and more than
+	// maxIterations would take minutes to unwrap.
+	iterations    = 600_000
+	minIterations = 100_000
+	maxIterations = 10_000_000
+
+	pemKey        = "DOTS KEY"
+	pemWrappedKey = "DOTS ENCRYPTED KEY"
+)
+
+var (
+	// ErrNotEncrypted is returned when decrypting data that was not
+	// encrypted by this package.
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	// ErrDecrypt is returned when data was encrypted with a different key
+	// or has been changed.
+	ErrDecrypt = errors.New("could not decrypt, wrong key or corrupt data")
+)
+
+// Key is the secret that files are encrypted with.
+type Key struct {
+	secret [KeySize]byte
+	aead   cipher.AEAD
+	nonce  []byte // HMAC key used to make nonces
+}
+
+// NewKey generates a random key.
+func NewKey() (*Key, error) {
+	var secret [KeySize]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return nil, err
+	}
+	return newKey(secret[:])
+}
+
+func newKey(secret []byte) (*Key, error) {
+	if len(secret) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	k := Key{}
+	copy(k.secret[:], secret)
+	enc, err := hkdf.Key(sha256.New, secret, nil, "dots file encryption", 32)
+	if err != nil {
+		return nil, err
+	}
+	if k.nonce, err = hkdf.Key(sha256.New, secret, nil, "dots file nonce", 32); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(enc)
+	if err != nil {
+		return nil, err
+	}
+	if k.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ParseKey reads a key written by [Key.MarshalText].
+func ParseKey(text []byte) (*Key, error) {
+	block, _ := pem.Decode(text)
+	if block == nil || block.Type != pemKey {
+		return nil, errors.New("not a dots key")
+	}
+	return newKey(block.Bytes)
+}
+
+// MarshalText encodes the key as a PEM block.
+func (k *Key) MarshalText() ([]byte, error) {
+	return pem.EncodeToMemory(&pem.Block{Type: pemKey, Bytes: k.secret[:]}), nil
+}
+
+// Equal returns true if both keys have the same secret.
+func (k *Key) Equal(other *Key) bool {
+	return hmac.Equal(k.secret[:], other.secret[:])
+}
+
+// Encrypt encrypts data. The result is always the same for the same data and
+// key.
+func (k *Key) Encrypt(data []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonce)
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:nonceSize]
+
+	out := make([]byte, 0, len(magic)+1+nonceSize+len(data)+k.aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, version)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, data, out[:len(magic)+1])
+}
+
+// Decrypt decrypts data from [Key.Encrypt].
+func (k *Key) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	header := len(magic) + 1
+	if len(data) < header+nonceSize {
+		return nil, ErrDecrypt
+	}
+	nonce := data[header : header+nonceSize]
+	out, err := k.aead.Open(nil, nonce, data[header+nonceSize:], data[:header])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// IsEncrypted returns true if data starts with the header of an encrypted
+// file.
+func IsEncrypted(data []byte) bool {
+	return len(data) > len(magic) && bytes.HasPrefix(data, []byte(magic)) && data[len(magic)] == version
+}
+
+// Wrap encrypts the key with a passphrase so that it can be copied to other
+// machines. The passphrase is stretched with PBKDF2.
+func (k *Key) Wrap(passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := passphraseCipher(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	block := pem.Block{
+		Type: pemWrappedKey,
+		Headers: map[string]string{
+			"Iterations": strconv.Itoa(iterations),
+		},
+		Bytes: append(append(salt, nonce...), aead.Seal(nil, nonce, k.secret[:], []byte(pemWrappedKey))...),
+	}
+	return pem.EncodeToMemory(&block), nil
+}
+
+// IsWrapped returns true if text is a key from [Key.Wrap].
+func IsWrapped(text []byte) bool {
+	block, _ := pem.Decode(text)
+	return block != nil && block.Type == pemWrappedKey
+}
+
+// Unwrap decrypts a key from [Key.Wrap].
+func Unwrap(text []byte, passphrase string) (*Key, error) {
+	block, _ := pem.Decode(text)
+	if block == nil || block.Type != pemWrappedKey {
+		return nil, errors.New("not an encrypted dots key")
+	}
+	iter, err := strconv.Atoi(block.Headers["Iterations"])
+	if err != nil || iter < minIterations || iter > maxIterations {
+		return nil, fmt.Errorf("invalid key iterations %q", block.Headers["Iterations"])
+	}
+	if len(block.Bytes) < saltSize+nonceSize {
+		return nil, ErrDecrypt
+	}
+	salt, nonce := block.Bytes[:saltSize], block.Bytes[saltSize:saltSize+nonceSize]
+	aead, err := passphraseCipher(passphrase, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aead.Open(nil, nonce, block.Bytes[saltSize+nonceSize:], []byte(pemWrappedKey))
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return newKey(secret)
+}
+
+func passphraseCipher(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iter, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/pem"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestKey(t *testing.T) {
+	is := is.New(t)
+	key, err := NewKey()
+	is.NoErr(err)
+	other, err := NewKey()
+	is.NoErr(err)
+	is.True(!key.Equal(other))
+
+	for _, data := range []string{"", "Host example.com\n  User me\n", "\x00binary\xff"} {
+		enc := key.Encrypt([]byte(data))
+		is.True(IsEncrypted(enc))
+		is.True(bytes.Equal(enc, key.Encrypt([]byte(data)))) // should be deterministic
+		is.True(!bytes.Contains(enc, []byte("example.com")))
+		dec, err := key.Decrypt(enc)
+		is.NoErr(err)
+		is.Equal(string(dec), data)
+		_, err = other.Decrypt(enc)
+		is.Equal(err, ErrDecrypt)
+	}
+	is.True(!bytes.Equal(key.Encrypt([]byte("a")), key.Encrypt([]byte("b"))))
+
+	enc := key.Encrypt([]byte("secret"))
+	enc[len(enc)-1] ^= 1
+	_, err = key.Decrypt(enc)
+	is.Equal(err, ErrDecrypt)
+	_, err = key.Decrypt([]byte("plain text"))
+	is.Equal(err, ErrNotEncrypted)
+	is.True(!IsEncrypted([]byte("\x00DOTSENC")))
+}
+
+func TestKey_Marshal(t *testing.T) {
+	is := is.New(t)
+	key, err := NewKey()
+	is.NoErr(err)
+	text, err := key.MarshalText()
+	is.NoErr(err)
+	parsed, err := ParseKey(text)
+	is.NoErr(err)
+	is.True(parsed.Equal(key))
+	is.Equal(string(parsed.Encrypt([]byte("x"))), string(key.Encrypt([]byte("x"))))
+	_, err = ParseKey([]byte("not a key"))
+	is.True(err != nil)
+
+	wrapped, err := key.Wrap("correct horse")
+	is.NoErr(err)
+	is.True(IsWrapped(wrapped))
+	is.True(!IsWrapped(text))
+	_, err = ParseKey(wrapped)
+	is.True(err != nil)
+	_, err = Unwrap(wrapped, "wrong")
+	is.True(err != nil)
+	unwrapped, err := Unwrap(wrapped, "correct horse")
+	is.NoErr(err)
+	is.True(unwrapped.Equal(key))
+	for _, iter := range []string{"1", "99999", "10000001", "-1", "x"} {
+		block, _ := pem.Decode(wrapped)
+		block.Headers["Iterations"] = iter
+		_, err = Unwrap(pem.EncodeToMemory(block), "correct horse")
+		is.True(err != nil) // iterations should be in range
+	}
+}
@@ -0,0 +1,327 @@
+// Package diff compares files line by line and formats the differences the
+// same way as 'git diff'.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change.
+const DefaultContext = 3
+
+// Kind is the kind of change made to a line.
+type Kind uint8
+
+const (
+	// Equal lines are the same in both files.
+	Equal Kind = iota
+	// Delete lines are only in the old file.
+	Delete
+	// Insert lines are only in the new file.
+	Insert
+)
+
+// Line is a single line of a hunk. Text ends with a newline unless it is the
+// last line of a file that does not end with one.
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// Hunk is a group of changed lines and the unchanged lines around them. Line
+// numbers start at one.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Header returns the "@@ -1,2 +1,3 @@" line of the hunk.
+func (h *Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Blob is one version of a file. A nil *Blob means the file does not exist.
+type Blob struct {
+	// Mode is the git mode of the file such as 0100644.
+	Mode int
+	Data []byte
+}
+
+// File is the difference between two versions of a file.
+type File struct {
+	Name             string
+	OldMode, NewMode int // zero if the file was added or deleted
+	OldSize, NewSize int
+	// Binary is true if either version has a NUL byte near the start in which
+	// case there are no hunks.
+	Binary bool
+	Hunks  []Hunk
+}
+
+// NewFile compares two versions of a file showing context unchanged lines
+// around each change.
+func NewFile(name string, old, new *Blob, context int) *File {
+	f := File{Name: name}
+	var a, b []byte
+	if old != nil {
+		f.OldMode, f.OldSize, a = old.Mode, len(old.Data), old.Data
+	}
+	if new != nil {
+		f.NewMode, f.NewSize, b = new.Mode, len(new.Data), new.Data
+	}
+	if IsBinary(a) || IsBinary(b) {
+		f.Binary = !bytes.Equal(a, b)
+		return &f
+	}
+	f.Hunks = Lines(a, b, context)
+	return &f
+}
+
+// Stats returns the number of lines added and deleted.
+func (f *File) Stats() (added, deleted int) {
+	for _, h := range f.Hunks {
+		for _, l := range h.Lines {
+			switch l.Kind {
+			case Insert:
+				added++
+			case Delete:
+				deleted++
+			}
+		}
+	}
+	return added, deleted
+}
+
+// IsBinary uses the same check as git, a NUL byte in the first 8000 bytes.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// Lines compares two texts line by line and groups the changes into hunks
+// with context unchanged lines around each change.
+func Lines(old, new []byte, context int) []Hunk {
+	a, b := SplitLines(string(old)), SplitLines(string(new))
+	return hunks(a, b, Diff(a, b), max(context, 0))
+}
+
+// SplitLines splits text after each newline.
+func SplitLines(text string) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Diff returns the shortest edit script that changes a into b using the
+// Myers algorithm. Each Equal consumes an element of both a and b, Delete
+// consumes an element of a and Insert consumes an element of b. Deletions
+// come before insertions when they are next to each other.
+func Diff[T comparable](a, b []T) []Kind {
+	size := len(a) + len(b) + 3
+	m := myers[T]{
+		ops: make([]Kind, 0, len(a)+len(b)),
+		vf:  make([]int, 2*size),
+		vb:  make([]int, 2*size),
+		off: size,
+	}
+	m.compare(a, b)
+	// Changes between two equal elements can be in any order so deletions
+	// are moved in front of the insertions.
+	ops := m.ops
+	for i := 0; i < len(ops); {
+		if ops[i] == Equal {
+			i++
+			continue
+		}
+		j, deleted := i, 0
+		for ; j < len(ops) && ops[j] != Equal; j++ {
+			if ops[j] == Delete {
+				deleted++
+			}
+		}
+		for k := i; k < j; k++ {
+			if k-i < deleted {
+				ops[k] = Delete
+			} else {
+				ops[k] = Insert
+			}
+		}
+		i = j
+	}
+	return ops
+}
+
+// myers implements the linear space refinement from "An O(ND) Difference
+// Algorithm and Its Variations". The middle snake of the edit script is found
+// by searching from both ends at once and the parts before and after it are
+// compared recursively. The vectors of furthest reaching paths are shared by
+// every step so only O(N+M) memory is used.
+type myers[T comparable] struct {
+	ops []Kind
+	// vf and vb hold the furthest reaching x of the forward and backward
+	// searches for each diagonal k at vf[off+k] and vb[off+k]. The backward
+	// search runs over the reversed sequences.
+	vf, vb []int
+	off    int
+}
+
+func (m *myers[T]) compare(a, b []T) {
+	// The common prefix and suffix are trimmed to keep the search small.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	m.repeat(Equal, pre)
+	a, b = a[pre:len(a)-suf], b[pre:len(b)-suf]
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		m.repeat(Delete, len(a))
+		m.repeat(Insert, len(b))
+	default:
+		// Neither side is empty and the ends differ so the script has at
+		// least two edits and both halves are smaller than the whole.
+		x, y, u, v := m.middleSnake(a, b)
+		m.compare(a[:x], b[:y])
+		m.repeat(Equal, u-x)
+		m.compare(a[u:], b[v:])
+	}
+	m.repeat(Equal, suf)
+}
+
+func (m *myers[T]) repeat(op Kind, n int) {
+	for range n {
+		m.ops = append(m.ops, op)
+	}
+}
+
+// middleSnake returns the start (x, y) and the end (u, v) of the snake in the
+// middle of the shortest edit script.
+func (m *myers[T]) middleSnake(a, b []T) (x, y, u, v int) {
+	n, w := len(a), len(b)
+	delta := n - w
+	odd := delta%2 != 0
+	vf, vb, off := m.vf, m.vb, m.off
+	vf[off+1], vb[off+1] = 0, 0
+	for d := 0; d <= (n+w+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1] // down, insert from b
+			} else {
+				x = vf[off+k-1] + 1 // right, delete from a
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < w && a[u] == b[v] {
+				u++
+				v++
+			}
+			vf[off+k] = u
+			// The paths overlap when the backward search has already
+			// reached x on the same diagonal.
+			if kr := delta - k; odd && kr >= -(d-1) && kr <= d-1 && u+vb[off+kr] >= n {
+				return x, y, u, v
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < w && a[n-1-u] == b[w-1-v] {
+				u++
+				v++
+			}
+			vb[off+k] = u
+			if kf := delta - k; !odd && kf >= -d && kf <= d && u+vf[off+kf] >= n {
+				return n - u, w - v, n - x, w - y
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// hunks groups an edit script into hunks. Changes that are at most
+// 2*context lines apart share a hunk like they do in git.
+func hunks(a, b []string, ops []Kind, context int) []Hunk {
+	// keep[i] is true if the op is a change or within context of one.
+	keep := make([]bool, len(ops))
+	last := -1
+	for i, op := range ops {
+		if op != Equal {
+			last = i
+		}
+		keep[i] = last >= 0 && i-last <= context
+	}
+	last = -1
+	for i := len(ops) - 1; i >= 0; i-- {
+		if ops[i] != Equal {
+			last = i
+		}
+		keep[i] = keep[i] || (last >= 0 && last-i <= context)
+	}
+
+	var (
+		res  []Hunk
+		cur  *Hunk
+		i, j int
+	)
+	for idx, op := range ops {
+		if !keep[idx] {
+			cur = nil
+		} else {
+			if cur == nil {
+				res = append(res, Hunk{OldStart: i + 1, NewStart: j + 1})
+				cur = &res[len(res)-1]
+			}
+			switch op {
+			case Equal:
+				cur.Lines = append(cur.Lines, Line{Kind: Equal, Text: a[i]})
+				cur.OldLines++
+				cur.NewLines++
+			case Delete:
+				cur.Lines = append(cur.Lines, Line{Kind: Delete, Text: a[i]})
+				cur.OldLines++
+			case Insert:
+				cur.Lines = append(cur.Lines, Line{Kind: Insert, Text: b[j]})
+				cur.NewLines++
+			}
+		}
+		if op != Insert {
+			i++
+		}
+		if op != Delete {
+			j++
+		}
+	}
+	// An empty range starts at the line before it.
+	for k := range res {
+		if res[k].OldLines == 0 {
+			res[k].OldStart--
+		}
+		if res[k].NewLines == 0 {
+			res[k].NewStart--
+		}
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+var cases = []struct{ name, old, new string }{
+	{"change", "one\ntwo three four\nfive\n", "one\ntwo 3 four\nfive\nsix\n"},
+	{"add", "", "a\nb\nc\n"},
+	{"delete", "a\nb\nc\n", ""},
+	{"prepend", "b\nc\nd\ne\nf\n", "a\nb\nc\nd\ne\nf\n"},
+	{"no newline", "a\nb\nc", "a\nb\nc\n"},
+	{"both no newline", "a\nb", "a\nc"},
+	{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n", "1\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\ny\n15\n"},
+	{"merged hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\nx\n3\n4\n5\n6\n7\ny\n9\n10\n"},
+	{"replace block", "a\nb\nc\nd\n", "a\nx\ny\nd\n"},
+}
+
+func TestPrint(t *testing.T) {
+	gitBinary(t)
+	for _, tc := range cases {
+		for _, words := range []bool{false, true} {
+			is := is.New(t)
+			args := []string{"--diff-algorithm=myers", "--no-indent-heuristic"}
+			if words {
+				args = append(args, "--word-diff=plain")
+			}
+			exp := gitDiff(t, tc.old, tc.new, args...)
+			if words && tc.name == "replace block" {
+				// Newlines are words so changed lines are paired up
+				// instead of git's deletions followed by insertions.
+				exp = "@@ -1,4 +1,4 @@\na\n[-b-]{+x+}\n[-c-]{+y+}\nd\n"
+			}
+			p := Printer{WordDiff: words}
+			f := NewFile("file", &Blob{Mode: 0100644, Data: []byte(tc.old)}, &Blob{Mode: 0100644, Data: []byte(tc.new)}, DefaultContext)
+			got := p.String(f)
+			if i := strings.Index(got, "@@"); i >= 0 {
+				got = got[i:]
+			}
+			is.Equal(got, exp) // should match git
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	is := is.New(t)
+	r := rand.New(rand.NewSource(1))
+	gen := func() []byte {
+		b := make([]byte, r.Intn(30))
+		for i := range b {
+			b[i] = "abcd"[r.Intn(4)]
+		}
+		return b
+	}
+	for range 500 {
+		a, b := gen(), gen()
+		ops := Diff(a, b)
+		var (
+			got  []byte
+			i, j int
+		)
+		edits := 0
+		for _, op := range ops {
+			switch op {
+			case Equal:
+				is.Equal(a[i], b[j])
+				got = append(got, a[i])
+				i++
+				j++
+			case Delete:
+				i++
+				edits++
+			case Insert:
+				got = append(got, b[j])
+				j++
+				edits++
+			}
+		}
+		is.Equal(i, len(a))
+		is.Equal(string(got), string(b))
+		is.Equal(edits, len(a)+len(b)-2*lcs(a, b)) // should be the shortest edit script
+	}
+}
+
+func TestDiff_Large(t *testing.T) {
+	is := is.New(t)
+	// Every element but one differs so the edit script is very long. Keeping
+	// every round of the search would need close to a gigabyte of memory.
+	n := 5000
+	a, b := make([]int, n), make([]int, n)
+	for i := range n {
+		a[i], b[i] = i, n+i
+	}
+	b[n/2] = a[n/2]
+	ops := Diff(a, b)
+	is.Equal(len(ops), 2*n-1)
+	for i, op := range ops {
+		switch {
+		case i < n/2:
+			is.Equal(op, Delete) // deletions should come first
+		case i < n:
+			is.Equal(op, Insert)
+		case i == n:
+			is.Equal(op, Equal)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	is := is.New(t)
+	files := []*File{
+		NewFile(".bashrc", &Blob{Mode: 0100644, Data: []byte("a\nb\n")}, &Blob{Mode: 0100644, Data: []byte("a\nc\nd\n")}, 3),
+		NewFile(".config/nvim/init.lua", nil, &Blob{Mode: 0100644, Data: []byte(strings.Repeat("x\n", 200))}, 3),
+		NewFile("img.png", &Blob{Mode: 0100644, Data: []byte("\x00\x01")}, &Blob{Mode: 0100644, Data: []byte("\x00\x02\x03")}, 3),
+	}
+	var b bytes.Buffer
+	p := Printer{}
+	is.NoErr(p.Stat(&b, 60, files...))
+	is.Equal(b.String(), ""+
+		" .bashrc               |   3 +-\n"+
+		" .config/nvim/init.lua | 200 +++++++++++++++++++++++++++++++\n"+
+		" img.png               | Bin 2 -> 3 bytes\n"+
+		" 3 files changed, 202 insertions(+), 1 deletion(-)\n")
+	is.True(files[2].Binary)
+	is.Equal(p.String(files[2]), "diff --git a/img.png b/img.png\nBinary files a/img.png and b/img.png differ\n")
+	is.Equal(strings.SplitN(p.String(files[1]), "\n", 5)[:4], []string{
+		"diff --git a/.config/nvim/init.lua b/.config/nvim/init.lua",
+		"new file mode 100644",
+		"--- /dev/null",
+		"+++ b/.config/nvim/init.lua",
+	})
+}
+
+func lcs(a, b []byte) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i][j] = dp[i-1][j-1] + 1
+			} else {
+				dp[i][j] = max(dp[i-1][j], dp[i][j-1])
+			}
+		}
+	}
+	return dp[len(a)][len(b)]
+}
+
+func gitBinary(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+// gitDiff returns the hunks from 'git diff --no-index'.
+func gitDiff(t *testing.T, old, new string, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte(new), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("git", append(append([]string{"diff", "--no-index", "--no-color"}, args...), a, b)...)
+	cmd.Stdout = &out
+	_ = cmd.Run() // exits with 1 when there are differences
+	s := out.String()
+	if i := strings.Index(s, "@@"); i >= 0 {
+		return s[i:]
+	}
+	return ""
+}
+
+func TestMerge(t *testing.T) {
+	is := is.New(t)
+	for _, tt := range []struct {
+		ours, theirs, want string
+		conflict           bool
+	}{
+		{"a\nb\n", "a\nb\n", "a\nb\n", false},
+		{"a\nb\nc\n", "a\nx\nc\n", "a\n<<<<<<< ours\nb\n=======\nx\n>>>>>>> theirs\nc\n", true},
+		{"a\n", "a\nb", "a\n<<<<<<< ours\n=======\nb\n>>>>>>> theirs\n", true},
+		{"", "a\n", "<<<<<<< ours\n=======\na\n>>>>>>> theirs\n", true},
+		{"1\n2\n3\n4\n", "0\n2\n3\n5\n", "<<<<<<< ours\n1\n=======\n0\n>>>>>>> theirs\n2\n3\n<<<<<<< ours\n4\n=======\n5\n>>>>>>> theirs\n", true},
+	} {
+		out, conflict := Merge([]byte(tt.ours), []byte(tt.theirs), "ours", "theirs")
+		is.Equal(string(out), tt.want)
+		is.Equal(conflict, tt.conflict)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	is := is.New(t)
+	base := "a\nb\nc\nd\ne\n"
+	for _, tt := range []struct {
+		ours, theirs, want string
+		conflict           bool
+	}{
+		{base, base, base, false},
+		{"a\nB\nc\nd\ne\n", base, "a\nB\nc\nd\ne\n", false},
+		{base, "a\nb\nc\nD\ne\n", "a\nb\nc\nD\ne\n", false},
+		{"a\nB\nc\nd\ne\n", "a\nb\nc\nD\ne\n", "a\nB\nc\nD\ne\n", false},
+		{"x\na\nb\nc\nd\ne\n", "a\nb\nc\ne\nf\n", "x\na\nb\nc\ne\nf\n", false},
+		{"a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", false}, // same change
+		{"a\nX\nc\nd\ne\n", "a\nY\nc\nd\ne\n", "a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nc\nd\ne\n", true},
+		{"a\nb\nc\nd\ne\nf\n", "a\nb\nc\nd\ne\ng", "a\nb\nc\nd\ne\n<<<<<<< ours\nf\n=======\ng\n>>>>>>> theirs\n", true},
+		{"a\ne\n", "a\nb\nc\nd\ne\n", "a\ne\n", false},
+	} {
+		out, conflict := Merge3([]byte(base), []byte(tt.ours), []byte(tt.theirs), "ours", "theirs")
+		is.Equal(string(out), tt.want)
+		is.Equal(conflict, tt.conflict)
+	}
+}
@@ -0,0 +1,285 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Colors used by git's default color.diff settings.
+const (
+	colorMeta  = "\x1b[1m"
+	colorFrag  = "\x1b[36m"
+	colorOld   = "\x1b[31m"
+	colorNew   = "\x1b[32m"
+	colorReset = "\x1b[m"
+)
+
+const noNewline = "\\ No newline at end of file"
+
+// Printer writes diffs in the same format as 'git diff'.
+type Printer struct {
+	// Color adds terminal colors to the output.
+	Color bool
+	// WordDiff shows the words that changed within lines instead of whole
+	// lines, like 'git diff --word-diff'.
+	WordDiff bool
+}
+
+// Print writes the header and hunks of each file.
+func (p *Printer) Print(w io.Writer, files ...*File) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range files {
+		p.header(bw, f)
+		for i := range f.Hunks {
+			h := &f.Hunks[i]
+			p.line(bw, colorFrag, h.Header())
+			if p.WordDiff {
+				p.words(bw, h)
+				continue
+			}
+			for _, l := range h.Lines {
+				text := strings.TrimSuffix(l.Text, "\n")
+				switch l.Kind {
+				case Equal:
+					p.line(bw, "", " "+text)
+				case Delete:
+					p.line(bw, colorOld, "-"+text)
+				case Insert:
+					p.line(bw, colorNew, "+"+text)
+				}
+				if !strings.HasSuffix(l.Text, "\n") {
+					p.line(bw, "", noNewline)
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// String formats files with [Printer.Print].
+func (p *Printer) String(files ...*File) string {
+	var b strings.Builder
+	_ = p.Print(&b, files...)
+	return b.String()
+}
+
+func (p *Printer) header(w *bufio.Writer, f *File) {
+	oldName, newName := "a/"+f.Name, "b/"+f.Name
+	p.line(w, colorMeta, fmt.Sprintf("diff --git %s %s", oldName, newName))
+	switch {
+	case f.OldMode == 0:
+		p.line(w, colorMeta, fmt.Sprintf("new file mode %06o", f.NewMode))
+		oldName = "/dev/null"
+	case f.NewMode == 0:
+		p.line(w, colorMeta, fmt.Sprintf("deleted file mode %06o", f.OldMode))
+		newName = "/dev/null"
+	case f.OldMode != f.NewMode:
+		p.line(w, colorMeta, fmt.Sprintf("old mode %06o", f.OldMode))
+		p.line(w, colorMeta, fmt.Sprintf("new mode %06o", f.NewMode))
+	}
+	if f.Binary {
+		p.line(w, "", fmt.Sprintf("Binary files %s and %s differ", oldName, newName))
+		return
+	}
+	if len(f.Hunks) == 0 {
+		return
+	}
+	p.line(w, colorMeta, "--- "+oldName)
+	p.line(w, colorMeta, "+++ "+newName)
+}
+
+func (p *Printer) line(w *bufio.Writer, color, text string) {
+	if p.Color && len(color) > 0 {
+		w.WriteString(color)
+		w.WriteString(text)
+		w.WriteString(colorReset)
+	} else {
+		w.WriteString(text)
+	}
+	w.WriteByte('\n')
+}
+
+// words writes a hunk using 'git diff --word-diff=plain' markers or
+// '--word-diff=color' when colors are on. Each run of changed lines is
+// compared word by word.
+func (p *Printer) words(w *bufio.Writer, h *Hunk) {
+	var old, new strings.Builder
+	flush := func() {
+		if old.Len() == 0 && new.Len() == 0 {
+			return
+		}
+		a, b := splitWords(old.String()), splitWords(new.String())
+		i, j := 0, 0
+		for _, op := range Diff(a, b) {
+			switch op {
+			case Equal:
+				w.WriteString(a[i])
+				i++
+				j++
+			case Delete:
+				p.marked(w, a[i], "[-", "-]", colorOld)
+				i++
+			case Insert:
+				p.marked(w, b[j], "{+", "+}", colorNew)
+				j++
+			}
+		}
+		if s := new.String(); len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			w.WriteByte('\n')
+		}
+		old.Reset()
+		new.Reset()
+	}
+	for _, l := range h.Lines {
+		switch l.Kind {
+		case Equal:
+			flush()
+			w.WriteString(l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				w.WriteByte('\n')
+			}
+		case Delete:
+			old.WriteString(l.Text)
+		case Insert:
+			new.WriteString(l.Text)
+		}
+	}
+	flush()
+}
+
+// marked writes a changed word. Newlines are written outside of the markers so
+// that every line is marked on its own.
+func (p *Printer) marked(w *bufio.Writer, word, open, close, color string) {
+	for i, part := range strings.Split(word, "\n") {
+		if i > 0 {
+			w.WriteByte('\n')
+		}
+		if len(part) == 0 {
+			continue
+		}
+		if p.Color {
+			w.WriteString(color + part + colorReset)
+		} else {
+			w.WriteString(open + part + close)
+		}
+	}
+}
+
+// splitWords splits text into runs of spaces and runs of other characters.
+// Newlines are always their own word so that changes never hide them. Unlike
+// git, this pairs up the words of changed lines instead of showing all the
+// deleted lines before the inserted ones.
+func splitWords(text string) []string {
+	var (
+		words []string
+		start int
+	)
+	class := func(r rune) int {
+		switch {
+		case r == '\n':
+			return 0
+		case unicode.IsSpace(r):
+			return 1
+		default:
+			return 2
+		}
+	}
+	for i, r := range text {
+		if i == start {
+			continue
+		}
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		if class(prev) != class(r) || r == '\n' {
+			words = append(words, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// Stat writes a summary of the changes to each file like 'git diff --stat'.
+// Width is the width of the terminal, 80 is used if it is zero.
+func (p *Printer) Stat(w io.Writer, width int, files ...*File) error {
+	if width <= 0 {
+		width = 80
+	}
+	type row struct {
+		file           *File
+		count          string
+		added, deleted int
+	}
+	var (
+		rows                    = make([]row, len(files))
+		nameWidth, countWidth   int
+		maxChanges, added, dels int
+	)
+	for i, f := range files {
+		r := row{file: f}
+		if f.Binary {
+			r.count = "Bin"
+		} else {
+			r.added, r.deleted = f.Stats()
+			r.count = fmt.Sprint(r.added + r.deleted)
+			maxChanges = max(maxChanges, r.added+r.deleted)
+		}
+		added += r.added
+		dels += r.deleted
+		nameWidth = max(nameWidth, utf8.RuneCountInString(f.Name))
+		countWidth = max(countWidth, len(r.count))
+		rows[i] = r
+	}
+	// The graph gets whatever is left after " name | count ".
+	graphWidth := max(width-nameWidth-countWidth-5, 10)
+	scale := func(n int) int {
+		if maxChanges <= graphWidth || n == 0 {
+			return n
+		}
+		return max(n*graphWidth/maxChanges, 1)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, r := range rows {
+		fmt.Fprintf(bw, " %-*s | %*s", nameWidth, r.file.Name, countWidth, r.count)
+		if r.file.Binary {
+			fmt.Fprintf(bw, " %d -> %d bytes", r.file.OldSize, r.file.NewSize)
+		} else if r.added+r.deleted > 0 {
+			bw.WriteByte(' ')
+			plus, minus := strings.Repeat("+", scale(r.added)), strings.Repeat("-", scale(r.deleted))
+			if p.Color {
+				if len(plus) > 0 {
+					plus = colorNew + plus + colorReset
+				}
+				if len(minus) > 0 {
+					minus = colorOld + minus + colorReset
+				}
+			}
+			bw.WriteString(plus + minus)
+		}
+		bw.WriteByte('\n')
+	}
+	if len(files) > 0 {
+		fmt.Fprintf(bw, " %d %s changed", len(files), plural(len(files), "file", "files"))
+		if added > 0 || dels == 0 {
+			fmt.Fprintf(bw, ", %d %s(+)", added, plural(added, "insertion", "insertions"))
+		}
+		if dels > 0 || added == 0 {
+			fmt.Fprintf(bw, ", %d %s(-)", dels, plural(dels, "deletion", "deletions"))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
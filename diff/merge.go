@@ -0,0 +1,145 @@
+package diff
+
+import (
+	"bytes"
+	"slices"
+)
+
+// Merge combines two versions of a file that have no common ancestor. Lines
+// that are the same in both are kept and each run of lines that differ
+// becomes a conflict with both versions between git style markers. It
+// returns false if there were no conflicts.
+func Merge(ours, theirs []byte, oursName, theirsName string) ([]byte, bool) {
+	var (
+		a, b         = SplitLines(string(ours)), SplitLines(string(theirs))
+		w            = mergeWriter{ours: oursName, theirs: theirsName}
+		i, j         int
+		start, other = -1, -1 // start of the conflict in a and b
+	)
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		w.conflict(a[start:i], b[other:j])
+		start, other = -1, -1
+	}
+	for _, op := range Diff(a, b) {
+		if op == Equal {
+			flush()
+			w.WriteString(a[i])
+			i++
+			j++
+			continue
+		}
+		if start < 0 {
+			start, other = i, j
+		}
+		if op == Delete {
+			i++
+		} else {
+			j++
+		}
+	}
+	flush()
+	return w.Bytes(), w.conflicts
+}
+
+// Merge3 merges the changes that ours and theirs made to their common
+// ancestor base like 'git merge-file'. Lines that were only changed on one
+// side are taken from that side and lines that were changed differently on
+// both sides become conflicts. It returns false if there were no conflicts.
+func Merge3(base, ours, theirs []byte, oursName, theirsName string) ([]byte, bool) {
+	var (
+		o, a, b = SplitLines(string(base)), SplitLines(string(ours)), SplitLines(string(theirs))
+		ma, mb  = matches(o, a), matches(o, b)
+		w       = mergeWriter{ours: oursName, theirs: theirsName}
+		i, j, k int // next line in base, ours and theirs
+	)
+	for i < len(o) || j < len(a) || k < len(b) {
+		if i < len(o) && ma[i] == j && mb[i] == k {
+			w.WriteString(o[i]) // unchanged on both sides
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		// Find the next base line that is kept by both sides. Everything
+		// before it was changed by at least one of them.
+		next, nj, nk := i, len(a), len(b)
+		for ; next < len(o); next++ {
+			if ma[next] >= 0 && mb[next] >= 0 {
+				nj, nk = ma[next], mb[next]
+				break
+			}
+		}
+		var (
+			orig   = o[i:next]
+			left   = a[j:nj]
+			right  = b[k:nk]
+			lines  []string
+			merged = true
+		)
+		switch {
+		case slices.Equal(left, orig):
+			lines = right
+		case slices.Equal(right, orig), slices.Equal(left, right):
+			lines = left
+		default:
+			merged = false
+		}
+		if merged {
+			for _, l := range lines {
+				w.WriteString(l)
+			}
+		} else {
+			w.conflict(left, right)
+		}
+		i, j, k = next, nj, nk
+	}
+	return w.Bytes(), w.conflicts
+}
+
+// matches returns the index of the line in b that each line of a is kept as
+// or -1 if it was removed.
+func matches(a, b []string) []int {
+	m := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range Diff(a, b) {
+		switch op {
+		case Equal:
+			m[i] = j
+			i++
+			j++
+		case Delete:
+			m[i] = -1
+			i++
+		case Insert:
+			j++
+		}
+	}
+	return m
+}
+
+// mergeWriter writes the result of a merge.
+type mergeWriter struct {
+	bytes.Buffer
+	ours, theirs string
+	conflicts    bool
+}
+
+// conflict writes both versions of a run of lines between git style markers.
+func (w *mergeWriter) conflict(ours, theirs []string) {
+	w.conflicts = true
+	w.WriteString("<<<<<<< " + w.ours + "\n")
+	w.side(ours)
+	w.WriteString("=======\n")
+	w.side(theirs)
+	w.WriteString(">>>>>>> " + w.theirs + "\n")
+}
+
+func (w *mergeWriter) side(lines []string) {
+	for _, l := range lines {
+		w.WriteString(l)
+	}
+	if n := w.Len(); n > 0 && w.Bytes()[n-1] != '\n' {
+		w.WriteByte('\n')
+	}
+}
@@ -0,0 +1,174 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AttributesFile is the name of the file that sets attributes for the paths
+// in a working tree.
+const AttributesFile = ".gitattributes"
+
+// Attributes are the rules of a .gitattributes file. Patterns are matched the
+// same way as gitignore patterns except that they never match directories.
+// Macros such as "binary" are not expanded.
+type Attributes struct {
+	rules []attrRule
+}
+
+type attrRule struct {
+	pattern string
+	// attrs maps a name to "true" when it is set, "false" when it is unset
+	// with a '-' prefix, the value after '=' or "" when it is reset with '!'.
+	attrs map[string]string
+}
+
+// ParseAttributes reads the rules of a .gitattributes file.
+func ParseAttributes(r io.Reader) (*Attributes, error) {
+	var (
+		a  Attributes
+		sc = bufio.NewScanner(r)
+	)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule := attrRule{pattern: fields[0], attrs: make(map[string]string, len(fields)-1)}
+		for _, f := range fields[1:] {
+			switch {
+			case strings.HasPrefix(f, "-"):
+				rule.attrs[f[1:]] = "false"
+			case strings.HasPrefix(f, "!"):
+				rule.attrs[f[1:]] = ""
+			default:
+				name, value, ok := strings.Cut(f, "=")
+				if !ok {
+					value = "true"
+				}
+				rule.attrs[name] = value
+			}
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return &a, sc.Err()
+}
+
+// Get returns the value of an attribute for a slash separated path relative
+// to the root of the tree. Later rules override earlier ones and an empty
+// string means that the attribute is not specified.
+func (a *Attributes) Get(name, attr string) string {
+	if a == nil {
+		return ""
+	}
+	for i := len(a.rules) - 1; i >= 0; i-- {
+		r := &a.rules[i]
+		value, ok := r.attrs[attr]
+		if ok && matchPattern(r.pattern, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Uses returns true if any rule sets an attribute to a value, such as a
+// "filter=dots" rule for the "filter" attribute and "dots".
+func (a *Attributes) Uses(attr, value string) bool {
+	if a == nil {
+		return false
+	}
+	for _, r := range a.rules {
+		if v, ok := r.attrs[attr]; ok && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a rule that sets attributes like "filter=dots" for a pattern.
+func (a *Attributes) Add(pattern string, attrs ...string) {
+	var b strings.Builder
+	b.WriteString(pattern)
+	for _, attr := range attrs {
+		b.WriteByte(' ')
+		b.WriteString(attr)
+	}
+	parsed, _ := ParseAttributes(strings.NewReader(b.String()))
+	a.rules = append(a.rules, parsed.rules...)
+}
+
+func (a *Attributes) merge(other *Attributes) {
+	if other != nil {
+		a.rules = append(a.rules, other.rules...)
+	}
+}
+
+// matchPattern matches a gitignore style pattern. Patterns without a slash
+// match the base name at any depth, others are relative to the root and "**"
+// matches any number of directories.
+func matchPattern(pattern, name string) bool {
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchParts(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(name) > 0
+			}
+			for i := range name {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// readAttributes reads the .gitattributes file at the root of a working tree
+// followed by the repository's "info/attributes" which takes precedence.
+func readAttributes(workTree, gitDir string) (*Attributes, error) {
+	return readAttributesFiles(filepath.Join(workTree, AttributesFile), infoAttributes(gitDir))
+}
+
+func infoAttributes(gitDir string) string {
+	return filepath.Join(gitDir, "info", "attributes")
+}
+
+// readAttributesFiles merges the rules of attribute files. Missing files have
+// no rules.
+func readAttributesFiles(files ...string) (*Attributes, error) {
+	var a Attributes
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		parsed, err := ParseAttributes(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		a.merge(parsed)
+	}
+	return &a, nil
+}
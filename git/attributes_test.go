@@ -0,0 +1,123 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestAttributes(t *testing.T) {
+	is := is.New(t)
+	attrs, err := ParseAttributes(strings.NewReader(`
+# comment
+*.txt text
+/.ssh/config filter=dots
+.gnupg/** filter=dots -diff
+secret* filter=dots
+secret.pub !filter
+`))
+	is.NoErr(err)
+	for _, tt := range []struct {
+		name, attr, want string
+	}{
+		{"a.txt", "text", "true"},
+		{"dir/a.txt", "text", "true"},
+		{"a.txt", "filter", ""},
+		{".ssh/config", "filter", "dots"},
+		{"x/.ssh/config", "filter", ""},
+		{".gnupg/private-keys-v1.d/key", "filter", "dots"},
+		{".gnupg/private-keys-v1.d/key", "diff", "false"},
+		{".gnupg", "filter", ""},
+		{"dir/secret.key", "filter", "dots"},
+		{"dir/secret.pub", "filter", ""},
+	} {
+		is.Equal(attrs.Get(tt.name, tt.attr), tt.want) // wrong attribute
+	}
+	attrs.Add("/a.txt", "filter=dots")
+	is.Equal(attrs.Get("a.txt", "filter"), "dots")
+	is.Equal(attrs.Get("dir/a.txt", "filter"), "")
+	is.Equal((*Attributes)(nil).Get("a.txt", "text"), "")
+}
+
+// prefixFilter stores files with a prefix.
+type prefixFilter struct{}
+
+func (prefixFilter) Clean(_ string, data []byte) ([]byte, error) {
+	return append([]byte("clean:"), data...), nil
+}
+
+func (prefixFilter) Smudge(_ string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("clean:")) {
+		return nil, errors.New("not clean")
+	}
+	return bytes.TrimPrefix(data, []byte("clean:")), nil
+}
+
+func TestFilters(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git,
+		newfile(AttributesFile, "/secret filter=test\n"),
+		newfile("secret", "password\n"),
+		newfile("plain", "hello\n"),
+	))
+	tree := git.WorkingTree()
+	mem := NewMemory(tree)
+	mem.AppendPersistentArgs("-c", "user.name=DotsTests", "-c", "user.email=dots@example.com")
+	is.NoErr(mem.InitBare())
+	git.SetFilter("test", prefixFilter{})
+	mem.SetFilter("test", prefixFilter{})
+
+	author := Identity{Name: "Jane Doe", Email: "jane@example.com", When: time.Unix(1700000000, 0)}
+	_, err := git.CommitFiles([]string{AttributesFile, "secret", "plain"}, "add files", author)
+	is.NoErr(err)
+	is.NoErr(mem.Add(filepath.Join(tree, AttributesFile), filepath.Join(tree, "secret"), filepath.Join(tree, "plain")))
+	is.NoErr(mem.Commit("add files"))
+	is.Equal(must(mem.HeadCommit()).Tree, must(git.HeadCommit()).Tree)
+
+	var out bytes.Buffer
+	cmd := git.Cmd("show", "HEAD:secret")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	is.Equal(out.String(), "clean:password\n")
+
+	for _, b := range []Backend{git, mem} {
+		is.Equal(len(must(b.Modifications())), 0)
+		for f, err := range b.WalkTree("HEAD") {
+			is.NoErr(err)
+			if f.IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			is.NoErr(err)
+			data, err := io.ReadAll(rc)
+			is.NoErr(err)
+			is.NoErr(rc.Close())
+			want, err := os.ReadFile(filepath.Join(tree, f.Path))
+			is.NoErr(err)
+			is.Equal(string(data), string(want))
+		}
+		data, err := b.Smudge("secret", []byte("clean:password\n"))
+		is.NoErr(err)
+		is.Equal(string(data), "password\n")
+		_, err = b.Smudge("secret", []byte("password\n"))
+		is.True(err != nil)
+		data, err = b.Smudge("plain", []byte("hello\n"))
+		is.NoErr(err)
+		is.Equal(string(data), "hello\n")
+	}
+
+	is.NoErr(appendfile(filepath.Join(tree, "secret"), "more\n"))
+	for _, b := range []Backend{git, mem} {
+		mods := must(b.Modifications())
+		is.Equal(len(mods), 1)
+		is.Equal(mods[0].Name, "secret")
+	}
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"context"
+	"iter"
+)
+
+// Backend is the set of repository operations used by dots. [Git] runs the
+// git binary or reads the object store directly and [Memory] keeps the whole
+// repository in memory so that commands can be tested without git.
+type Backend interface {
+	// WorkingTree returns the directory that tracked paths are relative to.
+	WorkingTree() string
+	// Exists returns true if the repository has been created.
+	Exists() bool
+	// InitBare creates an empty repository.
+	InitBare() error
+	// AppendPersistentArgs adds arguments to every git command. Backends
+	// that do not run git only use the '-c' options.
+	AppendPersistentArgs(args ...string)
+
+	// Add stages files and directories like 'git add'.
+	Add(paths ...string) error
+	// AddUpdate stages the changes to tracked files like 'git add --update'.
+	AddUpdate(paths ...string) error
+	// Remove stops tracking files without deleting them like
+	// 'git rm --cached'.
+	Remove(files ...string) error
+	// Commit commits the index to the current branch.
+	Commit(message string) error
+
+	// LsFiles lists the files in the HEAD commit.
+	LsFiles() ([]string, error)
+	// ModifiedFiles lists the files that differ from the index like
+	// 'git diff-files'.
+	ModifiedFiles() ([]string, error)
+	// Modifications compares the HEAD commit with the index and working tree
+	// like 'git diff-index HEAD'.
+	Modifications() ([]*ModifiedFile, error)
+	// WalkTree yields the files of a commit in the same order as
+	// 'git archive'.
+	WalkTree(ref Ref) iter.Seq2[*TreeFile, error]
+	// OpenObject reads an object by its hash or a ref that points to it.
+	OpenObject(ref Ref) (*Object, error)
+	// Blame finds the commit that last changed each line of a file.
+	Blame(ref Ref, path string) ([]BlameLine, error)
+
+	// SetFilter sets the filter run for files with the "filter=<name>"
+	// attribute.
+	SetFilter(name string, f Filter)
+	// Smudge converts a blob to the contents it has in the working tree.
+	Smudge(path string, data []byte) ([]byte, error)
+
+	// Head returns the ref that HEAD points to.
+	Head() (Ref, error)
+	// CurrentBranch returns the name of the current branch.
+	CurrentBranch() (string, error)
+	// CreateRemoteRef sets the remote tracking branch of a remote.
+	CreateRemoteRef(remoteName, branchName string, ref Ref) error
+
+	// HasRemote returns true if the repository has a remote.
+	HasRemote() bool
+	// Pull fetches and merges the upstream branch.
+	Pull(ctx context.Context, args ...string) error
+	// Push updates the remote branch.
+	Push(ctx context.Context, args ...string) error
+
+	// Config reads the configuration from every scope.
+	Config() (*Config, error)
+	// ConfigLocalSet sets a value in the repository's config.
+	ConfigLocalSet(key, value string) error
+}
+
+var (
+	_ Backend = (*Git)(nil)
+	_ Backend = (*Memory)(nil)
+)
@@ -0,0 +1,251 @@
+package git
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"strings"
+
+	"github.com/harrybrwn/dots/diff"
+)
+
+// BlameLine is a line of a file and the commit that last changed it.
+type BlameLine struct {
+	Commit *Commit
+	// Previous is the parent that Commit was compared with. It is nil if the
+	// file was added by Commit.
+	Previous Hash
+	// OrigLine is the number of the line in Commit and FinalLine is its
+	// number in the blamed version of the file. Both start at one.
+	OrigLine, FinalLine int
+	// Text is the line including its newline.
+	Text string
+}
+
+// Blame finds the commit that last changed each line of a file in the commit
+// that a ref points to. The path is slash separated and relative to the root of
+// the tree.
+//
+// Like git, every parent of a merge is searched. Lines are passed to a parent
+// that has the same line and are only blamed on the merge when no parent has
+// them.
+func (g *Git) Blame(ref Ref, path string) ([]BlameLine, error) {
+	c, err := g.PeelCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	return blame(g, algo, c, path)
+}
+
+// suspect is a commit and the lines of the blamed file that have been passed
+// to it. The lines are pairs of the line's index in the final version and its
+// index in the commit's version of the file.
+type suspect struct {
+	entry *TreeEntry
+	lines [][2]int
+}
+
+// blame walks the history in the same order as [Git.Log] so that a commit is
+// only searched once all of its children have passed their lines to it. See
+// 'assign_blame' and 'pass_blame' in "blame.c".
+func blame(objects objectReader, algo HashAlgo, c *Commit, path string) ([]BlameLine, error) {
+	entry, err := findTreeEntry(objects, algo, c.Tree, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("no such path %q in %s", path, c.Hash)
+	}
+	data, err := readBlob(objects, entry)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lines    = diff.SplitLines(string(data))
+		res      = make([]BlameLine, len(lines))
+		queue    commitQueue
+		seq      int
+		suspects = make(map[string]*suspect)
+	)
+	// give passes lines to a commit and queues it if it is not queued yet.
+	give := func(c *Commit, entry *TreeEntry, lines [][2]int) {
+		if s, ok := suspects[string(c.Hash)]; ok {
+			s.lines = append(s.lines, lines...)
+			return
+		}
+		suspects[string(c.Hash)] = &suspect{entry: entry, lines: lines}
+		heap.Push(&queue, queuedCommit{Commit: c, seq: seq})
+		seq++
+	}
+	todo := make([][2]int, len(lines))
+	for i := range todo {
+		todo[i] = [2]int{i, i}
+	}
+	give(c, entry, todo)
+	for queue.Len() > 0 {
+		c := heap.Pop(&queue).(*Commit)
+		s := suspects[string(c.Hash)]
+		delete(suspects, string(c.Hash))
+		var (
+			parents = make([]*Commit, 0, len(c.Parents))
+			entries = make([]*TreeEntry, 0, len(c.Parents))
+		)
+		for _, hash := range c.Parents {
+			parent, err := openCommit(objects, NewHashRef(hash))
+			if err != nil {
+				return nil, err
+			}
+			prev, err := findTreeEntry(objects, algo, parent.Tree, path)
+			if err != nil {
+				return nil, err
+			}
+			if prev == nil || prev.Mode == TreeMode || prev.Mode == modeGitlink {
+				continue
+			}
+			if prev.Hash.Equal(s.entry.Hash) {
+				// The file is the same in this parent so it gets every line.
+				parents, entries = []*Commit{parent}, []*TreeEntry{prev}
+				break
+			}
+			parents, entries = append(parents, parent), append(entries, prev)
+		}
+		todo := s.lines
+		if len(parents) == 1 && entries[0].Hash.Equal(s.entry.Hash) {
+			give(parents[0], entries[0], todo)
+			continue
+		}
+		var cur []string
+		if len(parents) > 0 {
+			if data, err = readBlob(objects, s.entry); err != nil {
+				return nil, err
+			}
+			cur = diff.SplitLines(string(data))
+		}
+		for i, parent := range parents {
+			if data, err = readBlob(objects, entries[i]); err != nil {
+				return nil, err
+			}
+			from := lineOrigins(diff.SplitLines(string(data)), cur)
+			var passed, kept [][2]int
+			for _, t := range todo {
+				if k := from[t[1]]; k >= 0 {
+					passed = append(passed, [2]int{t[0], k})
+				} else {
+					kept = append(kept, t)
+				}
+			}
+			if len(passed) > 0 {
+				give(parent, entries[i], passed)
+			}
+			if todo = kept; len(todo) == 0 {
+				break
+			}
+		}
+		var previous Hash
+		if len(parents) > 0 {
+			previous = parents[0].Hash
+		}
+		for _, t := range todo {
+			res[t[0]] = BlameLine{
+				Commit:    c,
+				Previous:  previous,
+				OrigLine:  t[1] + 1,
+				FinalLine: t[0] + 1,
+				Text:      lines[t[0]],
+			}
+		}
+	}
+	return res, nil
+}
+
+// lineOrigins returns the index of the line in old that each line of cur came
+// from or -1 if the line was added.
+func lineOrigins(old, cur []string) []int {
+	from := make([]int, len(cur))
+	i, j := 0, 0
+	for _, op := range diff.Diff(old, cur) {
+		switch op {
+		case diff.Equal:
+			from[j] = i
+			i++
+			j++
+		case diff.Delete:
+			i++
+		case diff.Insert:
+			from[j] = -1
+			j++
+		}
+	}
+	return from
+}
+
+// findTreeEntry looks up a slash separated path in a tree. It returns nil if
+// the path does not exist.
+func findTreeEntry(objects objectReader, algo HashAlgo, tree Hash, path string) (*TreeEntry, error) {
+	var entry *TreeEntry
+	for _, name := range strings.Split(path, "/") {
+		if entry != nil {
+			if entry.Mode != TreeMode {
+				return nil, nil
+			}
+			tree = entry.Hash
+		}
+		obj, err := objects.OpenObject(NewHashRef(tree))
+		if err != nil {
+			return nil, err
+		}
+		if obj.Type != ObjTree {
+			return nil, fmt.Errorf("object %s is not a tree", obj.Hash)
+		}
+		entries, err := parseTree(obj.Data, algo.Size())
+		if err != nil {
+			return nil, err
+		}
+		entry = nil
+		for i := range entries {
+			if entries[i].Name == name {
+				entry = &entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, nil
+		}
+	}
+	return entry, nil
+}
+
+func readBlob(objects objectReader, entry *TreeEntry) ([]byte, error) {
+	if entry.Mode == TreeMode || entry.Mode == modeGitlink {
+		return nil, fmt.Errorf("%q is a directory", entry.Name)
+	}
+	obj, err := objects.OpenObject(NewHashRef(entry.Hash))
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != ObjBlob {
+		return nil, fmt.Errorf("object %s is not a blob", obj.Hash)
+	}
+	return obj.Data, nil
+}
+
+// openCommit reads a commit without following tags.
+func openCommit(objects objectReader, ref Ref) (*Commit, error) {
+	obj, err := objects.OpenObject(ref)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != ObjCommit {
+		return nil, fmt.Errorf("object %s is not a commit", obj.Hash)
+	}
+	var c Commit
+	if err = parseCommit(bytes.NewReader(obj.Data), &c); err != nil {
+		return nil, err
+	}
+	c.Hash, err = ParseHash(obj.Hash)
+	return &c, err
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_Blame(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	write := func(name, content, msg string) {
+		t.Helper()
+		p := filepath.Join(git.WorkingTree(), name)
+		is.NoErr(os.MkdirAll(filepath.Dir(p), 0755))
+		is.NoErr(os.WriteFile(p, []byte(content), 0644))
+		is.NoErr(git.Add(name))
+		is.NoErr(git.Commit(msg))
+	}
+	write("dir/rc", "a\nb\nc\n", "add rc")
+	write("other", "1\n", "add other")
+	write("dir/rc", "a\nB\nc\nd\n", "change b")
+	write("dir/rc", "x\na\nB\nc\nd", "prepend x")
+	write("other", "2\n", "change other")
+
+	lines, err := git.Blame("HEAD", "dir/rc")
+	is.NoErr(err)
+	is.Equal(len(lines), 5)
+	exp := gitBlame(t, git, "dir/rc")
+	for i, l := range lines {
+		is.Equal(fmt.Sprintf("%s %d %d", l.Commit.Hash, l.OrigLine, l.FinalLine), exp[i]) // should match git blame
+	}
+	is.Equal(lines[0].Commit.Message, "prepend x")
+	is.Equal(lines[1].Commit.Message, "add rc")
+	is.Equal(lines[1].Previous, Hash(nil))
+	is.Equal(lines[2].Commit.Message, "change b")
+	is.Equal(lines[2].Previous, lines[2].Commit.Parents[0])
+	is.Equal(lines[4].Text, "d")
+
+	_, err = git.Blame("HEAD", "dir/missing")
+	is.True(err != nil)
+	_, err = git.Blame("HEAD", "dir")
+	is.True(err != nil)
+
+	// Lines changed on a merged branch are blamed on the commits that changed
+	// them on the branch.
+	is.NoErr(git.RunCmd("checkout", "-q", "-b", "side"))
+	write("dir/rc", "x\na\nB\nc\nd\ne\n", "add e")
+	is.NoErr(git.RunCmd("checkout", "-q", "-"))
+	is.NoErr(git.RunCmd("merge", "-q", "--no-ff", "-m", "merge side", "side"))
+	lines, err = git.Blame("HEAD", "dir/rc")
+	is.NoErr(err)
+	is.Equal(lines[5].Commit.Message, "add e")
+	is.Equal(lines[4].Commit.Message, "add e") // gained a newline
+	is.Equal(lines[0].Commit.Message, "prepend x")
+
+	// Both sides of a merge change the file.
+	is.NoErr(git.RunCmd("checkout", "-q", "side"))
+	write("dir/rc", "x\na\nB\nc\nD\ne\n", "change d")
+	is.NoErr(git.RunCmd("checkout", "-q", "-"))
+	write("dir/rc", "X\na\nB\nc\nd\ne\n", "change x")
+	is.NoErr(git.RunCmd("merge", "-q", "--no-edit", "side"))
+	merge := must(git.HeadCommit())
+	is.Equal(len(merge.Parents), 2)
+	write("dir/rc", "X\na\nB\nc\nD\ne\nf\n", "add f")
+	lines, err = git.Blame("HEAD", "dir/rc")
+	is.NoErr(err)
+	exp = gitBlame(t, git, "dir/rc")
+	is.Equal(len(lines), len(exp))
+	for i, l := range lines {
+		is.Equal(fmt.Sprintf("%s %d %d", l.Commit.Hash, l.OrigLine, l.FinalLine), exp[i]) // should match git blame
+	}
+	is.Equal(lines[0].Commit.Message, "change x")
+	is.Equal(lines[4].Commit.Message, "change d")
+	is.Equal(lines[6].Commit.Message, "add f")
+}
+
+// gitBlame returns the "<hash> <orig line> <final line>" of each line from
+// 'git blame --porcelain'.
+func gitBlame(t *testing.T, git *Git, path string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := git.Cmd("blame", "--porcelain", "HEAD", "--", path)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || len(fields[0]) != 40 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		res = append(res, strings.Join(fields[:3], " "))
+	}
+	return res
+}
@@ -1,56 +1,367 @@
 package git
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/BurntSushi/toml"
+	"github.com/harrybrwn/dots/git/gitconfig"
 )
 
-type Config map[string]any
+// ConfigScope is where a config value was set. Scopes are listed from the
+// lowest to the highest precedence.
+type ConfigScope uint8
 
-func (c Config) Exists(key string) bool {
-	_, ok := c[key]
-	return ok
+const (
+	ScopeSystem ConfigScope = iota
+	ScopeGlobal
+	ScopeLocal
+	ScopeWorktree
+	// ScopeCommand are values from the environment and '-c' arguments.
+	ScopeCommand
+)
+
+func (s ConfigScope) String() string {
+	switch s {
+	case ScopeSystem:
+		return "system"
+	case ScopeGlobal:
+		return "global"
+	case ScopeLocal:
+		return "local"
+	case ScopeWorktree:
+		return "worktree"
+	case ScopeCommand:
+		return "command"
+	default:
+		return "unknown"
+	}
+}
+
+// ConfigEntry is a single config value and where it came from.
+type ConfigEntry struct {
+	// Key is the canonical key with a lower case section and name.
+	Key   string
+	Value string
+	Scope ConfigScope
+	// Origin is the file the value was read from or empty for values from
+	// the command line.
+	Origin string
+	Line   uint
+}
+
+// ShowOrigin formats the origin of the entry the same way as
+// 'git config --show-origin'.
+func (e *ConfigEntry) ShowOrigin() string {
+	if e.Scope == ScopeCommand {
+		return "command line:"
+	}
+	return "file:" + e.Origin
 }
 
-func (g *Git) Config() (Config, error) {
-	f, err := os.Open(filepath.Join(g.gitDir, "config"))
+// Config is the merged configuration of a repository.
+type Config struct {
+	entries []ConfigEntry
+}
+
+// Get returns the value of a key with the highest precedence.
+func (c *Config) Get(key string) (string, bool) {
+	e := c.Origin(key)
+	if e == nil {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// GetAll returns every value of a key from the lowest to the highest
+// precedence.
+func (c *Config) GetAll(key string) []string {
+	key, err := gitconfig.CanonicalKey(key)
 	if err != nil {
-		return nil, err
+		return nil
+	}
+	var values []string
+	for _, e := range c.entries {
+		if e.Key == key {
+			values = append(values, e.Value)
+		}
 	}
-	defer f.Close()
-	var _ toml.Unmarshaler
+	return values
+}
+
+// Exists returns true if the key has a value in any scope.
+func (c *Config) Exists(key string) bool { return c.Origin(key) != nil }
+
+// Origin returns the entry that sets the value of a key or nil if it is not
+// set.
+func (c *Config) Origin(key string) *ConfigEntry {
+	key, err := gitconfig.CanonicalKey(key)
+	if err != nil {
+		return nil
+	}
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].Key == key {
+			return &c.entries[i]
+		}
+	}
+	return nil
+}
+
+// Entries returns all the config entries from the lowest to the highest
+// precedence, the same order as 'git config --list'.
+func (c *Config) Entries() []ConfigEntry { return c.entries }
+
+// Len returns the number of entries.
+func (c *Config) Len() int { return len(c.entries) }
+
+// Config reads the configuration of the repository from every scope without
+// running git.
+func (g *Git) Config() (*Config, error) {
+	var c Config
+	for _, scope := range []ConfigScope{ScopeSystem, ScopeGlobal, ScopeLocal, ScopeWorktree, ScopeCommand} {
+		if err := g.readConfigScope(&c, scope); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// ConfigLocal reads the repository's own config file.
+func (g *Git) ConfigLocal() (*Config, error) {
+	var c Config
+	return &c, g.readConfigScope(&c, ScopeLocal)
+}
+
+// ConfigGlobal reads the user's global config files.
+func (g *Git) ConfigGlobal() (*Config, error) {
 	var c Config
-	meta, err := toml.NewDecoder(f).Decode(&c)
+	return &c, g.readConfigScope(&c, ScopeGlobal)
+}
+
+func (g *Git) readConfigScope(c *Config, scope ConfigScope) error {
+	if scope == ScopeCommand {
+		return readCommandConfig(c, g.args)
+	}
+	files, err := g.configFiles(scope, c)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	opts := gitconfig.Options{GitDir: g.gitDir}
+	if head, err := g.Head(); err == nil {
+		opts.Branch = strings.TrimPrefix(string(head), "refs/heads/")
 	}
-	if !meta.IsDefined("init.defaultbranch") {
-		c["init.defaultbranch"] = "main"
+	for _, filename := range files {
+		if !exists(filename) {
+			continue
+		}
+		conf, err := gitconfig.ParseFile(filename, &opts)
+		if err != nil {
+			return err
+		}
+		for _, e := range conf.Entries() {
+			c.entries = append(c.entries, ConfigEntry{
+				Key:    e.Key(),
+				Value:  configValue(&e),
+				Scope:  scope,
+				Origin: e.Origin,
+				Line:   e.Line,
+			})
+		}
 	}
-	return c, nil
+	return nil
 }
 
-func (g *Git) ConfigLocal() (Config, error) {
-	return g.config("--local", "--list")
+// configFiles returns the files of a scope. See 'do_git_config_sequence' in
+// "config.c".
+func (g *Git) configFiles(scope ConfigScope, c *Config) ([]string, error) {
+	switch scope {
+	case ScopeSystem:
+		if len(g.configSystem) > 0 {
+			return []string{g.configSystem}, nil
+		}
+		if v, ok := os.LookupEnv("GIT_CONFIG_NOSYSTEM"); ok {
+			if skip, _ := gitconfig.ParseBool(v); skip {
+				return nil, nil
+			}
+		}
+		if f, ok := os.LookupEnv("GIT_CONFIG_SYSTEM"); ok {
+			return []string{f}, nil
+		}
+		return []string{"/etc/gitconfig"}, nil
+	case ScopeGlobal:
+		if len(g.configGlobal) > 0 {
+			return []string{g.configGlobal}, nil
+		}
+		if f, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+			return []string{f}, nil
+		}
+		var files []string
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		home, err := os.UserHomeDir()
+		if len(xdg) == 0 && err == nil {
+			xdg = filepath.Join(home, ".config")
+		}
+		if len(xdg) > 0 {
+			files = append(files, filepath.Join(xdg, "git", "config"))
+		}
+		if err == nil {
+			files = append(files, filepath.Join(home, ".gitconfig"))
+		}
+		return files, nil
+	case ScopeLocal:
+		return []string{filepath.Join(g.gitDir, "config")}, nil
+	case ScopeWorktree:
+		// The worktree config is only read when the extension is enabled
+		// in the repository config.
+		for i := len(c.entries) - 1; i >= 0; i-- {
+			e := &c.entries[i]
+			if e.Key == "extensions.worktreeconfig" && e.Scope == ScopeLocal {
+				if ok, _ := gitconfig.ParseBool(e.Value); ok {
+					return []string{filepath.Join(g.gitDir, "config.worktree")}, nil
+				}
+				break
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown config scope %d", scope)
+}
+
+// readCommandConfig reads values from GIT_CONFIG_COUNT and the '-c' options in
+// the persistent arguments.
+func readCommandConfig(c *Config, args []string) error {
+	add := func(key, value string) error {
+		canonical, err := gitconfig.CanonicalKey(key)
+		if err != nil {
+			return fmt.Errorf("invalid config key %q: %w", key, err)
+		}
+		c.entries = append(c.entries, ConfigEntry{Key: canonical, Value: value, Scope: ScopeCommand})
+		return nil
+	}
+	if count, ok := os.LookupEnv("GIT_CONFIG_COUNT"); ok && len(count) > 0 {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bogus count in GIT_CONFIG_COUNT %q", count)
+		}
+		for i := range n {
+			key, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+			if !ok {
+				return fmt.Errorf("missing config key GIT_CONFIG_KEY_%d", i)
+			}
+			value, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+			if !ok {
+				return fmt.Errorf("missing config value GIT_CONFIG_VALUE_%d", i)
+			}
+			if err = add(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-c" {
+			continue
+		}
+		i++
+		key, value, ok := strings.Cut(args[i], "=")
+		if !ok {
+			value = "true" // '-c key' without a value is a boolean
+		}
+		if err := add(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (g *Git) ConfigGlobal() (Config, error) {
-	return g.config("--global", "--list")
+// configValue returns the value of an entry as 'git config --list' would show
+// it except that keys without a value are shown as "true".
+func configValue(e *gitconfig.Entry) string {
+	if e.NoValue {
+		return "true"
+	}
+	return e.Value
 }
 
+// ConfigSet sets a value in the repository's config file the same way as
+// 'git config <key> <value>'.
 func (g *Git) ConfigSet(key, value string) error {
-	return run(g.Cmd("config", key, value))
+	return g.ConfigLocalSet(key, value)
 }
 
+// ConfigLocalSet sets a value in the repository's config file without running
+// git.
 func (g *Git) ConfigLocalSet(key, value string) error {
-	return run(g.Cmd("config", "--local", key, value))
+	return setConfig(filepath.Join(g.gitDir, "config"), key, value)
 }
 
+// ConfigGlobalSet sets a value in the user's global config file without
+// running git.
 func (g *Git) ConfigGlobalSet(key, value string) error {
-	return run(g.Cmd("config", "--global", key, value))
+	filename, err := g.globalConfigFile()
+	if err != nil {
+		return err
+	}
+	return setConfig(filename, key, value)
+}
+
+// globalConfigFile returns the global config file that is written to. The
+// XDG config file is only used when it exists and "~/.gitconfig" does not.
+// See 'git_global_config' and 'cmd_config' in "builtin/config.c".
+func (g *Git) globalConfigFile() (string, error) {
+	var c Config
+	files, err := g.configFiles(ScopeGlobal, &c)
+	if err != nil {
+		return "", err
+	}
+	switch len(files) {
+	case 0:
+		return "", errors.New("$HOME not set")
+	case 1:
+		return files[0], nil
+	}
+	xdg, user := files[0], files[1]
+	if !exists(user) && exists(xdg) {
+		return xdg, nil
+	}
+	return user, nil
+}
+
+// setConfig sets a value in a config file. The file's lock is held while it
+// is read and changed so that concurrent changes are not lost. See
+// 'repo_config_set_multivar_in_file_gently' in "config.c".
+func setConfig(filename, key, value string) (err error) {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	lock, err := newLockFile(filename, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = lock.Rollback()
+		}
+	}()
+	conf, err := gitconfig.ParseFile(filename, nil)
+	if err != nil {
+		return err
+	}
+	if err = conf.Set(key, value); err != nil {
+		return err
+	}
+	raw, err := conf.MarshalText()
+	if err != nil {
+		return err
+	}
+	if _, err = lock.Write(raw); err != nil {
+		return err
+	}
+	return lock.Commit()
 }
 
 func (g *Git) SetArgs(arguments ...string) { g.args = arguments }
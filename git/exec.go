@@ -0,0 +1,195 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Errors that an [ExitError] can be matched against using [errors.Is]. They
+// are detected from the output of the failed command.
+var (
+	// ErrNoUpstream means the current branch does not track a remote branch.
+	ErrNoUpstream = errors.New("no upstream branch")
+	// ErrMergeConflict means a merge, pull or rebase stopped with conflicts.
+	ErrMergeConflict = errors.New("merge conflict")
+	// ErrAuthFailed means the remote rejected the credentials or none were
+	// available.
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrNotRepository means the git directory is not a repository.
+	ErrNotRepository = errors.New("not a git repository")
+)
+
+// errorPatterns are substrings of git's output used to classify errors.
+var errorPatterns = map[error][]string{
+	ErrNoUpstream: {
+		"There is no tracking information for the current branch",
+		"has no upstream branch",
+		"no upstream configured for branch",
+	},
+	ErrMergeConflict: {
+		"CONFLICT (",
+		"Automatic merge failed",
+		"fix conflicts and then commit the result",
+		"Resolve all conflicts manually",
+		"You have not concluded your merge",
+	},
+	ErrAuthFailed: {
+		"Authentication failed",
+		"Permission denied (publickey",
+		"could not read Username",
+		"could not read Password",
+		"terminal prompts disabled",
+		"Invalid username or password",
+		"HTTP Basic: Access denied",
+	},
+	ErrNotRepository: {
+		"not a git repository",
+	},
+}
+
+// maxErrorOutput limits how much of a command's output is kept for errors.
+const maxErrorOutput = 8 << 10
+
+// ExitError is returned when a git command fails.
+type ExitError struct {
+	// Args are the arguments passed to git.
+	Args []string
+	// ExitCode is the exit status of git or -1 if it was killed.
+	ExitCode int
+	// Stderr is the standard error output of the command.
+	Stderr string
+	// Err is the underlying error. It is the context's error if the command
+	// was stopped because the context was canceled or timed out.
+	Err error
+
+	stdout string
+}
+
+func (e *ExitError) Error() string {
+	msg := strings.Trim(e.Stderr, "\n")
+	if len(msg) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// Is matches the error against the sentinel errors of this package.
+func (e *ExitError) Is(target error) bool {
+	patterns, ok := errorPatterns[target]
+	if !ok {
+		return false
+	}
+	for _, p := range patterns {
+		if strings.Contains(e.Stderr, p) || strings.Contains(e.stdout, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTimeout sets the time limit of commands run by methods that take a
+// context when the context has no deadline. Zero disables the limit.
+func (g *Git) SetTimeout(d time.Duration) { g.timeout = d }
+
+func (g *Git) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || g.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+// RunContext runs a git command and returns an [*ExitError] if it fails.
+func (g *Git) RunContext(ctx context.Context, args ...string) error {
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+	return runContext(ctx, g.CmdContext(ctx, args...))
+}
+
+// Pull runs 'git pull' with optional arguments such as a remote and branch.
+func (g *Git) Pull(ctx context.Context, args ...string) error {
+	return g.RunContext(ctx, append([]string{"pull"}, args...)...)
+}
+
+// Push runs 'git push' with optional arguments such as a remote and branch.
+func (g *Git) Push(ctx context.Context, args ...string) error {
+	return g.RunContext(ctx, append([]string{"push"}, args...)...)
+}
+
+// Fetch runs 'git fetch' with optional arguments.
+func (g *Git) Fetch(ctx context.Context, args ...string) error {
+	return g.RunContext(ctx, append([]string{"fetch"}, args...)...)
+}
+
+func run(cmd *exec.Cmd) error {
+	return runContext(context.Background(), cmd)
+}
+
+// runContext runs a command created with ctx. The standard error is always
+// captured and the standard output is copied so that failures can be
+// classified.
+func runContext(ctx context.Context, cmd *exec.Cmd) error {
+	var stderr, stdout tailBuffer
+	cmd.Stderr = &stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = &stdout
+	} else {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, &stdout)
+	}
+	// Give git time to exit after being killed before giving up on the
+	// output pipes which may be held open by child processes like ssh.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	e := &ExitError{
+		Args:     cmd.Args[1:],
+		ExitCode: -1,
+		Stderr:   stderr.String(),
+		Err:      err,
+		stdout:   stdout.String(),
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		e.ExitCode = exit.ExitCode()
+	} else if !errors.Is(err, exec.ErrWaitDelay) && ctx.Err() == nil {
+		return err // git could not be started
+	}
+	if ctx.Err() != nil {
+		e.Err = ctx.Err()
+	}
+	return e
+}
+
+// tailBuffer keeps the last part of the data written to it.
+type tailBuffer struct{ bytes.Buffer }
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > maxErrorOutput {
+		p = p[len(p)-maxErrorOutput:]
+	}
+	if over := b.Len() + len(p) - maxErrorOutput; over > 0 {
+		b.Next(over)
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// setEnv sets an environment variable of a command. The variables of the
+// current process are inherited.
+func setEnv(cmd *exec.Cmd, key, value string) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, key+"="+value)
+}
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_RunContext(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(git, newfile("one", "this is the first file\n")))
+	ctx := context.Background()
+
+	err := git.RunContext(ctx, "rev-parse", "--verify", "missing")
+	var exit *ExitError
+	is.True(errors.As(err, &exit))
+	is.Equal(exit.ExitCode, 128)
+	is.True(slices.Contains(exit.Args, "rev-parse"))
+	is.True(len(exit.Stderr) > 0)
+	is.True(!errors.Is(err, ErrMergeConflict))
+
+	err = git.Pull(ctx)
+	is.True(errors.Is(err, ErrNoUpstream))
+
+	branch := must(git.CurrentBranch())
+	is.NoErr(git.RunCmd("checkout", "-q", "-b", "other"))
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "one"), []byte("changed on other\n"), 0644))
+	is.NoErr(git.RunCmd("commit", "-q", "-am", "other"))
+	is.NoErr(git.RunCmd("checkout", "-q", branch))
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "one"), []byte("changed on "+branch+"\n"), 0644))
+	is.NoErr(git.RunCmd("commit", "-q", "-am", branch))
+	err = git.RunContext(ctx, "merge", "other")
+	is.True(errors.Is(err, ErrMergeConflict)) // conflicts are reported on stdout
+	is.Equal(err.(*ExitError).ExitCode, 1)
+	is.NoErr(git.RunCmd("merge", "--abort"))
+
+	git.SetTimeout(50 * time.Millisecond)
+	start := time.Now()
+	err = git.RunContext(ctx, "-c", "alias.slow=!sleep 10", "slow")
+	is.True(errors.Is(err, context.DeadlineExceeded))
+	is.True(time.Since(start) < 5*time.Second)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = git.RunContext(cancelled, "status")
+	is.True(errors.Is(err, context.Canceled))
+}
+
+func TestExitError_Is(t *testing.T) {
+	is := is.New(t)
+	for stderr, target := range map[string]error{
+		"fatal: Authentication failed for 'https://github.com/a/b.git/'\n":            ErrAuthFailed,
+		"git@github.com: Permission denied (publickey).\n":                            ErrAuthFailed,
+		"fatal: The current branch main has no upstream branch.\n":                    ErrNoUpstream,
+		"fatal: not a git repository (or any of the parent directories): .git\n":      ErrNotRepository,
+		"error: You have not concluded your merge (MERGE_HEAD exists).\n":             ErrMergeConflict,
+		"fatal: could not read Username for 'https://github.com': No such device\n":   ErrAuthFailed,
+		"fatal: unable to access 'https://example.com/': Could not resolve host: x\n": nil,
+	} {
+		err := &ExitError{Stderr: stderr, ExitCode: 128, Err: errors.New("exit status 128")}
+		for _, sentinel := range []error{ErrAuthFailed, ErrNoUpstream, ErrNotRepository, ErrMergeConflict} {
+			is.Equal(errors.Is(err, sentinel), sentinel == target) // stderr should only match its error
+		}
+	}
+}
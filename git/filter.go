@@ -0,0 +1,135 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Filter converts files between the working tree and the repository like the
+// filter driver named by the "filter" attribute. See gitattributes(5).
+type Filter interface {
+	// Clean converts the contents of a working tree file before it is
+	// stored. The path is slash separated and relative to the working tree.
+	Clean(path string, data []byte) ([]byte, error)
+	// Smudge converts the contents of a blob before it is written to the
+	// working tree.
+	Smudge(path string, data []byte) ([]byte, error)
+}
+
+// filters holds the filters set with SetFilter by name. Native commands run
+// them for files with a matching "filter" attribute while the git binary runs
+// the "filter.<name>.clean" and "filter.<name>.smudge" commands from the
+// config instead. Like git, files whose filter has not been set are left
+// unchanged.
+type filters map[string]Filter
+
+func (fs *filters) set(name string, f Filter) {
+	if *fs == nil {
+		*fs = make(filters)
+	}
+	(*fs)[name] = f
+}
+
+// pathFilters finds the filter of a path from its attributes. A nil
+// *pathFilters has no filters.
+type pathFilters struct {
+	attrs   *Attributes
+	filters filters
+}
+
+// newPathFilters returns nil if there are no filters so that the attributes
+// are only read when they are needed.
+func newPathFilters(fs filters, read func() (*Attributes, error)) (*pathFilters, error) {
+	if len(fs) == 0 {
+		return nil, nil
+	}
+	attrs, err := read()
+	if err != nil {
+		return nil, err
+	}
+	return &pathFilters{attrs: attrs, filters: fs}, nil
+}
+
+func (pf *pathFilters) get(name string) Filter {
+	if pf == nil {
+		return nil
+	}
+	return pf.filters[pf.attrs.Get(name, "filter")]
+}
+
+func (pf *pathFilters) clean(name string, data []byte) ([]byte, error) {
+	f := pf.get(name)
+	if f == nil {
+		return data, nil
+	}
+	out, err := f.Clean(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: clean filter failed: %w", name, err)
+	}
+	return out, nil
+}
+
+func (pf *pathFilters) smudge(name string, data []byte) ([]byte, error) {
+	f := pf.get(name)
+	if f == nil {
+		return data, nil
+	}
+	out, err := f.Smudge(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: smudge filter failed: %w", name, err)
+	}
+	return out, nil
+}
+
+// SetFilter sets the filter that native commands run for files with the
+// "filter=<name>" attribute.
+func (g *Git) SetFilter(name string, f Filter) { g.filters.set(name, f) }
+
+// Smudge converts a blob to the contents it has in the working tree using the
+// filter set for the path by the attributes of the working tree.
+func (g *Git) Smudge(path string, data []byte) ([]byte, error) {
+	pf, err := g.worktreeFilters()
+	if err != nil {
+		return nil, err
+	}
+	return pf.smudge(path, data)
+}
+
+// worktreeFilters reads the filters of files in the working tree.
+func (g *Git) worktreeFilters() (*pathFilters, error) {
+	return newPathFilters(g.filters, func() (*Attributes, error) {
+		return readAttributes(g.workTree, g.gitDir)
+	})
+}
+
+// treeFilters reads the filters of files in a tree from its .gitattributes
+// file. This is used when checking out a tree since the working tree may not
+// have the attributes yet.
+func treeFilters(fs filters, objects objectReader, algo HashAlgo, tree Hash, gitDir string) (*pathFilters, error) {
+	return newPathFilters(fs, func() (*Attributes, error) {
+		var attrs Attributes
+		e, err := findTreeEntry(objects, algo, tree, AttributesFile)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil && e.Mode != TreeMode && e.Mode != modeGitlink {
+			data, err := readBlob(objects, e)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := ParseAttributes(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			attrs.merge(parsed)
+		}
+		if gitDir != "" {
+			info, err := readAttributesFiles(infoAttributes(gitDir))
+			if err != nil {
+				return nil, err
+			}
+			attrs.merge(info)
+		}
+		return &attrs, nil
+	})
+}
@@ -0,0 +1,449 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FsckKind is the kind of problem found by [Git.Fsck].
+type FsckKind uint8
+
+const (
+	// FsckCorrupt objects cannot be read or their contents do not match
+	// their name.
+	FsckCorrupt FsckKind = iota + 1
+	// FsckMissing objects are referenced by an object, a ref or the index
+	// but are not in the object store.
+	FsckMissing
+	// FsckDangling objects are not referenced by anything. They are harmless
+	// and are left behind by things like 'git commit --amend'.
+	FsckDangling
+	// FsckBadRef refs or reflogs cannot be read.
+	FsckBadRef
+	// FsckBadIndex means that the index file is damaged.
+	FsckBadIndex
+	// FsckBadPack means that a pack file or its index does not match its
+	// checksum.
+	FsckBadPack
+)
+
+// FsckProblem is a single problem found by [Git.Fsck].
+type FsckProblem struct {
+	Kind FsckKind
+	// Hash is the object with the problem. It is nil for problems with refs,
+	// packs and the index.
+	Hash Hash
+	// Type is the type of the object if it is known.
+	Type ObjectType
+	// Name is the ref, pack or file where the problem was found. For missing
+	// objects it is what referenced the object.
+	Name string
+	Err  error
+}
+
+func (p *FsckProblem) String() string {
+	typ := "object"
+	if p.Type != ObjUnknown {
+		typ = p.Type.String()
+	}
+	switch p.Kind {
+	case FsckCorrupt:
+		return fmt.Sprintf("corrupt %s %s: %v", typ, p.Hash, p.Err)
+	case FsckMissing:
+		return fmt.Sprintf("missing %s %s (from %s)", typ, p.Hash, p.Name)
+	case FsckDangling:
+		return fmt.Sprintf("dangling %s %s", typ, p.Hash)
+	case FsckBadRef:
+		return fmt.Sprintf("bad ref %s: %v", p.Name, p.Err)
+	case FsckBadIndex:
+		return fmt.Sprintf("bad index %s: %v", p.Name, p.Err)
+	case FsckBadPack:
+		return fmt.Sprintf("bad pack %s: %v", p.Name, p.Err)
+	default:
+		return fmt.Sprintf("%s: %v", p.Name, p.Err)
+	}
+}
+
+// FsckReport is the result of [Git.Fsck].
+type FsckReport struct {
+	// Objects is the number of objects that were checked.
+	Objects  int
+	Problems []FsckProblem
+}
+
+// OK returns true if there are no problems other than dangling objects.
+func (r *FsckReport) OK() bool {
+	return !slices.ContainsFunc(r.Problems, func(p FsckProblem) bool {
+		return p.Kind != FsckDangling
+	})
+}
+
+var errHashMismatch = errors.New("hash does not match the contents")
+
+// Fsck checks the integrity of the repository like 'git fsck'. Every loose and
+// packed object is hashed and compared with its name, the pack files and the
+// index are compared with their checksums and the objects referenced by other
+// objects, refs, reflogs and the index are checked to exist. An error is only
+// returned if the repository cannot be read at all, damage is reported in the
+// problems of the report.
+func (g *Git) Fsck() (*FsckReport, error) {
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	f := fsck{
+		git:    g,
+		algo:   algo,
+		types:  make(map[string]ObjectType),
+		refs:   make(map[string]string),
+		wanted: make(map[string]ObjectType),
+	}
+	if err := f.objects(); err != nil {
+		return nil, err
+	}
+	f.refNames()
+	f.reflogs()
+	f.index()
+
+	for hash, from := range f.refs {
+		if _, ok := f.types[hash]; !ok {
+			f.add(FsckProblem{Kind: FsckMissing, Hash: mustHash(hash), Type: f.wanted[hash], Name: from})
+		}
+	}
+	for hash, typ := range f.types {
+		if _, ok := f.refs[hash]; !ok && typ != ObjUnknown {
+			f.add(FsckProblem{Kind: FsckDangling, Hash: mustHash(hash), Type: typ})
+		}
+	}
+	slices.SortStableFunc(f.report.Problems, func(a, b FsckProblem) int {
+		if a.Kind != b.Kind {
+			return int(a.Kind) - int(b.Kind)
+		}
+		if c := bytes.Compare(a.Hash, b.Hash); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return &f.report, nil
+}
+
+type fsck struct {
+	git    *Git
+	algo   HashAlgo
+	report FsckReport
+	// types holds the type of every object in the store by its hex name.
+	// Corrupt objects have an unknown type.
+	types map[string]ObjectType
+	// refs maps every referenced object to the first thing found that
+	// references it and wanted is the type it is expected to have.
+	refs   map[string]string
+	wanted map[string]ObjectType
+}
+
+func (f *fsck) add(p FsckProblem) { f.report.Problems = append(f.report.Problems, p) }
+
+func (f *fsck) ref(hash Hash, typ ObjectType, from string) {
+	name := hash.String()
+	if _, ok := f.refs[name]; ok {
+		return
+	}
+	f.refs[name] = from
+	f.wanted[name] = typ
+}
+
+// objects checks every loose and packed object.
+func (f *fsck) objects() error {
+	dir := filepath.Join(f.git.gitDir, "objects")
+	fans, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fan := range fans {
+		if !fan.IsDir() || len(fan.Name()) != 2 || !isHex(fan.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, fan.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := fan.Name() + file.Name()
+			if len(name) != f.algo.HexSize() || !isHex(name) {
+				continue
+			}
+			obj, err := f.git.openLooseObject(name)
+			f.object(name, obj, err)
+		}
+	}
+
+	// A new pack store is used so that packs removed since the repository
+	// was last read are not checked.
+	packs := packStore{
+		dir:   filepath.Join(dir, "pack"),
+		algo:  f.algo,
+		cache: newPackCache(packCacheEntries, packCacheBytes),
+	}
+	defer packs.close()
+	if err = packs.scan(); err != nil {
+		return err
+	}
+	for _, p := range packs.packs {
+		if err := f.packChecksums(p); err != nil {
+			f.add(FsckProblem{Kind: FsckBadPack, Name: filepath.Base(p.path), Err: err})
+		}
+		for i := range p.count() {
+			hash := p.name(i)
+			name := hex.EncodeToString(hash)
+			if _, ok := f.types[name]; ok {
+				continue // also a loose object
+			}
+			obj, err := packs.read(hash)
+			f.object(name, obj, err)
+		}
+	}
+	return nil
+}
+
+// object checks that an object matches its name and records what it
+// references.
+func (f *fsck) object(name string, obj *Object, err error) {
+	f.report.Objects++
+	f.types[name] = ObjUnknown
+	hash := mustHash(name)
+	if err != nil {
+		f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Err: err})
+		return
+	}
+	if obj.Size != uint64(len(obj.Data)) {
+		f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Type: obj.Type, Err: fmt.Errorf("size %d does not match the header", len(obj.Data))})
+		return
+	}
+	if !bytes.Equal(objectHash(f.algo, obj.Type, obj.Size, bytes.NewReader(obj.Data)), hash) {
+		f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Type: obj.Type, Err: errHashMismatch})
+		return
+	}
+	from := obj.Type.String() + " " + name
+	switch obj.Type {
+	case ObjBlob:
+	case ObjTree:
+		entries, err := parseTree(obj.Data, f.algo.Size())
+		if err != nil {
+			f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Type: obj.Type, Err: err})
+			return
+		}
+		for _, e := range entries {
+			switch e.Mode {
+			case modeGitlink:
+				// submodule commits are in another repository
+			case TreeMode:
+				f.ref(e.Hash, ObjTree, from)
+			default:
+				f.ref(e.Hash, ObjBlob, from)
+			}
+		}
+	case ObjCommit:
+		var c Commit
+		if err := parseCommit(bufio.NewReader(bytes.NewReader(obj.Data)), &c); err != nil {
+			f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Type: obj.Type, Err: err})
+			return
+		}
+		f.ref(c.Tree, ObjTree, from)
+		for _, p := range c.Parents {
+			f.ref(p, ObjCommit, from)
+		}
+	case ObjTag:
+		var t Tag
+		if err := parseTag(obj.Data, &t); err != nil {
+			f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Type: obj.Type, Err: err})
+			return
+		}
+		f.ref(t.Object, t.Type, from)
+	default:
+		f.add(FsckProblem{Kind: FsckCorrupt, Hash: hash, Err: errors.New("unknown object type")})
+		return
+	}
+	f.types[name] = obj.Type
+}
+
+// packChecksums checks the trailing checksums of a pack and its index. The
+// index ends with the checksum of the pack followed by its own checksum.
+func (f *fsck) packChecksums(p *packfile) error {
+	size := f.algo.Size()
+	sum := func(path string) (content, trailer []byte, err error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.Size() < int64(size) {
+			return nil, nil, errors.New("file too short")
+		}
+		h := f.algo.New()
+		if _, err = io.CopyN(h, file, info.Size()-int64(size)); err != nil {
+			return nil, nil, err
+		}
+		trailer, err = io.ReadAll(file)
+		return h.Sum(nil), trailer, err
+	}
+	packSum, packTrailer, err := sum(p.path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(packSum, packTrailer) {
+		return errors.New("pack checksum mismatch")
+	}
+	idxPath := strings.TrimSuffix(p.path, ".pack") + ".idx"
+	idxSum, idxTrailer, err := sum(idxPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(idxSum, idxTrailer) {
+		return errors.New("index checksum mismatch")
+	}
+	raw, err := os.ReadFile(idxPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(raw[len(raw)-2*size:len(raw)-size], packSum) {
+		return errors.New("index is for a different pack")
+	}
+	return nil
+}
+
+// refNames checks HEAD and every loose and packed ref.
+func (f *fsck) refNames() {
+	check := func(name string, ref Ref) {
+		if !ref.IsHash() {
+			return // symbolic refs are checked through their target
+		}
+		hash, err := ParseHash(string(ref))
+		if err != nil {
+			f.add(FsckProblem{Kind: FsckBadRef, Name: name, Err: err})
+			return
+		}
+		f.ref(hash, ObjUnknown, name)
+	}
+	head, err := readRef(filepath.Join(f.git.gitDir, "HEAD"))
+	if err != nil {
+		f.add(FsckProblem{Kind: FsckBadRef, Name: "HEAD", Err: err})
+	} else {
+		check("HEAD", head)
+	}
+	packed, err := f.git.packedRefs()
+	if err != nil {
+		f.add(FsckProblem{Kind: FsckBadRef, Name: "packed-refs", Err: err})
+	}
+	for _, p := range packed {
+		check(p.name, p.hash)
+		if p.peeled != "" {
+			check(p.name+"^{}", p.peeled)
+		}
+	}
+	root := filepath.Join(f.git.gitDir, "refs")
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := "refs/" + filepath.ToSlash(strings.TrimPrefix(path, root+string(filepath.Separator)))
+		ref, err := readRef(path)
+		if err == nil && !ref.IsHash() && !strings.HasPrefix(string(ref), "refs/") {
+			err = fmt.Errorf("invalid ref %q", ref)
+		}
+		if err != nil {
+			f.add(FsckProblem{Kind: FsckBadRef, Name: name, Err: err})
+			return nil
+		}
+		check(name, ref)
+		return nil
+	})
+}
+
+// reflogs checks that the objects in every reflog exist. Entries that delete a
+// ref have a zero hash and are skipped.
+func (f *fsck) reflogs() {
+	root := filepath.Join(f.git.gitDir, "logs")
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := "logs/" + filepath.ToSlash(strings.TrimPrefix(path, root+string(filepath.Separator)))
+		file, err := os.Open(path)
+		if err != nil {
+			f.add(FsckProblem{Kind: FsckBadRef, Name: name, Err: err})
+			return nil
+		}
+		defer file.Close()
+		logs, err := parseLogs(file)
+		if err != nil {
+			f.add(FsckProblem{Kind: FsckBadRef, Name: name, Err: err})
+			return nil
+		}
+		for _, l := range logs {
+			for _, h := range []Hash{l.Prev, l.Hash} {
+				if !h.IsZero() {
+					f.ref(h, ObjUnknown, name)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// index checks the checksum of the index and that its blobs exist.
+func (f *fsck) index() {
+	filename := f.git.indexFile()
+	raw, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		f.add(FsckProblem{Kind: FsckBadIndex, Name: "index", Err: err})
+		return
+	}
+	size := f.algo.Size()
+	if len(raw) >= size {
+		// A zero checksum is written when index.skipHash is set.
+		sum := f.algo.New()
+		sum.Write(raw[:len(raw)-size])
+		trailer := raw[len(raw)-size:]
+		if !Hash(trailer).IsZero() && !bytes.Equal(sum.Sum(nil), trailer) {
+			f.add(FsckProblem{Kind: FsckBadIndex, Name: "index", Err: errors.New("checksum mismatch")})
+			return
+		}
+	}
+	ix, err := readIndex(bytes.NewReader(raw), f.algo)
+	if err != nil {
+		f.add(FsckProblem{Kind: FsckBadIndex, Name: "index", Err: err})
+		return
+	}
+	for i := range ix.entries {
+		e := &ix.entries[i]
+		if e.mode == modeGitlink || e.intentToAdd() {
+			continue
+		}
+		f.ref(Hash(e.oid), ObjBlob, "index "+e.name)
+	}
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// mustHash decodes a hex name that has already been checked.
+func mustHash(name string) Hash {
+	h, _ := hex.DecodeString(name)
+	return h
+}
@@ -0,0 +1,108 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_Fsck(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+	))
+	fsck := func() *FsckReport {
+		t.Helper()
+		r, err := git.Fsck()
+		is.NoErr(err)
+		return r
+	}
+	problems := func(r *FsckReport) []string {
+		res := make([]string, len(r.Problems))
+		for i, p := range r.Problems {
+			res[i] = p.String()
+		}
+		return res
+	}
+	hashObject := func(data string) string {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := git.Cmd("hash-object", "-w", "--stdin")
+		cmd.Stdin = strings.NewReader(data)
+		cmd.Stdout = &out
+		is.NoErr(cmd.Run())
+		return strings.TrimSpace(out.String())
+	}
+	flip := func(name string, offset int) {
+		t.Helper()
+		raw, err := os.ReadFile(name)
+		is.NoErr(err)
+		if offset < 0 {
+			offset += len(raw)
+		}
+		raw[offset] ^= 0xff
+		is.NoErr(os.Chmod(name, 0644))
+		is.NoErr(os.WriteFile(name, raw, 0644))
+	}
+
+	r := fsck()
+	is.True(r.OK())
+	is.Equal(len(r.Problems), 0)
+	is.Equal(r.Objects, 7) // 2 blobs, 2 trees and 3 commits
+	dangling := hashObject("not referenced\n")
+	r = fsck()
+	is.True(r.OK())
+	is.Equal(problems(r), []string{"dangling blob " + dangling})
+
+	// Packed objects are checked the same way.
+	is.NoErr(run(git.Cmd("repack", "-a", "-d", "-q")))
+	r = fsck()
+	is.True(r.OK())
+	is.Equal(r.Objects, 8)
+	packs, err := filepath.Glob(filepath.Join(git.gitDir, "objects/pack/*.pack"))
+	is.NoErr(err)
+	is.Equal(len(packs), 1)
+	flip(packs[0], -1)
+	r = fsck()
+	is.True(!r.OK())
+	is.Equal(problems(r)[1], "bad pack "+filepath.Base(packs[0])+": pack checksum mismatch")
+	flip(packs[0], -1)
+	is.True(fsck().OK())
+
+	// A loose object with the wrong contents.
+	corrupt := hashObject("will be replaced\n")
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	_, _ = zw.Write([]byte("blob 4\x00abc\n"))
+	is.NoErr(zw.Close())
+	is.NoErr(os.Chmod(git.objectFilename(corrupt), 0644))
+	is.NoErr(os.WriteFile(git.objectFilename(corrupt), b.Bytes(), 0644))
+
+	// A blob that was committed and then lost.
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "three"), []byte("lost\n"), 0644))
+	is.NoErr(git.Add("three"))
+	is.NoErr(git.Commit("add three"))
+	lost := hashObject("lost\n")
+	is.NoErr(os.Remove(git.objectFilename(lost)))
+
+	is.NoErr(os.WriteFile(filepath.Join(git.gitDir, "refs/heads/bad"), []byte("garbage\n"), 0644))
+	flip(git.indexFile(), 20)
+
+	r = fsck()
+	is.True(!r.OK())
+	got := problems(r)
+	is.Equal(len(got), 5)
+	is.Equal(got[0], "corrupt blob "+corrupt+": hash does not match the contents")
+	is.True(strings.HasPrefix(got[1], "missing blob "+lost+" (from tree ")) // only the tree since the index is damaged
+	is.Equal(got[2], "dangling blob "+dangling)
+	is.True(strings.HasPrefix(got[3], "bad ref refs/heads/bad: "))
+	is.Equal(got[4], "bad index index: checksum mismatch")
+}
@@ -6,7 +6,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -17,6 +17,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -43,29 +44,41 @@ type Git struct {
 
 	configGlobal string
 	configSystem string
+	// timeout is the default time limit for commands run with a context
+	// that has no deadline.
+	timeout time.Duration
+
+	algo    *HashAlgo
+	packs   *packStore
+	filters filters
 }
 
 func (g *Git) Cmd(args ...string) *exec.Cmd {
-	cmd := g.newCmd(args)
+	return g.CmdContext(context.Background(), args...)
+}
+
+// CmdContext creates a git command that is killed when the context is done.
+func (g *Git) CmdContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := g.newCmd(ctx, args)
 	if len(g.configGlobal) > 0 {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_GLOBAL=%s", g.configGlobal))
+		setEnv(cmd, "GIT_CONFIG_GLOBAL", g.configGlobal)
 	}
 	if len(g.configSystem) > 0 {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_SYSTEM=%s", g.configSystem))
+		setEnv(cmd, "GIT_CONFIG_SYSTEM", g.configSystem)
 	}
 	g.setDefaultIO(cmd)
 	return cmd
 }
 
 func (g *Git) CmdWithEnv(args []string, env map[string]string) *exec.Cmd {
-	cmd := g.Cmd()
+	cmd := g.Cmd(args...)
 	for k, v := range env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		setEnv(cmd, k, v)
 	}
 	return cmd
 }
 
-func (g *Git) newCmd(args []string) *exec.Cmd {
+func (g *Git) newCmd(ctx context.Context, args []string) *exec.Cmd {
 	arguments := make([]string, 4, 4+len(args)+len(g.args))
 	arguments[0] = "--git-dir"
 	arguments[1] = g.gitDir
@@ -73,17 +86,21 @@ func (g *Git) newCmd(args []string) *exec.Cmd {
 	arguments[3] = g.workTree
 	arguments = append(arguments, g.args...)
 	arguments = append(arguments, args...)
-	return exec.Command(gitExec, arguments...)
+	return exec.CommandContext(ctx, gitExec, arguments...)
 }
 
-func (g *Git) RunCmd(args ...string) error { return run(g.Cmd(args...)) }
+func (g *Git) RunCmd(args ...string) error {
+	return g.RunContext(context.Background(), args...)
+}
 
 func (g *Git) RunCmdWithEnv(env map[string]string, args ...string) error {
-	c := g.Cmd(args...)
+	ctx, cancel := g.withTimeout(context.Background())
+	defer cancel()
+	c := g.CmdContext(ctx, args...)
 	for k, v := range env {
-		c.Env = append(c.Env, fmt.Sprintf("%s=%s", k, v))
+		setEnv(c, k, v)
 	}
-	return run(c)
+	return runContext(ctx, c)
 }
 
 func (g *Git) Exists() bool {
@@ -248,12 +265,19 @@ type ModifiedFile struct {
 
 type ObjModification struct {
 	Mode int    // file mode
-	Hash string // sha1 as hex
+	Hash string // object name as hex
 }
 
 // Modifications will list all the file modifications that are being tracked by
-// git.
+// git. This is the equivalent of 'git diff-index HEAD' but it is computed
+// natively from the index and the object store. See [Git.indexDiff].
 func (g *Git) Modifications() ([]*ModifiedFile, error) {
+	return g.indexDiff()
+}
+
+// modificationsCmd is the same as [Git.Modifications] but is computed by
+// running 'git diff-index HEAD'.
+func (g *Git) modificationsCmd() ([]*ModifiedFile, error) {
 	var buf bytes.Buffer
 	c := g.Cmd("diff-index", "HEAD")
 	c.Stdout = &buf
@@ -354,14 +378,45 @@ func objectFilename(gitDir, hash string) string {
 	)
 }
 
+// OpenObject will read an object from the object store. Loose objects are
+// checked first and then the pack files.
 func (g *Git) OpenObject(ref Ref) (obj *Object, err error) {
 	ref, err = ref.fullFollow(g)
 	if err != nil {
 		return nil, err
 	}
 	r := string(ref)
-	filename := g.objectFilename(r)
-	f, err := os.Open(filename)
+	obj, err = g.openLooseObject(r)
+	if err == nil || !os.IsNotExist(err) {
+		return obj, err
+	}
+	hash, err := hex.DecodeString(r)
+	if err != nil {
+		return nil, err
+	}
+	packs, err := g.packStore()
+	if err != nil {
+		return nil, err
+	}
+	obj, err = packs.read(hash)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, &os.PathError{Op: "open", Path: g.objectFilename(r), Err: os.ErrNotExist}
+	}
+	return obj, err
+}
+
+// Close releases any pack files held open by the object store.
+func (g *Git) Close() error {
+	if g.packs == nil {
+		return nil
+	}
+	err := g.packs.close()
+	g.packs = nil
+	return err
+}
+
+func (g *Git) openLooseObject(hash string) (obj *Object, err error) {
+	f, err := os.Open(g.objectFilename(hash))
 	if err != nil {
 		return nil, err
 	}
@@ -380,63 +435,16 @@ func (g *Git) OpenObject(ref Ref) (obj *Object, err error) {
 	if err != nil {
 		return nil, err
 	}
-	obj.Hash = r
+	obj.Hash = hash
 	return obj, nil
 }
 
-func (g *Git) WriteObject(o *Object) error {
-	var (
-		buf  bytes.Buffer
-		hash string
-	)
-	if len(o.Hash) > 0 {
-		hash = o.Hash
-		_, err := o.writeTo(&buf)
-		if err != nil {
-			return err
-		}
-	} else {
-		h := sha1.New()
-		_, err := o.writeTo(&hashWriter{w: &buf, hash: h})
-		if err != nil {
-			return err
-		}
-		hash = hex.EncodeToString(h.Sum(nil))
-	}
-	f, err := os.OpenFile(
-		g.objectFilename(hash),
-		os.O_CREATE|os.O_WRONLY,
-		0644,
-	)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = io.Copy(f, &buf)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (g *Git) HeadCommit() (*Commit, error) {
 	ref, err := g.HeadCommitHash()
 	if err != nil {
 		return nil, err
 	}
-	obj, err := g.OpenObject(ref)
-	if err != nil {
-		return nil, err
-	}
-	if obj.Type != ObjCommit {
-		return nil, errors.New("object at HEAD is not a commit object")
-	}
-	var cm Commit
-	err = parseCommit(bufio.NewReader(bytes.NewReader(obj.Data)), &cm)
-	if err != nil {
-		return nil, err
-	}
-	return &cm, nil
+	return g.OpenCommit(ref)
 }
 
 func (g *Git) OpenCommit(ref Ref) (*Commit, error) {
@@ -452,6 +460,9 @@ func (g *Git) OpenCommit(ref Ref) (*Commit, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cm.Hash, err = ParseHash(obj.Hash); err != nil {
+		return nil, err
+	}
 	return &cm, nil
 }
 
@@ -463,11 +474,19 @@ func (g *Git) CommitTree(commit *Commit) ([]TreeEntry, error) {
 	if obj.Type != ObjTree {
 		return nil, errors.New("commit tree is not a tree object")
 	}
-	return parseTree(obj.Data)
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	return parseTree(obj.Data, algo.Size())
 }
 
-func (g Git) CommitParent(commit *Commit) (*Commit, error) {
-	return g.OpenCommit(NewHashRef(commit.Parent))
+// CommitParent opens the first parent of a commit.
+func (g *Git) CommitParent(commit *Commit) (*Commit, error) {
+	if commit.IsRoot() {
+		return nil, errors.New("commit has no parents")
+	}
+	return g.OpenCommit(NewHashRef(commit.Parents[0]))
 }
 
 func (g *Git) FileCount() (int, error) {
@@ -488,32 +507,6 @@ func (g *Git) FileCount() (int, error) {
 	return int(hdr.entries), nil
 }
 
-func (g *Git) config(flags ...string) (Config, error) {
-	var (
-		buf  bytes.Buffer
-		m    = make(Config)
-		args = make([]string, 1+len(flags))
-	)
-	args[0] = "config"
-	for i := range len(flags) {
-		args[i+1] = flags[i]
-	}
-	cmd := g.Cmd(args...)
-	cmd.Stdout = &buf
-	err := run(cmd)
-	if err != nil {
-		return nil, err
-	}
-	for l := range strings.SplitSeq(buf.String(), "\n") {
-		parts := strings.SplitN(l, "=", 2)
-		if len(parts) < 2 {
-			continue
-		}
-		m[parts[0]] = parts[1]
-	}
-	return m, nil
-}
-
 // CurrentBranch returns the name of the current branch.
 func (g *Git) CurrentBranch() (string, error) {
 	// TODO git symbolic-ref --quient HEAD
@@ -636,20 +629,6 @@ func (g *Git) setDefaultIO(cmd *exec.Cmd) {
 	cmd.Stdin = g.stdin
 }
 
-func run(cmd *exec.Cmd) error {
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err != nil {
-		msg := strings.Trim(stderr.String(), "\n")
-		if len(msg) == 0 {
-			return err
-		}
-		return fmt.Errorf("%s: %w", msg, err)
-	}
-	return nil
-}
-
 type hashWriter struct {
 	w    io.Writer
 	hash hash.Hash
@@ -3,6 +3,7 @@ package git
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -149,11 +150,47 @@ func TestGit_Config(t *testing.T) {
 	is.NoErr(setupTestRepo(m.git, newfile("test.txt", "empty...")))
 	is.NoErr(m.git.Add("."))
 	is.NoErr(m.git.Commit("c"))
+	system := filepath.Join(m.tmp, "system")
+	global := filepath.Join(m.tmp, "global")
+	is.NoErr(os.WriteFile(system, []byte("[core]\n\teditor = nano\n[init]\n\tdefaultBranch = trunk\n"), 0644))
+	is.NoErr(os.WriteFile(global, []byte(`; user settings
+[user]
+	name = "Jane \"JD\" Doe" # quoted
+[url "git@github.com:"]
+	insteadOf = https://github.com/
+[core]
+	editor = vim
+	quotepath
+`), 0644))
+	m.git.SetSystemConfig(system).SetGlobalConfig(global)
+
 	conf, err := m.git.Config()
 	is.NoErr(err)
-	is.True(len(conf) > 0)
-	is.Equal(conf["core.filemode"], "true")
-	is.Equal(conf["core.bare"], "true")
+	is.True(conf.Len() > 0)
+	for key, exp := range map[string]string{
+		"core.filemode":                 "true",
+		"core.bare":                     "true",
+		"CORE.Editor":                   "vim",
+		"core.quotePath":                "true",
+		"init.defaultBranch":            "trunk",
+		"url.git@github.com:.insteadOf": "https://github.com/",
+		"url.git@GITHUB.com:.insteadOf": "",          // subsections are case sensitive
+		"user.name":                     "DotsTests", // -c has the highest precedence
+		"missing.key":                   "",
+	} {
+		v, _ := conf.Get(key)
+		is.Equal(v, exp) // value for key
+	}
+	is.Equal(conf.GetAll("user.name"), []string{"Jane \"JD\" Doe", "DotsTests"})
+	is.True(conf.Exists("init.defaultBranch"))
+	is.True(!conf.Exists("init.missing"))
+	origin := conf.Origin("core.editor")
+	is.Equal(origin.Scope, ScopeGlobal)
+	is.Equal(origin.ShowOrigin(), "file:"+global)
+	is.Equal(origin.Line, uint(7))
+	is.Equal(conf.Origin("user.name").ShowOrigin(), "command line:")
+	is.Equal(conf.Origin("core.bare").Scope, ScopeLocal)
+
 	for _, editor := range []string{
 		"nano",
 		"vim",
@@ -162,8 +199,55 @@ func TestGit_Config(t *testing.T) {
 		is.NoErr(m.git.ConfigLocalSet("core.editor", editor))
 		conf, err = m.git.ConfigLocal()
 		is.NoErr(err)
-		is.Equal(conf["core.editor"], editor)
+		v, _ := conf.Get("core.editor")
+		is.Equal(v, editor)
+	}
+	conf, err = m.git.ConfigGlobal()
+	is.NoErr(err)
+	v, _ := conf.Get("core.editor")
+	is.Equal(v, "vim")
+	is.True(!conf.Exists("core.bare"))
+
+	is.NoErr(m.git.ConfigLocalSet("filter.dots.clean", "dots crypt clean %f"))
+	is.NoErr(m.git.ConfigGlobalSet("init.defaultBranch", "main"))
+	is.NoErr(m.git.ConfigSet("core.editor", "emacs"))
+	for key, exp := range map[string]string{
+		"filter.dots.clean":  "dots crypt clean %f",
+		"init.defaultBranch": "main",
+		"core.editor":        "emacs",
+	} {
+		var out bytes.Buffer
+		cmd := m.git.Cmd("config", "--get", key)
+		cmd.Stdout = &out
+		is.NoErr(run(cmd))
+		is.Equal(out.String(), exp+"\n") // git should read the value
+	}
+	raw, err := os.ReadFile(global)
+	is.NoErr(err)
+	is.True(strings.HasPrefix(string(raw), "; user settings\n")) // should keep the file's comments
+	is.True(!exists(global + ".lock"))
+	lock, err := newLockFile(global, 0644)
+	is.NoErr(err)
+	is.True(m.git.ConfigGlobalSet("core.editor", "vi") != nil) // should not write while locked
+	is.NoErr(lock.Rollback())
+	is.Equal(must(os.ReadFile(global)), raw)
+
+	// Should list the same values in the same order as git.
+	var out bytes.Buffer
+	cmd := m.git.Cmd("config", "--list", "--show-origin")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	conf, err = m.git.Config()
+	is.NoErr(err)
+	var got strings.Builder
+	for _, e := range conf.Entries() {
+		if e.Key == "core.quotepath" {
+			fmt.Fprintf(&got, "%s\t%s\n", e.ShowOrigin(), e.Key) // git omits the value
+			continue
+		}
+		fmt.Fprintf(&got, "%s\t%s=%s\n", e.ShowOrigin(), e.Key, e.Value)
 	}
+	is.Equal(got.String(), out.String())
 }
 
 func TestGit_LsTree(t *testing.T) {
@@ -265,7 +349,7 @@ func TestGit_PrintFileModifications(t *testing.T) {
 	is.Equal(len(modfiles), 0) // should not have any files marked as modified
 	is.Equal(3, must(git.FileCount()))
 	f := must(os.Open(git.indexFile()))
-	index, err := readIndex(f)
+	index, err := readIndex(f, SHA1)
 	f.Close()
 	is.NoErr(err)
 	files, err := git.Files()
@@ -329,7 +413,7 @@ func TestReadIndex(t *testing.T) {
 	git.SetErr(os.Stderr)
 	filename := git.indexFile()
 	f := must(os.Open(filename))
-	index, err := readIndex(f)
+	index, err := readIndex(f, SHA1)
 	if err != nil {
 		f.Close()
 		t.Fatal(err)
@@ -415,8 +499,8 @@ func TestOpenObject(t *testing.T) {
 	var cm Commit
 	err = parseCommit(bufio.NewReader(bytes.NewReader(obj.Data)), &cm)
 	is.NoErr(err)
-	is.True(!isZero(cm.Tree[:]))
-	is.True(!isZero(cm.Parent[:]))
+	is.True(!cm.Tree.IsZero())
+	is.Equal(len(cm.Parents), 1)
 	is.True(len(cm.Author) > 0)
 	is.True(len(cm.Commiter) > 0)
 	is.True(len(cm.Message) > 0)
@@ -452,7 +536,7 @@ func TestParseTree(t *testing.T) {
 	for _, e := range entries {
 		is.True(int(e.Mode) != 0)
 		is.True(len(e.Name) > 0)
-		is.True(!isZero(e.Hash[:]))
+		is.True(!e.Hash.IsZero())
 		if e.Name == "git" {
 			is.Equal(e.Mode, TreeMode)
 		}
@@ -480,6 +564,46 @@ func TestParseLogs(t *testing.T) {
 	logs, err := parseLogs(f)
 	is.NoErr(err)
 	is.True(len(logs) > 0)
+	is.True(logs[0].IsCreation())
+	is.Equal(logs[0].Flag, LogFlagInitial)
+	is.Equal(logs[0].Author, "DotsTests <dots@example.com>")
+	last := logs[len(logs)-1]
+	is.Equal(last.Flag, LogFlagAmend)
+	is.Equal(last.Message, "commit (amend): empty emended commit")
+	is.Equal(last.Prev, logs[len(logs)-2].Hash)
+	head := must(git.HeadCommit())
+	is.Equal(last.Hash, head.Hash)
+	is.True(time.Since(last.TimeStamp) < time.Minute)
+	for _, l := range logs[1 : len(logs)-1] {
+		is.Equal(l.Flag, LogFlagNone)
+	}
+
+	_, err = parseLogs(strings.NewReader("not a reflog\tmessage\n"))
+	is.True(err != nil)
+}
+
+func TestGit_Reflog(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(git, newfile("one", "this is the first file\n")))
+	is.NoErr(git.RunCmd("reset", "-q", "--soft", "HEAD~1"))
+	logs, err := git.Reflog("")
+	is.NoErr(err)
+	is.Equal(len(logs), 3)
+	is.True(strings.HasPrefix(logs[0].Message, "reset: moving to HEAD~1"))
+	is.Equal(logs[0].Prev, logs[1].Hash)
+	is.Equal(logs[0].Hash, logs[2].Hash)
+	is.True(logs[2].IsCreation())
+
+	branch := must(git.CurrentBranch())
+	logs, err = git.Reflog(branch)
+	is.NoErr(err)
+	is.Equal(len(logs), 3)
+	logs, err = git.Reflog("refs/heads/" + branch)
+	is.NoErr(err)
+	is.Equal(len(logs), 3)
+	_, err = git.Reflog("does-not-exist")
+	is.True(os.IsNotExist(err))
 }
 
 func TestGatherCommits(t *testing.T) {
@@ -517,14 +641,14 @@ func TestGatherCommits(t *testing.T) {
 }
 
 func objectHashBytes(typ ObjectType, size uint64, r io.Reader) []byte {
-	raw := objectHash(typ, size, r)
+	raw := objectHash(SHA1, typ, size, r)
 	enc := make([]byte, hex.EncodedLen(len(raw)))
 	hex.Encode(enc, raw)
 	return enc
 }
 
 func gitHashObject(g *Git, data string) ([]byte, error) {
-	cmd := g.newCmd([]string{"hash-object", "--stdin", "-t", "blob"})
+	cmd := g.newCmd(context.Background(), []string{"hash-object", "--stdin", "-t", "blob"})
 	pipe, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -622,6 +746,9 @@ func addFileToTree(g *Git, f fs.File) error {
 			return err
 		}
 	} else {
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
 		file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode())
 		if err != nil {
 			return err
@@ -778,11 +905,3 @@ func must[T any](v T, e error) T {
 	}
 	return v
 }
-
-func isZero(b []byte) bool {
-	cum := uint64(0)
-	for _, c := range b {
-		cum += uint64(c)
-	}
-	return cum == 0
-}
@@ -0,0 +1,546 @@
+package gitconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth is the limit on nested includes. See 'MAX_INCLUDE_DEPTH' in
+// "config.c".
+const maxIncludeDepth = 10
+
+// ErrInvalidKey is returned for keys that are not in the form "section.key"
+// or "section.subsection.key".
+var ErrInvalidKey = errors.New("invalid config key")
+
+// Config is a parsed config file. Entries from included files are merged in
+// at the position of the include so that later entries take precedence.
+type Config struct {
+	// raw is the text of the file, not including any included files.
+	raw      []byte
+	filename string
+	opts     Options
+	entries  []*Entry
+	headers  []sectionHeader
+	sections map[string]*Section
+}
+
+// Entry is a single key and value in a config file.
+type Entry struct {
+	// Section and Name are always lower case while Subsection is case
+	// sensitive.
+	Section    string
+	Subsection string
+	Name       string
+	Value      string
+	// NoValue is true if the key has no '=', which means true for boolean
+	// keys.
+	NoValue bool
+	// Origin is the file that the entry was read from. It is empty for
+	// configs that were parsed from memory.
+	Origin string
+	Line   uint
+
+	// start and end are the offsets of the entry in the source file.
+	// Entries from included files have no offsets.
+	start, end int
+	included   bool
+}
+
+// Key returns the full name of the entry's key.
+func (e *Entry) Key() string {
+	if len(e.Subsection) > 0 {
+		return e.Section + "." + e.Subsection + "." + e.Name
+	}
+	return e.Section + "." + e.Name
+}
+
+// Options are used to evaluate the conditions of "includeIf" sections.
+type Options struct {
+	// GitDir is the git directory used by "gitdir:" conditions.
+	GitDir string
+	// Branch is the short name of the current branch used by "onbranch:"
+	// conditions.
+	Branch string
+}
+
+// ParseFile reads and parses a config file and the files that it includes
+// using "include.path" and "includeIf.<condition>.path". A missing file is
+// parsed as an empty config so that it can be written to.
+func ParseFile(filename string, opts *Options) (*Config, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	c := Config{filename: filename}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if err = c.load(raw); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// load parses the raw text of the config's file and resolves includes.
+func (c *Config) load(raw []byte) error {
+	p := configParser{raw, 1, false}
+	parsed, err := p.parse()
+	if err != nil {
+		if len(c.filename) == 0 {
+			return fmt.Errorf("line %d: %w", p.linenr, err)
+		}
+		return fmt.Errorf("%s:%d: %w", c.filename, p.linenr, err)
+	}
+	c.raw = raw
+	c.headers = parsed.headers
+	c.entries = parsed.entries
+	if len(c.filename) > 0 {
+		// Configs parsed from memory do not resolve includes.
+		c.entries, err = c.resolveIncludes(parsed.entries, c.filename, 0)
+		if err != nil {
+			return err
+		}
+	}
+	c.index()
+	return nil
+}
+
+func (c *Config) resolveIncludes(entries []*Entry, filename string, depth int) ([]*Entry, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("exceeded maximum include depth (%d) while including %s", maxIncludeDepth, filename)
+	}
+	res := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		e.Origin = filename
+		e.included = depth > 0
+		res = append(res, e)
+		path, ok, err := c.includePath(e, filename)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue // missing includes are ignored
+		} else if err != nil {
+			return nil, err
+		}
+		p := configParser{raw, 1, false}
+		included, err := p.parse()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, p.linenr, err)
+		}
+		nested, err := c.resolveIncludes(included.entries, path, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, nested...)
+	}
+	return res, nil
+}
+
+// includePath returns the file that an entry includes if it is an include
+// whose condition is true.
+func (c *Config) includePath(e *Entry, from string) (string, bool, error) {
+	if e.Name != "path" || e.NoValue {
+		return "", false, nil
+	}
+	switch {
+	case e.Section == "include" && e.Subsection == "":
+	case e.Section == "includeif":
+		ok, err := c.includeCondition(e.Subsection, from)
+		if err != nil || !ok {
+			return "", false, err
+		}
+	default:
+		return "", false, nil
+	}
+	path, err := ExpandPath(e.Value)
+	if err != nil {
+		return "", false, err
+	}
+	if !filepath.IsAbs(path) {
+		if len(from) == 0 {
+			return "", false, errors.New("relative config includes must come from files")
+		}
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	return path, true, nil
+}
+
+// includeCondition evaluates the condition of an "includeIf" section. Unknown
+// conditions are false. See 'include_condition_is_true' in "config.c".
+func (c *Config) includeCondition(cond, from string) (bool, error) {
+	if pattern, ok := strings.CutPrefix(cond, "gitdir:"); ok {
+		return c.matchGitDir(pattern, from, false)
+	} else if pattern, ok = strings.CutPrefix(cond, "gitdir/i:"); ok {
+		return c.matchGitDir(pattern, from, true)
+	} else if pattern, ok = strings.CutPrefix(cond, "onbranch:"); ok {
+		if len(c.opts.Branch) == 0 {
+			return false, nil
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		re, err := globRegexp(pattern, false)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(c.opts.Branch), nil
+	}
+	return false, nil
+}
+
+func (c *Config) matchGitDir(pattern, from string, fold bool) (bool, error) {
+	if len(c.opts.GitDir) == 0 {
+		return false, nil
+	}
+	pattern, err := ExpandPath(pattern)
+	if err != nil {
+		return false, err
+	}
+	if rest, ok := strings.CutPrefix(pattern, "./"); ok {
+		if len(from) == 0 {
+			return false, errors.New("relative config include conditionals must come from files")
+		}
+		pattern = filepath.Join(filepath.Dir(from), rest)
+	}
+	if !filepath.IsAbs(pattern) && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	re, err := globRegexp(filepath.ToSlash(pattern), fold)
+	if err != nil {
+		return false, err
+	}
+	gitdir, err := filepath.Abs(c.opts.GitDir)
+	if err != nil {
+		return false, err
+	}
+	if re.MatchString(filepath.ToSlash(gitdir)) {
+		return true, nil
+	}
+	real, err := filepath.EvalSymlinks(gitdir)
+	if err != nil {
+		return false, nil
+	}
+	return re.MatchString(filepath.ToSlash(real)), nil
+}
+
+// globRegexp converts a wildmatch pattern where "**" matches across
+// directories into a regular expression.
+func globRegexp(pattern string, fold bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?i)")
+	}
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
+
+// index builds the section lookup table. The last value of a key wins.
+func (c *Config) index() {
+	c.sections = make(map[string]*Section)
+	for _, e := range c.entries {
+		s, ok := c.sections[e.Section]
+		if !ok {
+			s = newSection(e.Section)
+			c.sections[e.Section] = s
+		}
+		if len(e.Subsection) > 0 {
+			sub, ok := s.subsections[e.Subsection]
+			if !ok {
+				sub = newSection(e.Subsection)
+				s.subsections[e.Subsection] = sub
+			}
+			s = sub
+		}
+		s.entries[e.Name] = e.Value
+		s.values[e.Name] = append(s.values[e.Name], e.Value)
+	}
+}
+
+// Filename returns the name of the file the config was read from.
+func (c *Config) Filename() string { return c.filename }
+
+// Entries returns all the entries in the order they were read, including the
+// entries of included files.
+func (c *Config) Entries() []Entry {
+	res := make([]Entry, len(c.entries))
+	for i, e := range c.entries {
+		res[i] = *e
+	}
+	return res
+}
+
+func (c *Config) GetSection(name string) *Section {
+	return c.sections[name]
+}
+
+// Get returns the value of a key in the form "section.key" or
+// "section.subsection.key". Section and key names are case insensitive. If a
+// key has multiple values then the last one is returned.
+func (c *Config) Get(key string) (string, bool) {
+	e := c.lookup(key)
+	if e == nil {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// GetAll returns every value of a multi-valued key such as
+// "remote.origin.fetch".
+func (c *Config) GetAll(key string) []string {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, e := range c.entries {
+		if e.Section == section && e.Subsection == sub && e.Name == name {
+			values = append(values, e.Value)
+		}
+	}
+	return values
+}
+
+// GetBool returns the value of a boolean key or def if the key is not set.
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	e := c.lookup(key)
+	if e == nil {
+		return def, nil
+	}
+	if e.NoValue {
+		return true, nil
+	}
+	v, err := ParseBool(e.Value)
+	if err != nil {
+		return def, fmt.Errorf("bad boolean config value %q for %q", e.Value, key)
+	}
+	return v, nil
+}
+
+// GetInt returns the value of an integer key or def if the key is not set.
+// Values may use the suffixes 'k', 'm' and 'g'.
+func (c *Config) GetInt(key string, def int64) (int64, error) {
+	e := c.lookup(key)
+	if e == nil {
+		return def, nil
+	}
+	v, err := ParseInt(e.Value)
+	if err != nil {
+		return def, fmt.Errorf("bad numeric config value %q for %q: %w", e.Value, key, err)
+	}
+	return v, nil
+}
+
+// GetPath returns the value of a path key with a leading '~' expanded to the
+// home directory or def if the key is not set.
+func (c *Config) GetPath(key string, def string) (string, error) {
+	e := c.lookup(key)
+	if e == nil {
+		return def, nil
+	}
+	return ExpandPath(e.Value)
+}
+
+func (c *Config) lookup(key string) *Entry {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		e := c.entries[i]
+		if e.Section == section && e.Subsection == sub && e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// CanonicalKey returns a key with the section and name in lower case. The
+// subsection is case sensitive and is not changed.
+func CanonicalKey(key string) (string, error) {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return "", err
+	}
+	e := Entry{Section: section, Subsection: sub, Name: name}
+	return e.Key(), nil
+}
+
+// splitKey splits a key into its lower case section, case sensitive
+// subsection and lower case name.
+func splitKey(key string) (section, subsection, name string, err error) {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", ErrInvalidKey
+	}
+	section = strings.ToLower(key[:first])
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	name = strings.ToLower(key[last+1:])
+	for i := range len(name) {
+		if !iskeychar(name[i]) {
+			return "", "", "", ErrInvalidKey
+		}
+	}
+	if !isalpha(name[0]) {
+		return "", "", "", ErrInvalidKey
+	}
+	for i := range len(section) {
+		if !iskeychar(section[i]) {
+			return "", "", "", ErrInvalidKey
+		}
+	}
+	return section, subsection, name, nil
+}
+
+// ParseBool parses a boolean the same way as git. See 'git_parse_maybe_bool'
+// in "parse.c".
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := ParseInt(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", s)
+	}
+	return n != 0, nil
+}
+
+// ParseInt parses an integer with an optional unit suffix of 'k', 'm' or 'g'
+// which multiply the value by 1024, 1024² or 1024³. See
+// 'git_parse_signed' in "parse.c".
+func ParseInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return 0, errors.New("empty value")
+	}
+	var factor int64 = 1
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		factor = 1 << 10
+	case 'm', 'M':
+		factor = 1 << 20
+	case 'g', 'G':
+		factor = 1 << 30
+	}
+	if factor != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 && n > (1<<63-1)/factor || n < 0 && n < (-1<<63)/factor {
+		return 0, strconv.ErrRange
+	}
+	return n * factor, nil
+}
+
+// ExpandPath expands a leading "~/" or "~user/" in a path. See
+// 'interpolate_path' in "path.c".
+func ExpandPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest, _ := strings.Cut(path[1:], "/")
+	var home string
+	if len(name) == 0 {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return "", err
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		home = u.HomeDir
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// Section holds the values of a section or subsection.
+type Section struct {
+	name string
+	// entries holds the last value of each key and values holds all of
+	// them.
+	entries     map[string]string
+	values      map[string][]string
+	subsections map[string]*Section
+}
+
+func newSection(name string) *Section {
+	return &Section{
+		name:        name,
+		entries:     make(map[string]string),
+		values:      make(map[string][]string),
+		subsections: make(map[string]*Section),
+	}
+}
+
+// Name returns the name of the section.
+func (s *Section) Name() string { return s.name }
+
+// Get returns the last value of a key in the section.
+func (s *Section) Get(name string) (string, bool) {
+	v, ok := s.entries[strings.ToLower(name)]
+	return v, ok
+}
+
+// GetAll returns all the values of a key in the section.
+func (s *Section) GetAll(name string) []string {
+	return s.values[strings.ToLower(name)]
+}
+
+// Subsection returns a subsection or nil if it does not exist.
+func (s *Section) Subsection(name string) *Section {
+	return s.subsections[name]
+}
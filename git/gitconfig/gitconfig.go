@@ -1,7 +1,9 @@
 package gitconfig
 
 import (
+	"bytes"
 	"errors"
+	"strings"
 )
 
 const utf8BOM = "\357\273\277"
@@ -28,6 +30,8 @@ var (
 	ErrMissingStartQuote = errors.New("missing start quote")
 	// ErrMissingClosingBracket indicates that there was a missing closing bracket in section
 	ErrMissingClosingBracket = errors.New("missing closing section bracket")
+	// ErrMissingSection indicates that a key was found before any section
+	ErrMissingSection = errors.New("key does not contain a section")
 )
 
 type configParser struct {
@@ -36,39 +40,34 @@ type configParser struct {
 	eof    bool
 }
 
-// Parse takes given bytes as configuration file (according to gitconfig syntax)
+// Parse takes given bytes as configuration file (according to gitconfig
+// syntax). Includes are not resolved, see [ParseFile].
 func Parse(bytes []byte) (*Config, uint, error) {
 	parser := &configParser{bytes, 1, false}
 	cfg, err := parser.parse()
 	return cfg, parser.linenr, err
 }
 
-type Config struct {
-	sections map[string]*Section
-}
-
-func (c *Config) MarshalText() ([]byte, error) {
-	return nil, nil
-}
-
-func (c *Config) GetSection(name string) *Section {
-	return c.sections[name]
-}
-
-type Section struct {
-	name        string
-	entries     map[string]string
-	subsections map[string]*Section
+// sectionHeader is the location of a section header in the source text.
+type sectionHeader struct {
+	section, subsection string
+	// end is the offset of the line after the header.
+	end int
 }
 
+// parse reads all the entries of a single file. The offsets of each entry and
+// section header are recorded so that the file can be edited later.
 func (cf *configParser) parse() (*Config, error) {
-	bomPtr := 0
-	comment := false
-	name := ""
 	var (
-		section *Section
-		cnf     = &Config{sections: make(map[string]*Section)}
+		raw       = cf.bytes
+		bomPtr    = 0
+		comment   = false
+		lineStart = 0
+		lineEmpty = true
+		header    *sectionHeader
+		cnf       = &Config{raw: raw}
 	)
+	offset := func() int { return len(raw) - len(cf.bytes) }
 	for {
 		c := cf.nextChar()
 		if bomPtr != -1 && bomPtr < len(utf8BOM) {
@@ -81,13 +80,16 @@ func (cf *configParser) parse() (*Config, error) {
 					return cnf, ErrPartialBOM
 				}
 				bomPtr = -1
+				lineStart = offset() - 1
 			}
 		}
 		if c == '\n' {
 			if cf.eof {
+				cnf.index()
 				return cnf, nil
 			}
 			comment = false
+			lineStart, lineEmpty = offset(), true
 			continue
 		}
 		if comment || isspace(c) {
@@ -102,31 +104,49 @@ func (cf *configParser) parse() (*Config, error) {
 			if err != nil {
 				return cnf, err
 			}
-			var ok bool
-			if section, ok = cnf.sections[sect]; !ok {
-				section = &Section{
-					name:        sect,
-					entries:     make(map[string]string),
-					subsections: make(map[string]*Section),
-				}
+			if len(ext) == 0 {
+				// The deprecated [section.subsection] syntax.
+				sect, ext, _ = strings.Cut(sect, ".")
 			}
-			cnf.sections[sect] = section
-			if len(ext) > 0 {
-				sub := Section{name: ext, entries: make(map[string]string)}
-				section.subsections[ext] = &sub
-				section = &sub
+			end := offset()
+			if i := bytes.IndexByte(raw[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(raw)
 			}
+			header = &sectionHeader{section: sect, subsection: ext, end: end}
+			cnf.headers = append(cnf.headers, *header)
+			lineEmpty = false
 			continue
 		}
 		if !isalpha(c) {
 			return cnf, ErrInvalidKeyChar
 		}
-		key := name + string(c)
-		value, err := cf.getValue(&key)
+		if header == nil {
+			return cnf, ErrMissingSection
+		}
+		start := offset() - 1
+		if lineEmpty {
+			start = lineStart
+		}
+		line := cf.linenr
+		key := string(lower(c))
+		value, hasValue, err := cf.getValue(&key)
 		if err != nil {
 			return cnf, err
 		}
-		section.entries[key] = value
+		cnf.entries = append(cnf.entries, &Entry{
+			Section:    header.section,
+			Subsection: header.subsection,
+			Name:       key,
+			Value:      value,
+			NoValue:    !hasValue,
+			Line:       line,
+			start:      start,
+			end:        offset(),
+		})
+		// getValue consumes the end of the line.
+		lineStart, lineEmpty = offset(), true
 	}
 }
 
@@ -183,8 +203,8 @@ func (cf *configParser) dumbParse() (map[string]string, error) {
 		if !isalpha(c) {
 			return cfg, ErrInvalidKeyChar
 		}
-		key := name + string(c)
-		value, err := cf.getValue(&key)
+		key := name + string(lower(c))
+		value, _, err := cf.getValue(&key)
 		if err != nil {
 			return cfg, err
 		}
@@ -300,7 +320,7 @@ func (cf *configParser) getExtendedSectionKey(c byte) (ext string, err error) {
 	return ext, nil
 }
 
-func (cf *configParser) getValue(name *string) (string, error) {
+func (cf *configParser) getValue(name *string) (string, bool, error) {
 	var c byte
 	var err error
 	var value string
@@ -321,13 +341,14 @@ func (cf *configParser) getValue(name *string) (string, error) {
 		c = cf.nextChar()
 	}
 
-	if c != '\n' {
+	hasValue := c != '\n'
+	if hasValue {
 		if c != '=' {
-			return "", ErrInvalidKeyChar
+			return "", false, ErrInvalidKeyChar
 		}
 		value, err = cf.parseValue()
 		if err != nil {
-			return "", err
+			return "", false, err
 		}
 	}
 	/*
@@ -340,7 +361,7 @@ func (cf *configParser) getValue(name *string) (string, error) {
 	// if ret >= 0 {
 	// 	cf.linenr++
 	// }
-	return value, err
+	return value, hasValue, err
 }
 
 func (cf *configParser) parseValue() (string, error) {
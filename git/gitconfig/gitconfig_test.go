@@ -1,6 +1,9 @@
 package gitconfig
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/matryer/is"
@@ -32,6 +35,16 @@ func TestConfig(t *testing.T) {
 		c.sections["remote"].subsections["origin"].entries["fetch"],
 		"+refs/heads/*:refs/remotes/origin/*",
 	)
+	v, ok := c.Get("core.fileMode")
+	is.True(ok)
+	is.Equal(v, "true")
+	v, ok = c.Get("remote.origin.url")
+	is.True(ok)
+	is.Equal(v, "git@github.com:harrybrwn/dots.git")
+	_, ok = c.Get("remote.upstream.url")
+	is.True(!ok)
+	_, ok = c.Get("core")
+	is.True(!ok)
 }
 
 func TestDumbParse(t *testing.T) {
@@ -67,3 +80,213 @@ func TestParseSection(t *testing.T) {
 	is.Equal(name, "remote")
 	is.Equal(sub, "origin")
 }
+
+const multiConfig = `# global settings
+[core]
+	editor = nvim ; trailing comment
+	bare
+[remote "origin"]
+	url = git@github.com:harrybrwn/dots.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[Pack]
+	windowMemory = 1g
+	threads = -2k
+[http "https://Example.com"]
+	sslVerify = no
+[alias.dots]
+	st = status
+`
+
+func TestConfig_Values(t *testing.T) {
+	is := is.New(t)
+	c, _, err := Parse([]byte(multiConfig))
+	is.NoErr(err)
+	is.Equal(c.GetAll("remote.origin.fetch"), []string{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/tags/*:refs/tags/*",
+	})
+	v, ok := c.Get("remote.origin.fetch")
+	is.True(ok)
+	is.Equal(v, "+refs/tags/*:refs/tags/*") // last value wins
+	v, _ = c.Get("core.editor")
+	is.Equal(v, "nvim")
+	is.Equal(c.GetSection("remote").Subsection("origin").GetAll("FETCH"), c.GetAll("remote.origin.fetch"))
+	v, _ = c.Get("alias.dots.st")
+	is.Equal(v, "status")
+
+	b, err := c.GetBool("core.bare", false)
+	is.NoErr(err)
+	is.True(b) // no value means true
+	b, err = c.GetBool("http.https://Example.com.sslverify", true)
+	is.NoErr(err)
+	is.True(!b)
+	_, ok = c.Get("http.https://example.com.sslverify")
+	is.True(!ok) // subsections are case sensitive
+	b, err = c.GetBool("core.missing", true)
+	is.NoErr(err)
+	is.True(b)
+	_, err = c.GetBool("core.editor", false)
+	is.True(err != nil)
+
+	n, err := c.GetInt("pack.windowmemory", 0)
+	is.NoErr(err)
+	is.Equal(n, int64(1<<30))
+	n, err = c.GetInt("pack.threads", 0)
+	is.NoErr(err)
+	is.Equal(n, int64(-2048))
+	n, err = c.GetInt("pack.missing", 7)
+	is.NoErr(err)
+	is.Equal(n, int64(7))
+	_, err = c.GetInt("core.editor", 0)
+	is.True(err != nil)
+
+	entries := c.Entries()
+	is.Equal(entries[0].Key(), "core.editor")
+	is.Equal(entries[0].Line, uint(3))
+	is.Equal(entries[len(entries)-1].Key(), "alias.dots.st")
+
+	_, _, err = Parse([]byte("key = value\n"))
+	is.Equal(err, ErrMissingSection)
+}
+
+func TestParseInt(t *testing.T) {
+	is := is.New(t)
+	for s, exp := range map[string]int64{"10": 10, "1k": 1024, "2M": 2 << 20, "0x10": 16, "-1g": -1 << 30} {
+		n, err := ParseInt(s)
+		is.NoErr(err)
+		is.Equal(n, exp)
+	}
+	for _, s := range []string{"", "k", "1x", "9999999999g"} {
+		_, err := ParseInt(s)
+		is.True(err != nil)
+	}
+	for s, exp := range map[string]bool{"yes": true, "On": true, "1": true, "false": false, "": false, "0": false} {
+		v, err := ParseBool(s)
+		is.NoErr(err)
+		is.Equal(v, exp)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	is := is.New(t)
+	home, err := os.UserHomeDir()
+	is.NoErr(err)
+	p, err := ExpandPath("~/.config/git")
+	is.NoErr(err)
+	is.Equal(p, filepath.Join(home, ".config/git"))
+	p, err = ExpandPath("/etc/gitconfig")
+	is.NoErr(err)
+	is.Equal(p, "/etc/gitconfig")
+}
+
+func TestParseFile_Includes(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		is.NoErr(os.MkdirAll(filepath.Dir(p), 0755))
+		is.NoErr(os.WriteFile(p, []byte(content), 0644))
+		return p
+	}
+	write("inc/user.conf", "[user]\n\tname = Included\n[include]\n\tpath = nested.conf\n")
+	write("inc/nested.conf", "[user]\n\temail = nested@example.com\n")
+	write("work.conf", "[user]\n\temail = work@example.com\n")
+	write("main.conf", "[user]\n\tname = Main\n")
+	filename := write("config", `[user]
+	name = Before
+[include]
+	path = inc/user.conf
+	path = missing.conf
+[includeIf "gitdir:`+dir+`/work/"]
+	path = work.conf
+[includeIf "onbranch:main"]
+	path = main.conf
+[core]
+	editor = vim
+`)
+	c, err := ParseFile(filename, nil)
+	is.NoErr(err)
+	v, _ := c.Get("user.name")
+	is.Equal(v, "Included") // included after the first value
+	v, _ = c.Get("user.email")
+	is.Equal(v, "nested@example.com")
+	is.Equal(c.GetAll("user.name"), []string{"Before", "Included"})
+	var origin string
+	for _, e := range c.Entries() {
+		if e.Key() == "user.email" {
+			origin = e.Origin
+		}
+	}
+	is.Equal(origin, filepath.Join(dir, "inc/nested.conf"))
+
+	c, err = ParseFile(filename, &Options{GitDir: filepath.Join(dir, "work/project/.git"), Branch: "main"})
+	is.NoErr(err)
+	v, _ = c.Get("user.email")
+	is.Equal(v, "work@example.com")
+	v, _ = c.Get("user.name")
+	is.Equal(v, "Main")
+
+	write("loop.conf", "[include]\n\tpath = loop.conf\n")
+	_, err = ParseFile(filepath.Join(dir, "loop.conf"), nil)
+	is.True(err != nil)
+}
+
+func TestConfig_Write(t *testing.T) {
+	is := is.New(t)
+	filename := filepath.Join(t.TempDir(), "config")
+	is.NoErr(os.WriteFile(filename, []byte(multiConfig), 0600))
+	c, err := ParseFile(filename, nil)
+	is.NoErr(err)
+	raw, err := c.MarshalText()
+	is.NoErr(err)
+	is.Equal(string(raw), multiConfig) // should round trip
+
+	is.NoErr(c.Set("core.editor", "code --wait"))
+	is.NoErr(c.Set("core.autocrlf", "input"))
+	is.NoErr(c.Add("remote.origin.fetch", "+refs/notes/*:refs/notes/*"))
+	is.True(errors.Is(c.Set("remote.origin.fetch", "x"), ErrMultipleValues))
+	is.True(errors.Is(c.Unset("remote.origin.fetch"), ErrMultipleValues))
+	is.NoErr(c.Unset("pack.threads"))
+	is.NoErr(c.Set(`remote.up "stream".url`, " has spaces; and \"quotes\"\t"))
+	is.NoErr(c.Set("user.name", "Dots"))
+	is.True(c.Set("invalid", "x") != nil)
+	is.NoErr(c.Save())
+
+	raw, err = os.ReadFile(filename)
+	is.NoErr(err)
+	is.Equal(string(raw), `# global settings
+[core]
+	editor = code --wait
+	bare
+	autocrlf = input
+[remote "origin"]
+	url = git@github.com:harrybrwn/dots.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+	fetch = +refs/notes/*:refs/notes/*
+[Pack]
+	windowMemory = 1g
+[http "https://Example.com"]
+	sslVerify = no
+[alias.dots]
+	st = status
+[remote "up \"stream\""]
+	url = " has spaces; and \"quotes\"\t"
+[user]
+	name = Dots
+`)
+	info, err := os.Stat(filename)
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0600))
+
+	c, err = ParseFile(filename, nil)
+	is.NoErr(err)
+	v, _ := c.Get(`remote.up "stream".url`)
+	is.Equal(v, " has spaces; and \"quotes\"\t")
+	is.NoErr(c.UnsetAll("remote.origin.fetch"))
+	is.Equal(len(c.GetAll("remote.origin.fetch")), 0)
+	v, _ = c.Get("remote.origin.url")
+	is.Equal(v, "git@github.com:harrybrwn/dots.git")
+}
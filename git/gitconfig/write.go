@@ -0,0 +1,206 @@
+package gitconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrMultipleValues is returned when changing a single value of a key that
+// has more than one value.
+var ErrMultipleValues = errors.New("key has multiple values")
+
+// MarshalText returns the text of the config file including any changes. The
+// contents of included files are not part of the output.
+func (c *Config) MarshalText() ([]byte, error) {
+	return c.raw, nil
+}
+
+// Save writes the config back to the file it was read from.
+func (c *Config) Save() error {
+	if len(c.filename) == 0 {
+		return errors.New("config was not read from a file")
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(c.filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	// Take the same lock as git so that concurrent writes fail.
+	lock := c.filename + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(c.raw); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	if err = os.Rename(lock, c.filename); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return nil
+}
+
+// Set sets a key to a single value. An existing value is replaced in place and
+// new keys are added to the end of their section, creating the section if
+// needed. Values that come from included files are not changed.
+func (c *Config) Set(key, value string) error {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	own := c.ownEntries(section, sub, name)
+	switch len(own) {
+	case 0:
+		return c.add(section, sub, key[strings.LastIndexByte(key, '.')+1:], value)
+	case 1:
+		e := own[0]
+		indent := ""
+		if line := c.raw[e.start:e.end]; len(line) > 0 && isspace(line[0]) {
+			indent = string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+		} else if e.start > 0 && c.raw[e.start-1] != '\n' {
+			indent = " " // after a section header on the same line
+		}
+		return c.edit(e.start, e.end, indent+formatEntry(key[strings.LastIndexByte(key, '.')+1:], value))
+	default:
+		return fmt.Errorf("cannot set %q: %w", key, ErrMultipleValues)
+	}
+}
+
+// Add adds another value to a key without changing its other values.
+func (c *Config) Add(key, value string) error {
+	section, sub, _, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	return c.add(section, sub, key[strings.LastIndexByte(key, '.')+1:], value)
+}
+
+// Unset removes a key. It fails if the key has multiple values, use
+// [Config.UnsetAll] to remove all of them. Removing a key that does not exist
+// is not an error.
+func (c *Config) Unset(key string) error {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	own := c.ownEntries(section, sub, name)
+	if len(own) > 1 {
+		return fmt.Errorf("cannot unset %q: %w", key, ErrMultipleValues)
+	}
+	return c.remove(own)
+}
+
+// UnsetAll removes every value of a key.
+func (c *Config) UnsetAll(key string) error {
+	section, sub, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	return c.remove(c.ownEntries(section, sub, name))
+}
+
+// ownEntries returns the entries of a key that are in this file.
+func (c *Config) ownEntries(section, sub, name string) []*Entry {
+	var res []*Entry
+	for _, e := range c.entries {
+		if !e.included && e.Section == section && e.Subsection == sub && e.Name == name {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (c *Config) add(section, sub, name, value string) error {
+	line := "\t" + formatEntry(name, value)
+	// Append after the last entry of the last matching section.
+	at := -1
+	for _, h := range c.headers {
+		if h.section == section && h.subsection == sub {
+			at = h.end
+		}
+	}
+	for _, e := range c.entries {
+		if !e.included && e.Section == section && e.Subsection == sub && e.end > at {
+			at = e.end
+		}
+	}
+	if at < 0 {
+		at = len(c.raw)
+		line = formatHeader(section, sub) + line
+	}
+	if at > 0 && c.raw[at-1] != '\n' {
+		line = "\n" + line
+	}
+	return c.edit(at, at, line)
+}
+
+func (c *Config) remove(entries []*Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	raw := c.raw
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		raw = append(raw[:e.start:e.start], raw[e.end:]...)
+	}
+	return c.load(raw)
+}
+
+// edit replaces the text between start and end and parses the result again.
+func (c *Config) edit(start, end int, text string) error {
+	raw := make([]byte, 0, len(c.raw)+len(text))
+	raw = append(raw, c.raw[:start]...)
+	raw = append(raw, text...)
+	raw = append(raw, c.raw[end:]...)
+	return c.load(raw)
+}
+
+func formatHeader(section, sub string) string {
+	if len(sub) == 0 {
+		return "[" + section + "]\n"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return fmt.Sprintf("[%s \"%s\"]\n", section, r.Replace(sub))
+}
+
+// formatEntry formats a key and value as a line, quoting the value when
+// needed. See 'write_pair' in "config.c".
+func formatEntry(name, value string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString(" = ")
+	quote := len(value) > 0 && (isspace(value[0]) || isspace(value[len(value)-1])) ||
+		strings.ContainsAny(value, ";#")
+	if quote {
+		b.WriteByte('"')
+	}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quote {
+		b.WriteByte('"')
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
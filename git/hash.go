@@ -0,0 +1,126 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harrybrwn/dots/git/gitconfig"
+)
+
+// HashAlgo is the hash algorithm used to name objects in a repository. See
+// "Documentation/technical/hash-function-transition.txt" in the git source.
+type HashAlgo uint8
+
+const (
+	// SHA1 is the default object format.
+	SHA1 HashAlgo = iota
+	// SHA256 is used by repositories created with '--object-format=sha256'.
+	SHA256
+)
+
+// Size returns the length of a hash in bytes.
+func (a HashAlgo) Size() int {
+	if a == SHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+// HexSize returns the length of a hash in hex.
+func (a HashAlgo) HexSize() int { return 2 * a.Size() }
+
+// New returns a new hash.Hash for the algorithm.
+func (a HashAlgo) New() hash.Hash {
+	if a == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+func (a HashAlgo) String() string {
+	if a == SHA256 {
+		return "sha256"
+	}
+	return "sha1"
+}
+
+// ZeroHash returns the all zero hash used to mean "no object".
+func (a HashAlgo) ZeroHash() Hash { return make(Hash, a.Size()) }
+
+// ParseHashAlgo parses the value of 'extensions.objectFormat'.
+func ParseHashAlgo(s string) (HashAlgo, error) {
+	switch strings.ToLower(s) {
+	case "", "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	default:
+		return SHA1, fmt.Errorf("unknown object format %q", s)
+	}
+}
+
+// Hash is the binary name of an object. The length depends on the hash
+// algorithm of the repository.
+type Hash []byte
+
+// ParseHash decodes a hex encoded SHA-1 or SHA-256 object name.
+func ParseHash(s string) (Hash, error) {
+	if len(s) != SHA1.HexSize() && len(s) != SHA256.HexSize() {
+		return nil, fmt.Errorf("invalid object name %q", s)
+	}
+	h, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object name %q", s)
+	}
+	return h, nil
+}
+
+func (h Hash) String() string { return hex.EncodeToString(h) }
+
+// Equal returns true if both hashes are the same.
+func (h Hash) Equal(other Hash) bool { return bytes.Equal(h, other) }
+
+// IsZero returns true for an empty or all zero hash.
+func (h Hash) IsZero() bool {
+	for _, b := range h {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// HashAlgo returns the hash algorithm of the repository as set by
+// 'extensions.objectFormat' in the repository config. Repositories without a
+// config use SHA-1. It fails if the config cannot be read or the format is
+// unknown so that objects are never written with the wrong algorithm.
+func (g *Git) HashAlgo() (HashAlgo, error) {
+	if g.algo != nil {
+		return *g.algo, nil
+	}
+	filename := filepath.Join(g.gitDir, "config")
+	raw, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return SHA1, nil
+	} else if err != nil {
+		return SHA1, err
+	}
+	conf, _, err := gitconfig.Parse(raw)
+	if err != nil {
+		return SHA1, fmt.Errorf("%s: %w", filename, err)
+	}
+	format, _ := conf.Get("extensions.objectformat")
+	algo, err := ParseHashAlgo(format)
+	if err != nil {
+		return SHA1, fmt.Errorf("%s: %w", filename, err)
+	}
+	g.algo = &algo
+	return algo, nil
+}
@@ -0,0 +1,120 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseHash(t *testing.T) {
+	is := is.New(t)
+	h, err := ParseHash(strings.Repeat("ab", SHA1.Size()))
+	is.NoErr(err)
+	is.Equal(len(h), SHA1.Size())
+	h, err = ParseHash(strings.Repeat("ab", SHA256.Size()))
+	is.NoErr(err)
+	is.Equal(len(h), SHA256.Size())
+	is.Equal(h.String(), strings.Repeat("ab", SHA256.Size()))
+	_, err = ParseHash("abcd")
+	is.True(err != nil)
+	_, err = ParseHash(strings.Repeat("zz", SHA1.Size()))
+	is.True(err != nil)
+	is.True(SHA256.ZeroHash().IsZero())
+	is.True(!h.IsZero())
+
+	algo, err := ParseHashAlgo("SHA256")
+	is.NoErr(err)
+	is.Equal(algo, SHA256)
+	_, err = ParseHashAlgo("md5")
+	is.True(err != nil)
+}
+
+func TestGit_HashAlgoErrors(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(git.InitBare())
+	algo, err := git.HashAlgo()
+	is.NoErr(err)
+	is.Equal(algo, SHA1)
+
+	for _, config := range []string{
+		"[extensions]\n\tobjectFormat = md5\n",
+		"[extensions\n",
+	} {
+		git = New(git.GitDir(), git.WorkingTree())
+		is.NoErr(os.WriteFile(filepath.Join(git.GitDir(), "config"), []byte(config), 0644))
+		_, err = git.HashAlgo()
+		is.True(err != nil)
+		err = git.WriteObject(&Object{Type: ObjBlob, Data: []byte("data")})
+		is.True(err != nil) // nothing is written with the wrong algorithm
+	}
+}
+
+func TestGit_SHA256(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(os.MkdirAll(git.WorkingTree(), 0755))
+	is.NoErr(git.RunCmd("init", "-q", "--object-format=sha256"))
+	is.Equal(must(git.HashAlgo()), SHA256)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("dir/two", "this is the second file\n"),
+	))
+
+	files := must(git.Files())
+	for _, f := range files {
+		is.Equal(len(f.Hash), SHA256.HexSize())
+		obj, err := git.OpenObject(Ref(f.Hash))
+		is.NoErr(err)
+		if obj.Type != ObjBlob {
+			continue
+		}
+		fo, err := git.HashFile(must(os.Open(filepath.Join(git.WorkingTree(), f.Name))))
+		is.NoErr(err)
+		is.Equal(fo.Hash, f.Hash)
+	}
+	head := must(git.HeadCommit())
+	is.Equal(len(head.Hash), SHA256.Size())
+	is.Equal(len(head.Tree), SHA256.Size())
+	entries := must(git.CommitTree(head))
+	is.Equal(len(entries), 2)
+	n := 0
+	for c, err := range git.Log("HEAD", "dir") {
+		is.NoErr(err)
+		raw := must(c.MarshalBinary())
+		is.Equal(Hash(objectHash(SHA256, ObjCommit, uint64(len(raw)), bytes.NewReader(raw))), c.Hash)
+		n++
+	}
+	is.Equal(n, 1)
+	logs := must(git.Reflog("HEAD"))
+	is.Equal(logs[0].Hash, head.Hash)
+
+	ix := readTestIndex(t, git)
+	is.Equal(len(ix.checksum), SHA256.Size())
+	is.Equal(len(ix.entries[0].oid), SHA256.Size())
+	raw, err := os.ReadFile(git.indexFile())
+	is.NoErr(err)
+	out, err := ix.MarshalBinary()
+	is.NoErr(err)
+	is.True(bytes.Equal(raw, out))
+
+	is.NoErr(appendfile(filepath.Join(git.WorkingTree(), "one"), "more\n"))
+	mods := must(git.Modifications())
+	is.Equal(len(mods), 1)
+	is.Equal(mods[0].Dst.Hash, SHA256.ZeroHash().String())
+	is.NoErr(git.RefreshIndex())
+
+	is.NoErr(run(git.Cmd("repack", "-a", "-d", "-q")))
+	is.NoErr(run(git.Cmd("prune-packed")))
+	git = New(git.GitDir(), git.WorkingTree())
+	defer git.Close()
+	c, err := git.OpenCommit(NewHashRef(head.Hash))
+	is.NoErr(err)
+	is.Equal(c.Tree, head.Tree)
+	is.Equal(len(must(git.CommitTree(c))), 2)
+}
@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
@@ -31,7 +31,8 @@ const (
 )
 
 const (
-	// HashSize is the size hash for the current hash algorithm being used.
+	// HashSize is the size of a hash for the default hash algorithm, SHA-1.
+	// Repositories may use a different algorithm, see [Git.HashAlgo].
 	HashSize = sha1.Size
 	// MaxHashSize is the maximum length that of a git hash no matter what
 	// algorithm is used. Corresponds with GIT_MAX_RAWSZ in "hash.h" of the git
@@ -39,6 +40,14 @@ const (
 	MaxHashSize = gitMaxRawSZ
 )
 
+// Git file modes as they are stored in the index and in tree objects.
+const (
+	modeTypeMask = 0170000
+	modeRegular  = 0100000
+	modeSymlink  = 0120000
+	modeGitlink  = 0160000
+)
+
 const (
 	mtimeChanged = 0x0001
 	ctimeChanged = 0x0002
@@ -55,68 +64,20 @@ type objectID struct {
 	algo int
 }
 
-// TODO:
-// - implement 'refresh_index' from "read-cache.c"
-
 type index struct {
 	header  indexCacheHeader
 	entries []indexCacheEntry
-}
 
-func (ix *index) indexDiff(workingTree string) ([]*ModifiedFile, error) {
-	mods := make([]*ModifiedFile, 0)
-	for _, entry := range ix.entries {
-		mod := ModifiedFile{
-			Name: entry.name,
-			Src: ObjModification{
-				Mode: int(entry.mode),
-				Hash: hex.EncodeToString(entry.oid),
-			},
-		}
-		fname := filepath.Join(workingTree, entry.name)
+	// extensions in the order they were found in the index file
+	extensions  []indexExtension
+	cacheTree   *cacheTree
+	resolveUndo []resolveUndoEntry
+	untracked   *untrackedCache
+	endOfIndex  *endOfIndexEntries
+	checksum    []byte
 
-		info, err := os.Lstat(fname)
-		if err != nil {
-			if os.IsNotExist(err) {
-				mod.Type = ModDelete
-				mod.Dst.Hash = string(make([]byte, hex.EncodedLen(HashSize))) // zero hash
-				goto next
-			} else {
-				return nil, err
-			}
-		}
-
-		if !info.ModTime().Equal(entry.statData.mtime.Time()) ||
-			info.Size() != int64(entry.statData.size) ||
-			info.Mode().Perm() != entry.mode.Perm() {
-			mod.Type = ModChanged
-		} else {
-			switch sys := info.Sys().(type) {
-			case *syscall.Stat_t:
-				if uint64(sys.Dev) != uint64(entry.statData.dev) ||
-					sys.Uid != entry.statData.uid ||
-					sys.Gid != entry.statData.gid ||
-					uint64(sys.Ino) != uint64(entry.statData.ino) {
-					mod.Type = ModChanged
-				} else {
-					continue
-				}
-			default:
-				continue
-			}
-		}
-		mod.Dst.Mode = int(info.Mode())
-		if mod.Dst.Mode&0100 != 0 {
-			mod.Dst.Mode = 0644
-		} else {
-			mod.Dst.Mode = 0755
-		}
-		mod.Dst.Mode |= 0100000
-		// mod.Dst.Mode = int(info.Mode())
-	next:
-		mods = append(mods, &mod)
-	}
-	return mods, nil
+	// algo is the hash algorithm used for object names and the checksum.
+	algo HashAlgo
 }
 
 // look for `struct cache_header` in read-cache-ll.h
@@ -141,29 +102,44 @@ func (hdr *indexCacheHeader) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
-func readIndex(r io.Reader) (*index, error) {
+func readIndex(r io.Reader, algo HashAlgo) (*index, error) {
 	// See 'do_read_index' in "read-cache.c"
 	raw, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	size := uint(len(raw))
-	var ix index
+	ix := index{algo: algo}
+	hashSize := algo.Size()
 	if err = ix.header.UnmarshalBinary(raw); err != nil {
 		return nil, err
 	}
+	if ix.header.version < 2 || ix.header.version > 4 {
+		return nil, fmt.Errorf("bad index version %d", ix.header.version)
+	}
+	if len(raw) < 12+hashSize {
+		return nil, errors.New("index file too short")
+	}
+	ix.checksum = raw[len(raw)-hashSize:]
+	// Trim the header and the trailing checksum.
+	body := raw[12 : len(raw)-hashSize]
 	ix.entries = make([]indexCacheEntry, ix.header.entries)
-	raw = raw[12:]
-	size -= 12
 	offset := uint(0)
-	for i := uint32(0); offset < size && i < ix.header.entries; i++ {
-		consumed, err := ix.entries[i].unmarshalBinary(raw[offset:], &ix.header)
+	prev := ""
+	for i := uint32(0); i < ix.header.entries; i++ {
+		if offset >= uint(len(body)) {
+			return nil, errors.New("index file truncated")
+		}
+		consumed, err := ix.entries[i].unmarshalBinary(body[offset:], &ix.header, prev, uint(hashSize))
 		if err != nil {
 			return nil, err
 		}
 		ix.entries[i].index = uint(i)
+		prev = ix.entries[i].name
 		offset += consumed
 	}
+	if err = ix.readExtensions(body[offset:]); err != nil {
+		return nil, err
+	}
 	return &ix, nil
 }
 
@@ -226,44 +202,78 @@ const (
 	ceStageMask = 0x3000
 	// provided by "read-cache-ll.h"
 	// #define CE_EXTENDED 0x4000
-	ceExtended = 0x4000
-	ceValid    = 0x8000
+	ceExtended   = 0x4000
+	ceValid      = 0x8000
+	ceStageShift = 12
+	// provided by "read-cache-ll.h"
+	// #define CE_INTENT_TO_ADD (1 << 29)
+	// #define CE_SKIP_WORKTREE (1 << 30)
+	ceIntentToAdd  = uint(1 << 29)
+	ceSkipWorktree = uint(1 << 30)
 	// provided by "read-cache-ll.h"
 	// #define CE_EXTENDED_FLAGS (CE_INTENT_TO_ADD | CE_SKIP_WORKTREE)
-	ceExtendedFlags    = uint((1 << 29) | (1 << 30))
+	ceExtendedFlags    = ceIntentToAdd | ceSkipWorktree
 	ceNotExtendedFlags = ^ceExtendedFlags
 )
 
-func (ce *indexCacheEntry) unmarshalBinary(data []byte, hdr *indexCacheHeader) (uint, error) {
-	const offset = uint(unsafe.Offsetof(indexOnDiskCacheEntry{}.data))
-
-	flagsp := data[offset+HashSize:]
+func (ce *indexCacheEntry) unmarshalBinary(data []byte, hdr *indexCacheHeader, prev string, hashSize uint) (uint, error) {
+	// See 'create_from_disk' in "read-cache.c"
+	const (
+		offset     = uint(unsafe.Offsetof(indexOnDiskCacheEntry{}.data))
+		uint16Size = uint(unsafe.Sizeof(uint16(0)))
+	)
+	if uint(len(data)) < offset+hashSize+uint16Size {
+		return 0, errors.New("index entry too short")
+	}
+	flagsp := data[offset+hashSize:]
 	flags := uint(binary.BigEndian.Uint16(flagsp))
 	length := flags & ceNameMask
 	expandNameField := hdr.version == 4
-	copyLength := uint(0)
-	var name []byte
+	namep := flagsp[uint16Size:]
 
 	if (flags & ceExtended) != 0 {
-		extendedFlags := uint(binary.BigEndian.Uint16(flagsp)) << 16
-		if (extendedFlags & (^ceExtendedFlags)) != 0 {
-			panic(fmt.Sprintf("unknown index entry format 0x%08x", extendedFlags))
+		if hdr.version < 3 {
+			return 0, fmt.Errorf("extended index entry flags in version %d index", hdr.version)
+		}
+		if len(namep) < int(uint16Size) {
+			return 0, errors.New("index entry too short")
+		}
+		extendedFlags := uint(binary.BigEndian.Uint16(namep)) << 16
+		if (extendedFlags & ceNotExtendedFlags) != 0 {
+			return 0, fmt.Errorf("unknown index entry format 0x%08x", extendedFlags)
 		}
 		flags |= extendedFlags
-		name = readCstringBytes(flagsp[2*unsafe.Sizeof(uint16(0)):])
-	} else {
-		name = readCstringBytes(flagsp[unsafe.Sizeof(uint16(0)):])
+		namep = namep[uint16Size:]
 	}
 
+	var (
+		name     []byte
+		consumed uint
+	)
 	if expandNameField {
-		panic("unfinished. Look for expand_name_field block in `create_from_disk` in read-cache.c")
+		// Version 4 prefix compresses the path name relative to the path
+		// name of the previous entry.
+		stripLen, n := decodeVarint(namep)
+		if n == 0 || stripLen > uint64(len(prev)) {
+			return 0, errors.New("malformed name field in the index")
+		}
+		suffix := readCstringBytes(namep[n:])
+		if suffix == nil {
+			return 0, errors.New("unterminated index entry path name")
+		}
+		name = make([]byte, 0, len(prev)-int(stripLen)+len(suffix))
+		name = append(name, prev[:len(prev)-int(stripLen)]...)
+		name = append(name, suffix...)
+		consumed = uint(len(data)-len(namep)) + uint(n) + uint(len(suffix)) + 1
+	} else {
+		name = readCstringBytes(namep)
+		if name == nil {
+			return 0, errors.New("unterminated index entry path name")
+		}
 	}
 
 	if length == ceNameMask {
 		length = uint(len(name))
-		if expandNameField {
-			length += copyLength
-		}
 	}
 
 	ce.statData.ctime.sec = binary.BigEndian.Uint32(data)
@@ -277,23 +287,30 @@ func (ce *indexCacheEntry) unmarshalBinary(data []byte, hdr *indexCacheHeader) (
 	ce.statData.gid = binary.BigEndian.Uint32(data[32:])
 	ce.statData.size = binary.BigEndian.Uint32(data[36:])
 
-	ce.oid = data[offset : offset+HashSize]
+	ce.oid = data[offset : offset+hashSize]
 	ce.flags = flags & (^uint(ceNameMask)) // remove the string length from flags
 	ce.nameLen = length
+	ce.name = string(name)
 	if expandNameField {
-		panic("unfinished. Look for expand_name_field block in `create_from_disk` in read-cache.c")
-		// if copyLength != 0 {
-		// 	panic("unfinished. look for expand_name_field block in `create_from_disk` in read-cache.c")
-		// }
-		// ce.name = string(name[copyLength:])
-	} else {
-		ce.name = string(name)
-		return cacheEntryDiskLength(ce), nil
+		// Version 4 entries are not padded.
+		return consumed, nil
 	}
+	return cacheEntryDiskLength(ce, hashSize), nil
 }
 
-func cacheEntryDiskLength(ce *indexCacheEntry) uint {
-	const hashSize = uint(HashSize)
+// stage returns the merge stage of the entry. Zero means the entry is merged.
+func (ce *indexCacheEntry) stage() int {
+	return int((ce.flags & ceStageMask) >> ceStageShift)
+}
+
+// skipWorktree reports whether the entry has the CE_SKIP_WORKTREE flag which
+// is used by sparse checkouts.
+func (ce *indexCacheEntry) skipWorktree() bool { return ce.flags&ceSkipWorktree != 0 }
+
+// intentToAdd reports whether the entry was added with 'git add -N'.
+func (ce *indexCacheEntry) intentToAdd() bool { return ce.flags&ceIntentToAdd != 0 }
+
+func cacheEntryDiskLength(ce *indexCacheEntry, hashSize uint) uint {
 	const uint16Size = uint(unsafe.Sizeof(uint16(0)))
 	const dataOffset = uint(unsafe.Offsetof(indexOnDiskCacheEntry{}.data))
 	var nflags uint
@@ -313,3 +330,320 @@ func readCstringBytes(raw []byte) []byte {
 	}
 	return raw[:i]
 }
+
+// MarshalBinary encodes the index in the on-disk format including the trailing
+// checksum. See 'do_write_index' in "read-cache.c".
+func (ix *index) MarshalBinary() ([]byte, error) {
+	version := ix.header.version
+	if version == 0 {
+		version = 2
+	}
+	for i := range ix.entries {
+		if ix.entries[i].flags&ceExtendedFlags != 0 && version < 3 {
+			version = 3
+		}
+	}
+	hashSize := ix.algo.Size()
+	buf := make([]byte, 12, 12+len(ix.entries)*(64+hashSize))
+	binary.BigEndian.PutUint32(buf, cacheSignature)
+	binary.BigEndian.PutUint32(buf[4:], version)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(ix.entries)))
+	prev := ""
+	for i := range ix.entries {
+		buf = ix.entries[i].appendBinary(buf, version, prev)
+		prev = ix.entries[i].name
+	}
+
+	extStart := len(buf)
+	eoie := ix.algo.New()
+	for _, ext := range ix.extensions {
+		if ext.signature == extEndOfIndex {
+			// The end of index extension is always recomputed since the
+			// entries may have changed size.
+			continue
+		}
+		if len(ext.signature) != 4 {
+			return nil, fmt.Errorf("invalid index extension signature %q", ext.signature)
+		}
+		var hdr [extHeaderSize]byte
+		copy(hdr[:], ext.signature)
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(ext.data)))
+		eoie.Write(hdr[:])
+		buf = append(buf, hdr[:]...)
+		buf = append(buf, ext.data...)
+	}
+	if ix.endOfIndex != nil {
+		buf = append(buf, extEndOfIndex...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(4+hashSize))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(extStart))
+		buf = eoie.Sum(buf)
+	}
+	h := ix.algo.New()
+	h.Write(buf)
+	sum := h.Sum(nil)
+	ix.header.signature = cacheSignature
+	ix.header.version = version
+	ix.header.entries = uint32(len(ix.entries))
+	ix.checksum = sum
+	return append(buf, sum...), nil
+}
+
+// writeLock writes the index to the lock of the index file which replaces
+// the index once it is committed. The lock should be taken before the index
+// is read so that changes made by other processes in between are not lost.
+func (ix *index) writeLock(lock *lockFile) error {
+	raw, err := ix.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(raw)
+	return err
+}
+
+func readIndexFile(filename string, algo HashAlgo) (*index, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readIndex(f, algo)
+}
+
+func (ce *indexCacheEntry) appendBinary(buf []byte, version uint32, prev string) []byte {
+	// See 'ce_write_entry' in "read-cache.c"
+	start := len(buf)
+	sd := &ce.statData
+	for _, v := range []uint32{
+		sd.ctime.sec, sd.ctime.nsec,
+		sd.mtime.sec, sd.mtime.nsec,
+		sd.dev, sd.ino,
+		uint32(ce.mode),
+		sd.uid, sd.gid, sd.size,
+	} {
+		buf = binary.BigEndian.AppendUint32(buf, v)
+	}
+	buf = append(buf, ce.oid...)
+
+	flags := ce.flags & 0xffff &^ (ceNameMask | ceExtended)
+	extended := ce.flags & ceExtendedFlags
+	if extended != 0 {
+		flags |= ceExtended
+	}
+	flags |= min(uint(len(ce.name)), ceNameMask)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(flags))
+	if extended != 0 {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(extended>>16))
+	}
+
+	if version == 4 {
+		common := 0
+		for common < len(prev) && common < len(ce.name) && prev[common] == ce.name[common] {
+			common++
+		}
+		buf = appendVarint(buf, uint64(len(prev)-common))
+		buf = append(buf, ce.name[common:]...)
+		return append(buf, 0)
+	}
+	buf = append(buf, ce.name...)
+	// Pad with at least one NUL byte up to a multiple of eight bytes.
+	padded := start + int((uint(len(buf)-start)+8)&^uint(7))
+	for len(buf) < padded {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// matchStat compares the cached stat data with the file info of a file in the
+// working tree and returns the set of changes. See 'ie_match_stat' in
+// "read-cache.c".
+func (ce *indexCacheEntry) matchStat(info fs.FileInfo) uint {
+	var changed uint
+	mode := gitFileMode(info)
+	if ce.mode&modeTypeMask != mode&modeTypeMask {
+		changed |= typeChanged
+	} else if ce.mode&modeTypeMask == modeRegular && ce.mode.Perm() != mode.Perm() {
+		changed |= modeChanged
+	}
+	mtime := info.ModTime()
+	if int64(ce.statData.mtime.sec) != mtime.Unix() ||
+		int64(ce.statData.mtime.nsec) != int64(mtime.Nanosecond()) {
+		changed |= mtimeChanged
+	}
+	if ce.statData.size != uint32(info.Size()) {
+		changed |= dataChanged
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		sec, nsec := statCtime(st)
+		if int64(ce.statData.ctime.sec) != sec || int64(ce.statData.ctime.nsec) != nsec {
+			changed |= ctimeChanged
+		}
+		if ce.statData.uid != st.Uid || ce.statData.gid != st.Gid {
+			changed |= ownerChanged
+		}
+		if ce.statData.ino != uint32(st.Ino) {
+			changed |= inodeChanged
+		}
+	}
+	return changed
+}
+
+// isRacy reports whether the entry could have been modified in the same
+// timestamp granularity as the index was written in which case the stat data
+// cannot be trusted. See 'is_racy_stat' in "read-cache.c".
+func (ce *indexCacheEntry) isRacy(indexMtime time.Time) bool {
+	mtime := ce.statData.mtime.Time()
+	return !indexMtime.IsZero() && !mtime.Before(indexMtime)
+}
+
+// fillStatData updates the cached stat data. See 'fill_stat_data' in
+// "statinfo.c".
+func (ce *indexCacheEntry) fillStatData(info fs.FileInfo) {
+	mtime := info.ModTime()
+	ce.statData.mtime = indexCacheTime{sec: uint32(mtime.Unix()), nsec: uint32(mtime.Nanosecond())}
+	ce.statData.size = uint32(info.Size())
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		sec, nsec := statCtime(st)
+		ce.statData.ctime = indexCacheTime{sec: uint32(sec), nsec: uint32(nsec)}
+		ce.statData.dev = uint32(st.Dev)
+		ce.statData.ino = uint32(st.Ino)
+		ce.statData.uid = st.Uid
+		ce.statData.gid = st.Gid
+	}
+}
+
+// gitFileMode converts file info to the file mode git would store in the
+// index. See 'ce_mode_from_stat' in "read-cache-ll.h".
+func gitFileMode(info fs.FileInfo) fs.FileMode {
+	m := info.Mode()
+	switch {
+	case m&fs.ModeSymlink != 0:
+		return modeSymlink
+	case m.IsDir():
+		return modeGitlink
+	case m&0100 != 0:
+		return modeRegular | 0755
+	default:
+		return modeRegular | 0644
+	}
+}
+
+// hashWorktreeFile returns the blob hash for a file in the working tree. The
+// name is the path in the index which is used to find its filter.
+func hashWorktreeFile(algo HashAlgo, path string, info fs.FileInfo, pf *pathFilters, name string) ([]byte, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return objectHash(algo, ObjBlob, uint64(len(target)), strings.NewReader(target)), nil
+	}
+	if pf.get(name) != nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = pf.clean(name, data); err != nil {
+			return nil, err
+		}
+		return objectHash(algo, ObjBlob, uint64(len(data)), bytes.NewReader(data)), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return objectHash(algo, ObjBlob, uint64(info.Size()), f), nil
+}
+
+// RefreshIndex re-stats all the files in the index and rewrites the cached stat
+// data for files whose contents have not changed. This is the equivalent of
+// running 'git update-index --refresh' except that files needing an update are
+// not reported.
+func (g *Git) RefreshIndex() (err error) {
+	// See 'refresh_index' and 'refresh_cache_ent' in "read-cache.c"
+	filename := g.indexFile()
+	if _, err = os.Stat(filename); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return err
+	}
+	lock, err := newLockFile(filename, 0644)
+	if err != nil {
+		return err
+	}
+	updated := false
+	defer func() {
+		if err != nil || !updated {
+			_ = lock.Rollback()
+		}
+	}()
+	indexInfo, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	ix, err := readIndexFile(filename, algo)
+	if err != nil {
+		return err
+	}
+	pf, err := g.worktreeFilters()
+	if err != nil {
+		return err
+	}
+	for i := range ix.entries {
+		ce := &ix.entries[i]
+		if ce.stage() != 0 || ce.skipWorktree() || ce.intentToAdd() ||
+			ce.mode&modeTypeMask == modeGitlink {
+			continue
+		}
+		path := filepath.Join(g.workTree, ce.name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		changed := ce.matchStat(info)
+		if changed == 0 && !ce.isRacy(indexInfo.ModTime()) {
+			continue
+		}
+		if changed&(typeChanged|modeChanged) != 0 {
+			continue // needs update
+		}
+		hash, err := hashWorktreeFile(algo, path, info, pf, ce.name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, ce.oid) || changed == 0 {
+			continue
+		}
+		ce.fillStatData(info)
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	if err = ix.writeLock(lock); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// appendVarint appends an offset encoded integer. See 'encode_varint' in
+// "varint.c".
+func appendVarint(buf []byte, value uint64) []byte {
+	var varint [16]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(value & 127)
+	for value >>= 7; value != 0; value >>= 7 {
+		pos--
+		value--
+		varint[pos] = 128 | byte(value&127)
+	}
+	return append(buf, varint[pos:]...)
+}
@@ -0,0 +1,259 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+// Index extension signatures. See "Documentation/gitformat-index.txt".
+const (
+	extCacheTree     = "TREE"
+	extResolveUndo   = "REUC"
+	extUntracked     = "UNTR"
+	extEndOfIndex    = "EOIE"
+	extOffsetTable   = "IEOT"
+	extSplitIndex    = "link"
+	extSparseDir     = "sdir"
+	extFSMonitor     = "FSMN"
+	extHeaderSize    = 8
+	untrackedStatLen = 36 // on-disk 'struct stat_data' in "dir.c"
+)
+
+// indexExtension is the raw data for a single index extension.
+type indexExtension struct {
+	signature string
+	data      []byte
+}
+
+// cacheTree is the "TREE" extension which caches the tree object names of
+// directories in the index. See 'read_one' in "cache-tree.c".
+type cacheTree struct {
+	name string
+	// entryCount is the number of index entries covered by this tree or -1
+	// if the tree has been invalidated.
+	entryCount int
+	subtrees   []*cacheTree
+	oid        []byte
+}
+
+// resolveUndoEntry is a single entry in the "REUC" extension which records
+// the higher stage entries of a path before a merge conflict was resolved.
+type resolveUndoEntry struct {
+	name  string
+	modes [3]fs.FileMode
+	oids  [3][]byte
+}
+
+// untrackedCache is the "UNTR" extension. Only the header is parsed, the rest
+// of the extension is opaque to us.
+type untrackedCache struct {
+	idents        []string
+	dirFlags      uint32
+	excludePerDir string
+}
+
+// endOfIndexEntries is the "EOIE" extension that points at the start of the
+// extensions.
+type endOfIndexEntries struct {
+	offset uint32
+	hash   []byte
+}
+
+func (ix *index) readExtensions(data []byte) error {
+	// See 'read_index_extension' in "read-cache.c"
+	hashSize := ix.algo.Size()
+	for len(data) >= extHeaderSize {
+		sig := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:])
+		if uint64(size) > uint64(len(data)-extHeaderSize) {
+			return fmt.Errorf("index extension %q is truncated", sig)
+		}
+		body := data[extHeaderSize : extHeaderSize+size]
+		data = data[extHeaderSize+size:]
+		var err error
+		switch sig {
+		case extCacheTree:
+			ix.cacheTree, err = parseCacheTree(body, hashSize)
+		case extResolveUndo:
+			ix.resolveUndo, err = parseResolveUndo(body, hashSize)
+		case extUntracked:
+			ix.untracked, err = parseUntrackedCache(body, hashSize)
+		case extEndOfIndex:
+			ix.endOfIndex, err = parseEndOfIndex(body, hashSize)
+		case extOffsetTable, extSplitIndex, extSparseDir, extFSMonitor:
+		default:
+			// Extensions starting with an upper case letter are optional
+			// and can be ignored.
+			if sig[0] < 'A' || sig[0] > 'Z' {
+				return fmt.Errorf("index uses %q extension, which we do not understand", sig)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s index extension: %w", sig, err)
+		}
+		ix.extensions = append(ix.extensions, indexExtension{signature: sig, data: body})
+	}
+	if len(data) != 0 {
+		return errors.New("trailing garbage after index extensions")
+	}
+	return nil
+}
+
+func parseCacheTree(data []byte, hashSize int) (*cacheTree, error) {
+	t, rest, err := readCacheTree(data, hashSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after cache tree")
+	}
+	return t, nil
+}
+
+func readCacheTree(data []byte, hashSize int) (*cacheTree, []byte, error) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return nil, nil, errors.New("unterminated path")
+	}
+	t := cacheTree{name: string(data[:i])}
+	data = data[i+1:]
+	i = bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, nil, errors.New("unterminated entry count")
+	}
+	counts := bytes.SplitN(data[:i], []byte{' '}, 2)
+	if len(counts) != 2 {
+		return nil, nil, errors.New("invalid entry count")
+	}
+	data = data[i+1:]
+	var err error
+	t.entryCount, err = strconv.Atoi(string(counts[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	nsub, err := strconv.Atoi(string(counts[1]))
+	if err != nil || nsub < 0 {
+		return nil, nil, errors.New("invalid subtree count")
+	}
+	if t.entryCount >= 0 {
+		if len(data) < hashSize {
+			return nil, nil, errors.New("truncated object name")
+		}
+		t.oid = data[:hashSize]
+		data = data[hashSize:]
+	}
+	t.subtrees = make([]*cacheTree, 0, nsub)
+	for range nsub {
+		var sub *cacheTree
+		sub, data, err = readCacheTree(data, hashSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.subtrees = append(t.subtrees, sub)
+	}
+	return &t, data, nil
+}
+
+func parseResolveUndo(data []byte, hashSize int) ([]resolveUndoEntry, error) {
+	// See 'resolve_undo_read' in "resolve-undo.c"
+	entries := make([]resolveUndoEntry, 0)
+	for len(data) > 0 {
+		var e resolveUndoEntry
+		name := readCstringBytes(data)
+		if name == nil {
+			return nil, errors.New("unterminated path")
+		}
+		e.name = string(name)
+		data = data[len(name)+1:]
+		for i := range e.modes {
+			m := readCstringBytes(data)
+			if m == nil {
+				return nil, errors.New("unterminated mode")
+			}
+			mode, err := strconv.ParseUint(string(m), 8, 32)
+			if err != nil {
+				return nil, err
+			}
+			e.modes[i] = fs.FileMode(mode)
+			data = data[len(m)+1:]
+		}
+		for i := range e.oids {
+			if e.modes[i] == 0 {
+				continue
+			}
+			if len(data) < hashSize {
+				return nil, errors.New("truncated object name")
+			}
+			e.oids[i] = data[:hashSize]
+			data = data[hashSize:]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseUntrackedCache(data []byte, hashSize int) (*untrackedCache, error) {
+	// See 'read_untracked_extension' in "dir.c"
+	identLen, n := decodeVarint(data)
+	if n == 0 || uint64(len(data)-n) < identLen {
+		return nil, errors.New("invalid ident length")
+	}
+	var uc untrackedCache
+	idents := data[n : n+int(identLen)]
+	for len(idents) > 0 {
+		id := readCstringBytes(idents)
+		if id == nil {
+			return nil, errors.New("unterminated ident")
+		}
+		uc.idents = append(uc.idents, string(id))
+		idents = idents[len(id)+1:]
+	}
+	data = data[n+int(identLen):]
+	const hdrLen = 2*untrackedStatLen + 4
+	if len(data) < hdrLen+2*hashSize {
+		return nil, errors.New("truncated header")
+	}
+	uc.dirFlags = binary.BigEndian.Uint32(data[2*untrackedStatLen:])
+	data = data[hdrLen+2*hashSize:]
+	excl := readCstringBytes(data)
+	if excl == nil {
+		return nil, errors.New("unterminated exclude file name")
+	}
+	uc.excludePerDir = string(excl)
+	return &uc, nil
+}
+
+func parseEndOfIndex(data []byte, hashSize int) (*endOfIndexEntries, error) {
+	if len(data) != 4+hashSize {
+		return nil, errors.New("invalid size")
+	}
+	return &endOfIndexEntries{
+		offset: binary.BigEndian.Uint32(data),
+		hash:   data[4:],
+	}, nil
+}
+
+// decodeVarint decodes the offset encoded integers used by the index and
+// returns the number of bytes read. See 'decode_varint' in "varint.c".
+func decodeVarint(buf []byte) (uint64, int) {
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	c := buf[0]
+	val := uint64(c & 127)
+	n := 1
+	for c&128 != 0 {
+		if n >= len(buf) || n > 9 {
+			return 0, 0
+		}
+		val++
+		c = buf[n]
+		n++
+		val = (val << 7) + uint64(c&127)
+	}
+	return val, n
+}
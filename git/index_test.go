@@ -1,11 +1,13 @@
 package git
 
 import (
+	"bytes"
 	"encoding/hex"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 )
@@ -26,7 +28,7 @@ func TestIndex(t *testing.T) {
 	is.NoErr(m.Git().Commit("2nd commit"))
 	// Read/Test index file
 	f := must(os.Open(m.Git().indexFile()))
-	index, err := readIndex(f)
+	index, err := readIndex(f, SHA1)
 	is.NoErr(err)
 	is.NoErr(f.Close())
 	is.True(len(index.entries) > 0)
@@ -53,3 +55,266 @@ func TestIndex(t *testing.T) {
 		is.True(exists(objPath))
 	}
 }
+
+func TestIndex_Version4(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("git/git.go", "package git\n"),
+		newfile("git/index.go", "package git\n\ntype index struct{}\n"),
+		newfile("git/objects.go", "package git\n\ntype Object struct{}\n"),
+		newfile("git/objects/objects.go", "package objects\n"),
+		fileFrom("../README.md"),
+	))
+	before := readTestIndex(t, git)
+	is.Equal(before.header.version, uint32(2))
+	is.NoErr(git.RunCmd("update-index", "--index-version", "4"))
+	ix := readTestIndex(t, git)
+	is.Equal(ix.header.version, uint32(4))
+	is.Equal(len(ix.entries), len(before.entries))
+	for i, e := range ix.entries {
+		is.Equal(e.name, before.entries[i].name)
+		is.Equal(e.nameLen, uint(len(e.name)))
+		is.Equal(e.oid, before.entries[i].oid)
+		is.Equal(e.mode, before.entries[i].mode)
+		is.Equal(e.statData, before.entries[i].statData)
+	}
+	is.Equal(ix.entries[1].name, "git/git.go")
+	is.Equal(ix.entries[4].name, "git/objects/objects.go")
+}
+
+func TestIndex_ExtendedFlags(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("a", "a\n"),
+		newfile("b", "b\n"),
+		newfile("c", "c\n"),
+	))
+	is.NoErr(git.RunCmd("update-index", "--skip-worktree", "b"))
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "new"), []byte("new\n"), 0644))
+	is.NoErr(git.RunCmd("add", "--intent-to-add", "new"))
+	for _, version := range []string{"3", "4"} {
+		is.NoErr(git.RunCmd("update-index", "--index-version", version))
+		ix := readTestIndex(t, git)
+		is.Equal(len(ix.entries), 4)
+		is.Equal(ix.entries[0].name, "a")
+		is.True(!ix.entries[0].skipWorktree())
+		is.Equal(ix.entries[1].name, "b")
+		is.True(ix.entries[1].skipWorktree())
+		is.True(ix.entries[1].flags&ceExtended != 0)
+		is.Equal(ix.entries[2].name, "c")
+		is.Equal(ix.entries[3].name, "new")
+		is.True(ix.entries[3].intentToAdd())
+		for _, e := range ix.entries {
+			is.Equal(e.stage(), 0)
+		}
+	}
+}
+
+func TestIndex_Extensions(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("git/git.go", "package git\n"),
+		newfile("git/index.go", "package git\n\ntype index struct{}\n"),
+	))
+	is.NoErr(git.RunCmd(
+		"-c", "index.recordEndOfIndexEntries=true",
+		"-c", "index.recordOffsetTable=true",
+		"-c", "core.untrackedCache=true",
+		"update-index", "--index-version", "4", "--untracked-cache",
+	))
+	is.NoErr(git.RunCmd("-c", "core.untrackedCache=true", "status", "--untracked-files=all"))
+	is.NoErr(git.RunCmd("write-tree")) // make sure the cache tree is complete
+	is.NoErr(git.RunCmd(
+		"-c", "index.recordEndOfIndexEntries=true",
+		"-c", "index.recordOffsetTable=true",
+		"-c", "core.untrackedCache=true",
+		"update-index", "--index-version", "2", "--untracked-cache",
+	))
+	ix := readTestIndex(t, git)
+	is.Equal(len(ix.entries), 3)
+	is.True(ix.cacheTree != nil)
+	is.Equal(ix.cacheTree.name, "")
+	is.Equal(ix.cacheTree.entryCount, 3)
+	is.Equal(len(ix.cacheTree.subtrees), 1)
+	is.Equal(ix.cacheTree.subtrees[0].name, "git")
+	is.Equal(ix.cacheTree.subtrees[0].entryCount, 2)
+	cm := must(git.HeadCommit())
+	is.Equal(Hash(ix.cacheTree.oid), cm.Tree)
+	is.True(ix.untracked != nil)
+	is.True(len(ix.untracked.idents) > 0)
+	is.Equal(ix.untracked.excludePerDir, ".gitignore")
+	is.True(ix.endOfIndex != nil)
+	sigs := make([]string, len(ix.extensions))
+	for i, ext := range ix.extensions {
+		sigs[i] = ext.signature
+	}
+	is.Equal(sigs[len(sigs)-1], extEndOfIndex) // EOIE is always the last extension
+}
+
+func TestParseResolveUndo(t *testing.T) {
+	is := is.New(t)
+	oid := func(b byte) string { return string(bytes.Repeat([]byte{b}, HashSize)) }
+	raw := "file.txt\x00100644\x00100755\x000\x00" + oid(1) + oid(2) +
+		"other\x000\x00100644\x00100644\x00" + oid(3) + oid(4)
+	entries, err := parseResolveUndo([]byte(raw), HashSize)
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].name, "file.txt")
+	is.Equal(entries[0].modes, [3]fs.FileMode{0100644, 0100755, 0})
+	is.Equal(entries[0].oids[0], []byte(oid(1)))
+	is.Equal(entries[0].oids[1], []byte(oid(2)))
+	is.True(entries[0].oids[2] == nil)
+	is.Equal(entries[1].name, "other")
+	is.True(entries[1].oids[0] == nil)
+	is.Equal(entries[1].oids[2], []byte(oid(4)))
+	_, err = parseResolveUndo([]byte("file.txt\x00100644\x00"), HashSize)
+	is.True(err != nil)
+}
+
+func TestDecodeVarint(t *testing.T) {
+	is := is.New(t)
+	for _, tt := range []struct {
+		in  []byte
+		val uint64
+		n   int
+	}{
+		{[]byte{0}, 0, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x80, 0x00}, 128, 2},
+		{[]byte{0x80, 0x7f}, 255, 2},
+		{[]byte{0x81, 0x00, 0xff}, 256, 2},
+		{[]byte{0x80}, 0, 0},
+		{nil, 0, 0},
+	} {
+		val, n := decodeVarint(tt.in)
+		is.Equal(val, tt.val)
+		is.Equal(n, tt.n)
+	}
+}
+
+func readTestIndex(t *testing.T, g *Git) *index {
+	t.Helper()
+	f, err := os.Open(g.indexFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ix, err := readIndex(f, must(g.HashAlgo()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func TestIndex_MarshalBinary(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("a-really-long-file-name-that-needs-more-padding.txt", "padding\n"),
+		newfile("git/git.go", "package git\n"),
+		newfile("git/index.go", "package git\n\ntype index struct{}\n"),
+		newfile("git/objects/objects.go", "package objects\n"),
+	))
+	for _, args := range [][]string{
+		{"update-index", "--index-version", "2"},
+		{"update-index", "--index-version", "3", "--skip-worktree", "one"},
+		{"update-index", "--index-version", "4"},
+		{
+			"-c", "index.recordEndOfIndexEntries=true",
+			"-c", "index.recordOffsetTable=true",
+			"update-index", "--index-version", "2", "--no-skip-worktree", "one",
+		},
+	} {
+		is.NoErr(git.RunCmd(args...))
+		raw, err := os.ReadFile(git.indexFile())
+		is.NoErr(err)
+		ix, err := readIndex(bytes.NewReader(raw), SHA1)
+		is.NoErr(err)
+		out, err := ix.MarshalBinary()
+		is.NoErr(err)
+		is.Equal(len(out), len(raw))
+		is.True(bytes.Equal(out, raw)) // index should round-trip exactly
+	}
+}
+
+func TestGit_RefreshIndex(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+		newfile("three", "this is the third file\n"),
+	))
+	is.NoErr(os.Symlink("one", filepath.Join(git.WorkingTree(), "link")))
+	is.NoErr(git.Add("link"))
+	is.NoErr(git.Commit("add link"))
+	// Make sure that everything is older than the index so that nothing is
+	// racily clean.
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"one", "two", "three"} {
+		is.NoErr(os.Chtimes(filepath.Join(git.WorkingTree(), name), past, past))
+	}
+	is.NoErr(appendfile(filepath.Join(git.WorkingTree(), "three"), "modified\n"))
+	files, err := git.ModifiedFiles()
+	is.NoErr(err)
+	is.Equal(len(files), 3) // stat data should be stale for all touched files
+	before := readTestIndex(t, git)
+
+	is.NoErr(git.RefreshIndex())
+	files, err = git.ModifiedFiles()
+	is.NoErr(err)
+	is.Equal(files, []string{"three"})
+	ix := readTestIndex(t, git)
+	is.Equal(len(ix.entries), len(before.entries))
+	for i, e := range ix.entries {
+		info, err := os.Lstat(filepath.Join(git.WorkingTree(), e.name))
+		is.NoErr(err)
+		is.Equal(e.oid, before.entries[i].oid)
+		switch e.name {
+		case "one", "two":
+			is.Equal(e.matchStat(info), uint(0))
+		case "three":
+			is.Equal(e.statData, before.entries[i].statData) // should not be refreshed
+		}
+	}
+	// Refreshing a clean index should not touch it.
+	stat, err := os.Stat(git.indexFile())
+	is.NoErr(err)
+	is.NoErr(git.RefreshIndex())
+	stat2, err := os.Stat(git.indexFile())
+	is.NoErr(err)
+	is.Equal(stat.ModTime(), stat2.ModTime())
+	is.True(!exists(git.indexFile() + ".lock"))
+
+	// The index should not be read or written while another process holds
+	// the lock.
+	is.NoErr(os.Chtimes(filepath.Join(git.WorkingTree(), "one"), past, past))
+	lock, err := newLockFile(git.indexFile(), 0644)
+	is.NoErr(err)
+	is.True(git.RefreshIndex() != nil)
+	is.NoErr(lock.Rollback())
+	stat2, err = os.Stat(git.indexFile())
+	is.NoErr(err)
+	is.Equal(stat.ModTime(), stat2.ModTime())
+}
+
+func TestAppendVarint(t *testing.T) {
+	is := is.New(t)
+	for _, v := range []uint64{0, 1, 127, 128, 255, 256, 16383, 16384, 1 << 32, 1<<63 + 5} {
+		buf := appendVarint(nil, v)
+		val, n := decodeVarint(buf)
+		is.Equal(n, len(buf))
+		is.Equal(val, v)
+	}
+}
@@ -0,0 +1,47 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile is a "<file>.lock" file used to atomically replace files in the git
+// directory the same way git does. See "lockfile.h".
+type lockFile struct {
+	*os.File
+	target string
+}
+
+func newLockFile(target string, perm os.FileMode) (*lockFile, error) {
+	f, err := os.OpenFile(target+".lock", os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf(
+				"unable to create %q: another git process seems to be running",
+				target+".lock",
+			)
+		}
+		return nil, err
+	}
+	return &lockFile{File: f, target: target}, nil
+}
+
+// Commit will close the lock file and rename it to the target file.
+func (lf *lockFile) Commit() error {
+	if err := lf.File.Close(); err != nil {
+		_ = os.Remove(lf.Name())
+		return err
+	}
+	return os.Rename(lf.Name(), lf.target)
+}
+
+// Rollback will remove the lock file without touching the target file.
+func (lf *lockFile) Rollback() error {
+	_ = lf.File.Close()
+	err := os.Remove(lf.Name())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"container/heap"
+	"iter"
+	"maps"
+	"strings"
+)
+
+// Log walks the history reachable from a ref in reverse chronological order
+// of the committer dates, the same as 'git log'. If paths are given then only
+// commits that change those paths are yielded. Like git's default history
+// simplification, a commit that has the same contents as one of its parents
+// for the given paths is skipped and only that parent is followed.
+func (g *Git) Log(from Ref, paths ...string) iter.Seq2[*Commit, error] {
+	return func(yield func(*Commit, error) bool) {
+		start, err := g.PeelCommit(from)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		w := logWalker{
+			git:   g,
+			paths: paths,
+			seen:  map[string]struct{}{string(start.Hash): {}},
+			trees: make(map[string]map[string]TreeEntry),
+		}
+		w.push(start)
+		for w.queue.Len() > 0 {
+			c := heap.Pop(&w.queue).(*Commit)
+			show, err := w.visit(c)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if show && !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
+
+type logWalker struct {
+	git   *Git
+	paths []string
+	queue commitQueue
+	seq   int
+	seen  map[string]struct{}
+	// trees caches the path filtered contents of trees.
+	trees map[string]map[string]TreeEntry
+}
+
+// visit queues the parents of a commit that should be walked and reports
+// whether the commit itself should be shown. See 'try_to_simplify_commit' in
+// "revision.c".
+func (w *logWalker) visit(c *Commit) (bool, error) {
+	parents := make([]*Commit, 0, len(c.Parents))
+	for _, hash := range c.Parents {
+		p, err := w.git.OpenCommit(NewHashRef(hash))
+		if err != nil {
+			return false, err
+		}
+		parents = append(parents, p)
+	}
+	show := true
+	if len(w.paths) > 0 {
+		files, err := w.filteredTree(c.Tree)
+		if err != nil {
+			return false, err
+		}
+		if len(parents) == 0 {
+			show = len(files) > 0
+		}
+		for _, p := range parents {
+			pfiles, err := w.filteredTree(p.Tree)
+			if err != nil {
+				return false, err
+			}
+			if maps.EqualFunc(files, pfiles, TreeEntry.equal) {
+				// TREESAME to this parent, only follow it.
+				parents = []*Commit{p}
+				show = false
+				break
+			}
+		}
+	}
+	for _, p := range parents {
+		if _, ok := w.seen[string(p.Hash)]; ok {
+			continue
+		}
+		w.seen[string(p.Hash)] = struct{}{}
+		w.push(p)
+	}
+	return show, nil
+}
+
+func (w *logWalker) push(c *Commit) {
+	heap.Push(&w.queue, queuedCommit{Commit: c, seq: w.seq})
+	w.seq++
+}
+
+func (w *logWalker) filteredTree(tree Hash) (map[string]TreeEntry, error) {
+	if files, ok := w.trees[string(tree)]; ok {
+		return files, nil
+	}
+	files := make(map[string]TreeEntry)
+	if err := w.git.treeFiles(NewHashRef(tree), "", files); err != nil {
+		return nil, err
+	}
+	maps.DeleteFunc(files, func(name string, _ TreeEntry) bool {
+		return !matchPaths(name, w.paths)
+	})
+	w.trees[string(tree)] = files
+	return files, nil
+}
+
+// matchPaths reports whether a file is one of the paths or is inside one of
+// them.
+func matchPaths(name string, paths []string) bool {
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		if p == "" || p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type queuedCommit struct {
+	*Commit
+	seq int
+}
+
+// commitQueue is a priority queue of commits with the most recent committer
+// date first. Commits with the same date are popped in the order they were
+// pushed. See "prio-queue.c".
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if !q[i].CommiterTime.Equal(q[j].CommiterTime) {
+		return q[i].CommiterTime.After(q[j].CommiterTime)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c.Commit
+}
@@ -0,0 +1,165 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const signedCommit = "tree 3b18e512dba79e4c8300dd08aeb37f8e728b8dad\n" +
+	"parent 7e2e4ee1c8bc1e04fb9b5b2b1d0e52dea7d3d8ab\n" +
+	"parent 1f7a7a472abf3dd9643fd615f6da379c4acb3e3a\n" +
+	"author Jane Doe <jane@example.com> 1700000000 -0500\n" +
+	"committer Jane Doe <jane@example.com> 1700000100 +0130\n" +
+	"encoding ISO-8859-1\n" +
+	"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+	" \n" +
+	" iQEzBAABCAAdFiEE\n" +
+	" =abcd\n" +
+	" -----END PGP SIGNATURE-----\n" +
+	"\n" +
+	"Merge branch 'other'\n" +
+	"\n" +
+	"with a body\n"
+
+func TestParseCommit_Headers(t *testing.T) {
+	is := is.New(t)
+	var c Commit
+	is.NoErr(parseCommit(bufio.NewReader(strings.NewReader(signedCommit)), &c))
+	is.Equal(len(c.Parents), 2)
+	is.True(c.IsMerge())
+	is.Equal(hex.EncodeToString(c.Parents[1][:]), "1f7a7a472abf3dd9643fd615f6da379c4acb3e3a")
+	is.Equal(c.Author, "Jane Doe <jane@example.com> ")
+	is.Equal(c.AuthorTime.Unix(), int64(1700000000))
+	is.Equal(len(c.ExtraHeaders), 2)
+	is.Equal(c.ExtraHeaders[0], CommitHeader{Key: "encoding", Value: "ISO-8859-1"})
+	is.Equal(c.Signature(), "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----")
+	is.Equal(c.Message, "Merge branch 'other'\n\nwith a body")
+	raw, err := c.MarshalBinary()
+	is.NoErr(err)
+	is.Equal(string(raw), signedCommit)
+}
+
+func TestCommit_MarshalBinaryMessage(t *testing.T) {
+	is := is.New(t)
+	const header = "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author Jane Doe <jane@example.com> 1700000000 +0000\n" +
+		"committer Jane Doe <jane@example.com> 1700000000 +0000\n\n"
+	for _, message := range []string{"", "no newline", "one\n", "two\n\n"} {
+		var c Commit
+		is.NoErr(parseCommit(bufio.NewReader(strings.NewReader(header+message)), &c))
+		is.Equal(c.Message, strings.TrimRight(message, "\n"))
+		raw, err := c.MarshalBinary()
+		is.NoErr(err)
+		is.Equal(string(raw), header+message) // parsed commits should encode to the same object
+	}
+	var c Commit
+	is.NoErr(parseCommit(bufio.NewReader(strings.NewReader(header+"old\n\n")), &c))
+	c.Message = "new"
+	raw, err := c.MarshalBinary()
+	is.NoErr(err)
+	is.Equal(string(raw), header+"new\n")
+}
+
+func TestCommit_MarshalBinary(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+	))
+	is.NoErr(git.RunCmd("checkout", "-q", "-b", "other", "HEAD~1"))
+	is.NoErr(appendfile(filepath.Join(git.WorkingTree(), "one"), "more\n"))
+	is.NoErr(git.Add("one"))
+	is.NoErr(git.Commit("change one"))
+	is.NoErr(git.RunCmd("checkout", "-q", "-"))
+	is.NoErr(git.RunCmd("merge", "-q", "--no-ff", "-m", "merge other", "other"))
+
+	for c, err := range git.Log("HEAD") {
+		is.NoErr(err)
+		obj, err := git.OpenObject(NewHashRef(c.Hash))
+		is.NoErr(err)
+		raw, err := c.MarshalBinary()
+		is.NoErr(err)
+		is.Equal(string(raw), string(obj.Data))
+		hash := objectHash(must(git.HashAlgo()), ObjCommit, uint64(len(raw)), bytes.NewReader(raw))
+		is.Equal(Hash(hash), c.Hash)
+	}
+	c, err := git.HeadCommit()
+	is.NoErr(err)
+	is.Equal(len(c.Parents), 2)
+}
+
+func TestGit_Log(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	date := 1700000000
+	commit := func(msg string) {
+		t.Helper()
+		date += 60
+		d := fmt.Sprintf("%d +0000", date)
+		is.NoErr(git.RunCmdWithEnv(
+			map[string]string{"GIT_AUTHOR_DATE": d, "GIT_COMMITTER_DATE": d},
+			"commit", "-q", "--allow-empty", "-m", msg,
+		))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(git.WorkingTree(), name)
+		is.NoErr(os.MkdirAll(filepath.Dir(p), 0755))
+		is.NoErr(os.WriteFile(p, []byte(content), 0644))
+		is.NoErr(git.Add(name))
+	}
+	write("a", "a\n")
+	commit("add a")
+	write("dir/b", "b\n")
+	commit("add b")
+	is.NoErr(git.RunCmd("checkout", "-q", "-b", "other"))
+	write("a", "a changed on other\n")
+	commit("change a on other")
+	is.NoErr(git.RunCmd("checkout", "-q", "-"))
+	write("dir/c", "c\n")
+	commit("add c")
+	commit("empty")
+	write("dir/b", "b changed\n")
+	commit("change b")
+	d := fmt.Sprintf("%d +0000", date+60)
+	is.NoErr(git.RunCmdWithEnv(
+		map[string]string{"GIT_AUTHOR_DATE": d, "GIT_COMMITTER_DATE": d},
+		"merge", "-q", "--no-ff", "-m", "merge", "other",
+	))
+
+	for _, paths := range [][]string{nil, {"a"}, {"dir"}, {"dir/c"}, {"dir/", "a"}, {"missing"}} {
+		var exp bytes.Buffer
+		cmd := git.Cmd(append([]string{"log", "--format=%H", "--"}, paths...)...)
+		cmd.Stdout = &exp
+		is.NoErr(run(cmd))
+		var got strings.Builder
+		for c, err := range git.Log("HEAD", paths...) {
+			is.NoErr(err)
+			fmt.Fprintf(&got, "%s\n", c.Hash)
+		}
+		is.Equal(got.String(), exp.String()) // paths should match git log
+	}
+
+	n := 0
+	for range git.Log("HEAD") {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	is.Equal(n, 2)
+	for _, err := range git.Log(Ref(strings.Repeat("ab", HashSize))) {
+		is.True(os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,794 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/harrybrwn/dots/git/gitconfig"
+)
+
+// Memory is a [Backend] that keeps objects, refs, the index and the config in
+// memory. Files are still staged from and checked out to the working tree on
+// disk. Remotes are other Memory repositories and pulls only fast-forward,
+// diverged branches are reported as merge conflicts. It is meant for tests
+// and is not safe for concurrent use.
+type Memory struct {
+	workTree string
+	exists   bool
+	args     []string
+	branch   string
+	objects  map[string]*Object
+	refs     map[string]Hash
+	index    map[string]TreeEntry
+	config   []ConfigEntry
+	filters  filters
+
+	remote     *Memory
+	remoteName string
+	upstream   bool
+}
+
+// NewMemory creates an in-memory repository for a working tree. Like [New],
+// the repository does not exist until [Memory.InitBare] is called.
+func NewMemory(workTree string) *Memory {
+	return &Memory{
+		workTree: workTree,
+		branch:   "main",
+		objects:  make(map[string]*Object),
+		refs:     make(map[string]Hash),
+		index:    make(map[string]TreeEntry),
+	}
+}
+
+// SetRemote sets the remote that is pulled from and pushed to. Like a clone,
+// the current branch tracks the remote's branch if it already exists.
+// Otherwise it is tracked after pushing with '--set-upstream'.
+func (m *Memory) SetRemote(name string, remote *Memory) {
+	m.remoteName = name
+	m.remote = remote
+	_, m.upstream = remote.refs[m.headRef()]
+}
+
+func (m *Memory) WorkingTree() string { return m.workTree }
+
+func (m *Memory) SetWorkingTree(path string) { m.workTree = path }
+
+func (m *Memory) Exists() bool { return m.exists }
+
+func (m *Memory) InitBare() error {
+	m.exists = true
+	return nil
+}
+
+func (m *Memory) AppendPersistentArgs(args ...string) { m.args = append(m.args, args...) }
+
+func (m *Memory) Add(paths ...string) error {
+	if len(paths) == 0 {
+		return errors.New("no paths to add")
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		name, err := m.indexPath(p)
+		if err != nil {
+			return err
+		}
+		full := filepath.Join(m.workTree, filepath.FromSlash(name))
+		info, err := os.Lstat(full)
+		if os.IsNotExist(err) {
+			// Adding a deleted file stages the deletion.
+			if m.unstage(name) == 0 {
+				return fmt.Errorf("pathspec %q did not match any files", p)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err = m.stage(name, full, info); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.WalkDir(full, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(m.workTree, file)
+			if err != nil {
+				return err
+			}
+			return m.stage(filepath.ToSlash(rel), file, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) AddUpdate(paths ...string) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	prefixes := make([]string, len(paths))
+	for i, p := range paths {
+		name, err := m.indexPath(p)
+		if err != nil {
+			return err
+		}
+		prefixes[i] = name
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.index)) {
+		if len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(p string) bool { return pathHasPrefix(name, p) }) {
+			continue
+		}
+		full := filepath.Join(m.workTree, filepath.FromSlash(name))
+		info, err := os.Lstat(full)
+		if os.IsNotExist(err) {
+			delete(m.index, name)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err = m.stage(name, full, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Remove(files ...string) error {
+	if len(files) == 0 {
+		return errors.New("no files to remove")
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	for _, f := range files {
+		name, err := m.indexPath(f)
+		if err != nil {
+			return err
+		}
+		if m.unstage(name) == 0 {
+			return fmt.Errorf("pathspec %q did not match any files", f)
+		}
+	}
+	return nil
+}
+
+// Commit commits the index using the author from the 'user.name' and
+// 'user.email' config values.
+func (m *Memory) Commit(message string) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	tree, err := writeTree(m.index, m.writeObject)
+	if err != nil {
+		return err
+	}
+	conf, err := m.Config()
+	if err != nil {
+		return err
+	}
+	var author Identity
+	author.Name, _ = conf.Get("user.name")
+	author.Email, _ = conf.Get("user.email")
+	author.When = time.Now()
+	c := Commit{
+		Tree:         tree,
+		Author:       author.String() + " ",
+		AuthorTime:   author.When,
+		Commiter:     author.String() + " ",
+		CommiterTime: author.When,
+		Message:      strings.TrimRight(message, "\n"),
+	}
+	head, err := m.HeadCommit()
+	switch {
+	case err == nil:
+		if head.Tree.Equal(tree) {
+			return ErrNothingToCommit
+		}
+		c.Parents = []Hash{head.Hash}
+	case os.IsNotExist(err):
+		if len(m.index) == 0 {
+			return ErrNothingToCommit
+		}
+	default:
+		return err
+	}
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	hash, err := m.writeObject(ObjCommit, data)
+	if err != nil {
+		return err
+	}
+	m.refs[m.headRef()] = hash
+	return nil
+}
+
+func (m *Memory) LsFiles() ([]string, error) {
+	files := make([]string, 0)
+	for f, err := range m.WalkTree("HEAD") {
+		if err != nil {
+			return nil, err
+		}
+		if !f.IsDir() {
+			files = append(files, f.Path)
+		}
+	}
+	return files, nil
+}
+
+func (m *Memory) ModifiedFiles() ([]string, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, name := range slices.Sorted(maps.Keys(m.index)) {
+		e, ok, err := m.worktreeEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !e.equal(m.index[name]) {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// Modifications follows the same rules as [Git.Modifications].
+func (m *Memory) Modifications() ([]*ModifiedFile, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	head := make(map[string]TreeEntry)
+	if _, err := m.resolve("HEAD"); err == nil {
+		for f, err := range m.WalkTree("HEAD") {
+			if err != nil {
+				return nil, err
+			}
+			if !f.IsDir() {
+				head[f.Path] = TreeEntry{Mode: f.Mode, Hash: f.Hash}
+			}
+		}
+	}
+	zeroHash := SHA1.ZeroHash().String()
+	names := slices.Collect(maps.Keys(head))
+	for name := range m.index {
+		if _, ok := head[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	mods := make([]*ModifiedFile, 0)
+	for _, name := range names {
+		src, inHead := head[name]
+		staged, inIndex := m.index[name]
+		mod := ModifiedFile{Name: name}
+		if inHead {
+			mod.Src = ObjModification{Mode: int(src.Mode), Hash: src.Hash.String()}
+		} else {
+			mod.Src = ObjModification{Hash: zeroHash}
+		}
+		if !inIndex {
+			mod.Type = ModDelete
+			mod.Dst = ObjModification{Hash: zeroHash}
+			mods = append(mods, &mod)
+			continue
+		}
+		wt, ok, err := m.worktreeEntry(name)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			mod.Type = ModDelete
+			mod.Dst = ObjModification{Hash: zeroHash}
+			mods = append(mods, &mod)
+			continue
+		case wt.equal(staged):
+			mod.Dst = ObjModification{Mode: int(staged.Mode), Hash: staged.Hash.String()}
+		default:
+			mod.Dst = ObjModification{Mode: int(wt.Mode), Hash: zeroHash}
+		}
+		switch {
+		case !inHead:
+			mod.Type = ModAddition
+		case src.equal(wt):
+			continue
+		default:
+			mod.Type = ModChanged
+		}
+		mods = append(mods, &mod)
+	}
+	return mods, nil
+}
+
+func (m *Memory) WalkTree(ref Ref) iter.Seq2[*TreeFile, error] {
+	return func(yield func(*TreeFile, error) bool) {
+		tree, err := m.peelTree(ref)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		pf, err := treeFilters(m.filters, m, SHA1, tree, "")
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		walkTree(m, SHA1, pf, tree, "", yield)
+	}
+}
+
+// Head returns the full name of the current branch.
+func (m *Memory) Head() (Ref, error) {
+	if err := m.check(); err != nil {
+		return "", err
+	}
+	return Ref(m.headRef()), nil
+}
+
+func (m *Memory) CurrentBranch() (string, error) {
+	if err := m.check(); err != nil {
+		return "", err
+	}
+	return m.branch, nil
+}
+
+func (m *Memory) CreateRemoteRef(remoteName, branchName string, ref Ref) error {
+	hash, err := m.resolve(ref)
+	if err != nil {
+		return err
+	}
+	m.refs[path.Join("refs/remotes", remoteName, branchName)] = hash
+	return nil
+}
+
+// HeadCommit opens the commit at the tip of the current branch.
+func (m *Memory) HeadCommit() (*Commit, error) {
+	return openCommit(m, "HEAD")
+}
+
+// SetFilter sets the filter run for files with the "filter=<name>" attribute
+// like [Git.SetFilter].
+func (m *Memory) SetFilter(name string, f Filter) { m.filters.set(name, f) }
+
+// Smudge converts a blob to the contents it has in the working tree like
+// [Git.Smudge].
+func (m *Memory) Smudge(path string, data []byte) ([]byte, error) {
+	pf, err := m.worktreeFilters()
+	if err != nil {
+		return nil, err
+	}
+	return pf.smudge(path, data)
+}
+
+func (m *Memory) worktreeFilters() (*pathFilters, error) {
+	return newPathFilters(m.filters, func() (*Attributes, error) {
+		return readAttributesFiles(filepath.Join(m.workTree, AttributesFile))
+	})
+}
+
+// Blame finds the commit that last changed each line of a file like
+// [Git.Blame].
+func (m *Memory) Blame(ref Ref, path string) ([]BlameLine, error) {
+	c, err := openCommit(m, ref)
+	if err != nil {
+		return nil, err
+	}
+	return blame(m, SHA1, c, path)
+}
+
+// OpenObject opens an object by its hash or by the name of a branch, tag or
+// remote branch.
+func (m *Memory) OpenObject(ref Ref) (*Object, error) {
+	hash, err := m.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := m.objects[hash.String()]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", hash, os.ErrNotExist)
+	}
+	o := *obj
+	return &o, nil
+}
+
+func (m *Memory) HasRemote() bool { return m.remote != nil }
+
+// Pull fast-forwards the current branch to the upstream branch and checks out
+// the changed files.
+func (m *Memory) Pull(ctx context.Context, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	argv := append([]string{"pull"}, args...)
+	if m.remote == nil || !m.upstream {
+		return exitError(argv, 1, "There is no tracking information for the current branch.\n")
+	}
+	theirs, ok := m.remote.refs[m.headRef()]
+	if !ok {
+		return exitError(argv, 1, fmt.Sprintf("fatal: couldn't find remote ref %s\n", m.headRef()))
+	}
+	m.fetch(m.remote)
+	m.refs[path.Join("refs/remotes", m.remoteName, m.branch)] = theirs
+	ours, ok := m.refs[m.headRef()]
+	switch {
+	case ok && m.isAncestor(theirs, ours):
+		return nil // already up to date
+	case ok && !m.isAncestor(ours, theirs):
+		return exitError(argv, 1, "Automatic merge failed; fix conflicts and then commit the result.\n")
+	}
+	return m.checkout(ours, theirs)
+}
+
+// Push updates the remote's branch if it is an ancestor of the current
+// branch. The arguments are the same as 'git push' but only '--set-upstream'
+// and the remote name are used.
+func (m *Memory) Push(ctx context.Context, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	argv := append([]string{"push"}, args...)
+	var setUpstream, named bool
+	for _, a := range args {
+		switch {
+		case a == "-u" || a == "--set-upstream":
+			setUpstream = true
+		case strings.HasPrefix(a, "-") || named:
+		default:
+			if m.remote == nil || a != m.remoteName {
+				return exitError(argv, 128, fmt.Sprintf("fatal: '%s' does not appear to be a git repository\n", a))
+			}
+			named = true
+		}
+	}
+	if m.remote == nil {
+		return exitError(argv, 128, "fatal: No configured push destination.\n")
+	}
+	ours, ok := m.refs[m.headRef()]
+	if !ok {
+		return exitError(argv, 1, fmt.Sprintf("error: src refspec %s does not match any\n", m.branch))
+	}
+	if theirs, ok := m.remote.refs[m.headRef()]; ok && !m.isAncestor(theirs, ours) {
+		return exitError(argv, 1, fmt.Sprintf(" ! [rejected]        %[1]s -> %[1]s (fetch first)\n", m.branch))
+	}
+	m.remote.exists = true
+	m.remote.fetch(m)
+	m.remote.refs[m.headRef()] = ours
+	m.refs[path.Join("refs/remotes", m.remoteName, m.branch)] = ours
+	if setUpstream {
+		m.upstream = true
+	}
+	return nil
+}
+
+func (m *Memory) Config() (*Config, error) {
+	c := Config{entries: slices.Clone(m.config)}
+	if err := readCommandConfig(&c, m.args); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (m *Memory) ConfigLocalSet(key, value string) error {
+	canonical, err := gitconfig.CanonicalKey(key)
+	if err != nil {
+		return fmt.Errorf("invalid config key %q: %w", key, err)
+	}
+	m.config = slices.DeleteFunc(m.config, func(e ConfigEntry) bool { return e.Key == canonical })
+	m.config = append(m.config, ConfigEntry{Key: canonical, Value: value, Scope: ScopeLocal})
+	return nil
+}
+
+func (m *Memory) check() error {
+	if !m.exists {
+		return fmt.Errorf("%s: %w", m.workTree, ErrNotRepository)
+	}
+	return nil
+}
+
+func (m *Memory) headRef() string { return "refs/heads/" + m.branch }
+
+// resolve finds the hash of a ref using the same lookup order as git.
+func (m *Memory) resolve(ref Ref) (Hash, error) {
+	name := string(ref)
+	if name == "HEAD" {
+		name = m.headRef()
+	}
+	for _, full := range []string{name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if hash, ok := m.refs[full]; ok {
+			return hash, nil
+		}
+	}
+	if ref.IsHash() {
+		if _, ok := m.objects[name]; ok {
+			return ParseHash(name)
+		}
+	}
+	return nil, &os.PathError{Op: "resolve", Path: string(ref), Err: os.ErrNotExist}
+}
+
+func (m *Memory) peelTree(ref Ref) (Hash, error) {
+	obj, err := m.OpenObject(ref)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch obj.Type {
+		case ObjTree:
+			return ParseHash(obj.Hash)
+		case ObjCommit:
+			var c Commit
+			if err = parseCommit(bytes.NewReader(obj.Data), &c); err != nil {
+				return nil, err
+			}
+			obj, err = m.OpenObject(NewHashRef(c.Tree))
+		case ObjTag:
+			var t Tag
+			if err = parseTag(obj.Data, &t); err != nil {
+				return nil, err
+			}
+			obj, err = m.OpenObject(NewHashRef(t.Object))
+		default:
+			return nil, fmt.Errorf("%q does not point to a tree", ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (m *Memory) writeObject(typ ObjectType, data []byte) (Hash, error) {
+	hash := Hash(objectHash(SHA1, typ, uint64(len(data)), bytes.NewReader(data)))
+	m.objects[hash.String()] = &Object{
+		Type: typ,
+		Size: uint64(len(data)),
+		Data: data,
+		Hash: hash.String(),
+	}
+	return hash, nil
+}
+
+// indexPath converts a path to its name in the index. Relative paths are
+// relative to the current directory like they are for git.
+func (m *Memory) indexPath(p string) (string, error) {
+	full, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(m.workTree, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside repository at %q", p, m.workTree)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// stage adds a file from the working tree to the index.
+func (m *Memory) stage(name, full string, info fs.FileInfo) error {
+	e, err := m.readEntry(name, full, info)
+	if err != nil {
+		return err
+	}
+	m.index[name] = e
+	return nil
+}
+
+// unstage removes a path or the files in a directory from the index and
+// returns the number of files removed.
+func (m *Memory) unstage(name string) int {
+	n := 0
+	for f := range m.index {
+		if pathHasPrefix(f, name) {
+			delete(m.index, f)
+			n++
+		}
+	}
+	return n
+}
+
+// worktreeEntry hashes a file in the working tree. It returns false if the
+// file does not exist.
+func (m *Memory) worktreeEntry(name string) (TreeEntry, bool, error) {
+	full := filepath.Join(m.workTree, filepath.FromSlash(name))
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return TreeEntry{}, false, nil
+	} else if err != nil {
+		return TreeEntry{}, false, err
+	}
+	if info.IsDir() {
+		return TreeEntry{}, false, nil
+	}
+	e, err := m.readEntry(name, full, info)
+	return e, err == nil, err
+}
+
+// readEntry writes a blob for a file, the target of a symlink is stored as its
+// contents. Regular files are cleaned by their filter.
+func (m *Memory) readEntry(name, full string, info fs.FileInfo) (TreeEntry, error) {
+	var (
+		e    TreeEntry
+		data []byte
+		err  error
+	)
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		var target string
+		target, err = os.Readlink(full)
+		data, e.Mode = []byte(target), modeSymlink
+	case info.Mode().Perm()&0111 != 0:
+		data, err = os.ReadFile(full)
+		e.Mode = modeRegular | 0755
+	default:
+		data, err = os.ReadFile(full)
+		e.Mode = modeRegular | 0644
+	}
+	if err != nil {
+		return e, err
+	}
+	if e.Mode != modeSymlink {
+		pf, err := m.worktreeFilters()
+		if err != nil {
+			return e, err
+		}
+		if data, err = pf.clean(name, data); err != nil {
+			return e, err
+		}
+	}
+	e.Hash, err = m.writeObject(ObjBlob, data)
+	return e, err
+}
+
+// fetch copies all the objects of another repository.
+func (m *Memory) fetch(other *Memory) {
+	for hash, obj := range other.objects {
+		if _, ok := m.objects[hash]; !ok {
+			m.objects[hash] = obj
+		}
+	}
+}
+
+// isAncestor returns true if commit a is reachable from commit b.
+func (m *Memory) isAncestor(a, b Hash) bool {
+	queue := []Hash{b}
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if h.Equal(a) {
+			return true
+		}
+		if seen[h.String()] {
+			continue
+		}
+		seen[h.String()] = true
+		obj, ok := m.objects[h.String()]
+		if !ok {
+			continue
+		}
+		var c Commit
+		if parseCommit(bytes.NewReader(obj.Data), &c) == nil {
+			queue = append(queue, c.Parents...)
+		}
+	}
+	return false
+}
+
+// checkout moves the current branch from one commit to another, updating the
+// index and the files in the working tree that changed. The old commit may be
+// nil for a branch with no commits.
+func (m *Memory) checkout(from, to Hash) error {
+	files := func(commit Hash) (map[string]*TreeFile, error) {
+		res := make(map[string]*TreeFile)
+		if commit == nil {
+			return res, nil
+		}
+		for f, err := range m.WalkTree(NewHashRef(commit)) {
+			if err != nil {
+				return nil, err
+			}
+			if !f.IsDir() {
+				res[f.Path] = f
+			}
+		}
+		return res, nil
+	}
+	old, err := files(from)
+	if err != nil {
+		return err
+	}
+	next, err := files(to)
+	if err != nil {
+		return err
+	}
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			delete(m.index, name)
+			err = os.Remove(filepath.Join(m.workTree, filepath.FromSlash(name)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for name, f := range next {
+		e := TreeEntry{Mode: f.Mode, Hash: f.Hash}
+		if prev, ok := old[name]; ok && e.equal(TreeEntry{Mode: prev.Mode, Hash: prev.Hash}) {
+			continue
+		}
+		full := filepath.Join(m.workTree, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return err
+		}
+		_ = os.Remove(full)
+		if f.IsSymlink() {
+			err = os.Symlink(f.Target, full)
+		} else {
+			var (
+				r    io.ReadCloser
+				data []byte
+			)
+			if r, err = f.Open(); err != nil {
+				return err
+			}
+			data, err = io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(full, data, f.FileMode())
+		}
+		if err != nil {
+			return err
+		}
+		m.index[name] = e
+	}
+	m.refs[m.headRef()] = to
+	return nil
+}
+
+// exitError creates the error git would have returned from a failed command.
+func exitError(args []string, code int, stderr string) error {
+	return &ExitError{
+		Args:     args,
+		ExitCode: code,
+		Stderr:   stderr,
+		Err:      fmt.Errorf("exit status %d", code),
+	}
+}
+
+// pathHasPrefix returns true if name is dir or a file inside of it.
+func pathHasPrefix(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
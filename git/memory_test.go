@@ -0,0 +1,118 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestMemory(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(git.InitBare())
+	tree := git.WorkingTree()
+	mem := NewMemory(tree)
+	mem.AppendPersistentArgs("-c", "user.name=DotsTests", "-c", "user.email=dots@example.com")
+	is.True(errors.Is(mem.Add(filepath.Join(tree, "x")), ErrNotRepository))
+	is.NoErr(mem.InitBare())
+	is.True(mem.Commit("empty") == ErrNothingToCommit)
+
+	is.NoErr(os.MkdirAll(filepath.Join(tree, ".config/nvim"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".bashrc"), []byte("export A=1\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".config/nvim/init.lua"), []byte("require('a')\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".config/run.sh"), []byte("#!/bin/sh\n"), 0755))
+	is.NoErr(os.Symlink(".bashrc", filepath.Join(tree, ".profile")))
+
+	// Both backends should build the same trees from the same working tree.
+	same := func() {
+		t.Helper()
+		is.Equal(must(mem.HeadCommit()).Tree, must(git.HeadCommit()).Tree)
+		is.Equal(must(mem.LsFiles()), must(git.LsFiles()))
+		is.Equal(must(mem.ModifiedFiles()), must(git.ModifiedFiles()))
+		is.Equal(must(mem.Modifications()), must(git.Modifications()))
+	}
+	for _, b := range []Backend{git, mem} {
+		is.NoErr(b.Add(filepath.Join(tree, ".bashrc"), filepath.Join(tree, ".config"), filepath.Join(tree, ".profile")))
+		is.NoErr(b.Commit("first"))
+	}
+	same()
+	c := must(mem.HeadCommit())
+	is.Equal(c.Message, "first")
+	is.Equal(c.Author, "DotsTests <dots@example.com> ")
+
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".bashrc"), []byte("export A=2\n"), 0644))
+	is.NoErr(os.Remove(filepath.Join(tree, ".config/run.sh")))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".vimrc"), []byte("set number\n"), 0644))
+	for _, b := range []Backend{git, mem} {
+		is.NoErr(b.Add(filepath.Join(tree, ".vimrc")))
+	}
+	is.Equal(len(must(mem.Modifications())), 3)
+	same()
+	for _, b := range []Backend{git, mem} {
+		is.NoErr(b.AddUpdate())
+		is.NoErr(b.Remove(filepath.Join(tree, ".vimrc")))
+		is.True(b.Remove(filepath.Join(tree, ".vimrc")) != nil) // no longer tracked
+		is.NoErr(b.Commit("second"))
+	}
+	same()
+	is.Equal(must(mem.LsFiles()), []string{".bashrc", ".config/nvim/init.lua", ".profile"})
+	is.True(mem.Add("/outside") != nil)
+
+	for f, err := range mem.WalkTree("HEAD") {
+		is.NoErr(err)
+		if f.IsSymlink() {
+			is.Equal(f.Target, ".bashrc")
+		}
+	}
+	is.NoErr(mem.ConfigLocalSet("status.showUntrackedFiles", "no"))
+	conf := must(mem.Config())
+	is.Equal(conf.Origin("status.showuntrackedfiles").Scope, ScopeLocal)
+	is.Equal(conf.Origin("user.name").Scope, ScopeCommand)
+}
+
+func TestMemory_Remote(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	remote := NewMemory("")
+	a, b := NewMemory(t.TempDir()), NewMemory(t.TempDir())
+	for _, m := range []*Memory{a, b} {
+		is.NoErr(m.InitBare())
+		m.AppendPersistentArgs("-c", "user.name=DotsTests", "-c", "user.email=dots@example.com")
+	}
+	commit := func(m *Memory, name, content string) {
+		t.Helper()
+		is.NoErr(os.WriteFile(filepath.Join(m.WorkingTree(), name), []byte(content), 0644))
+		is.NoErr(m.Add(filepath.Join(m.WorkingTree(), name)))
+		is.NoErr(m.Commit("update " + name))
+	}
+
+	a.SetRemote("origin", remote)
+	is.True(errors.Is(a.Pull(ctx), ErrNoUpstream))
+	commit(a, ".bashrc", "export A=1\n")
+	is.True(a.Push(ctx, "upstream", "main") != nil)
+	is.NoErr(a.Push(ctx, "--set-upstream", "origin", "main"))
+	is.NoErr(a.Pull(ctx))
+	is.Equal(must(remote.LsFiles()), []string{".bashrc"})
+
+	b.SetRemote("origin", remote) // the branch exists so it is tracked
+	is.NoErr(b.Pull(ctx))
+	data, err := os.ReadFile(filepath.Join(b.WorkingTree(), ".bashrc"))
+	is.NoErr(err)
+	is.Equal(string(data), "export A=1\n")
+	is.Equal(len(must(b.Modifications())), 0)
+
+	commit(b, ".vimrc", "set number\n")
+	is.NoErr(b.Push(ctx, "origin", "main"))
+	commit(a, ".bashrc", "export A=2\n")
+	err = a.Push(ctx, "origin", "main")
+	is.True(err != nil) // remote has commits a does not have
+	is.True(errors.Is(a.Pull(ctx), ErrMergeConflict))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	is.True(errors.Is(b.Pull(cancelled), context.Canceled))
+}
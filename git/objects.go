@@ -3,7 +3,6 @@ package git
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -90,14 +89,29 @@ func objectType(s string) ObjectType {
 	}
 }
 
+// NewObjectFromFile hashes a file as a blob using SHA-1. Use
+// [Git.HashFile] for repositories that may use a different hash algorithm.
 func NewObjectFromFile(file fs.File) (*FileObject, error) {
+	return newObjectFromFile(SHA1, file)
+}
+
+// HashFile hashes a file as a blob using the hash algorithm of the repository.
+func (g *Git) HashFile(file fs.File) (*FileObject, error) {
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	return newObjectFromFile(algo, file)
+}
+
+func newObjectFromFile(algo HashAlgo, file fs.File) (*FileObject, error) {
 	stat, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 	size := stat.Size()
 	t := ObjBlob
-	hash := objectHash(t, uint64(size), file)
+	hash := objectHash(algo, t, uint64(size), file)
 	return &FileObject{
 		Name: stat.Name(),
 		Type: t,
@@ -107,50 +121,164 @@ func NewObjectFromFile(file fs.File) (*FileObject, error) {
 }
 
 type Commit struct {
-	Tree         [HashSize]byte
-	Parent       [HashSize]byte
+	Hash         Hash
+	Tree         Hash
+	Parents      []Hash
 	Author       string
 	AuthorTime   time.Time
 	Commiter     string
 	CommiterTime time.Time
+	// ExtraHeaders are the headers that come after the committer such as
+	// "encoding", "mergetag" and "gpgsig" in the order they were found.
+	// Multi-line values are stored without the leading space of each
+	// continuation line.
+	ExtraHeaders []CommitHeader
 	Message      string
+
+	// rawMessage is the message exactly as it was parsed.
+	rawMessage []byte
+}
+
+// CommitHeader is a single header of a commit object.
+type CommitHeader struct {
+	Key, Value string
 }
 
-func (c *Commit) IsRoot() bool {
-	for i := 0; i < HashSize; i++ {
-		if c.Parent[i] != 0 {
-			return false
+func (c *Commit) IsRoot() bool { return len(c.Parents) == 0 }
+
+// IsMerge returns true if the commit has more than one parent.
+func (c *Commit) IsMerge() bool { return len(c.Parents) > 1 }
+
+// Signature returns the GPG signature of the commit or an empty string if the
+// commit is not signed.
+func (c *Commit) Signature() string {
+	for _, h := range c.ExtraHeaders {
+		if h.Key == "gpgsig" || h.Key == "gpgsig-sha256" {
+			return h.Value
 		}
 	}
-	return true
+	return ""
 }
 
+// MarshalBinary encodes the commit into the contents of a commit object. The
+// message is terminated by a single newline unless the commit was parsed and
+// its message was not changed, then the message is written as it was parsed
+// so that the commit encodes to the same object.
+func (c *Commit) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	b.WriteString("author ")
+	b.Write(appendCommitAuthor(nil, c.Author, c.AuthorTime))
+	b.WriteString("\ncommitter ")
+	b.Write(appendCommitAuthor(nil, c.Commiter, c.CommiterTime))
+	b.WriteByte('\n')
+	for _, h := range c.ExtraHeaders {
+		b.WriteString(h.Key)
+		b.WriteByte(' ')
+		b.WriteString(strings.ReplaceAll(h.Value, "\n", "\n "))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	writeMessage(&b, c.Message, c.rawMessage)
+	return b.Bytes(), nil
+}
+
+// Tag is an annotated tag object.
+type Tag struct {
+	Hash Hash
+	// Object is the hash of the tagged object and Type is its type.
+	Object Hash
+	Type   ObjectType
+	Name   string
+	// Tagger has the same format as [Commit.Author]. It is empty for some
+	// very old tags.
+	Tagger       string
+	TaggerTime   time.Time
+	ExtraHeaders []CommitHeader
+	// Message is the tag message including any signature.
+	Message string
+
+	// rawMessage is the message exactly as it was parsed.
+	rawMessage []byte
+}
+
+// MarshalBinary encodes the tag into the contents of a tag object.
+func (t *Tag) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "object %s\ntype %s\ntag %s\n", t.Object, t.Type, t.Name)
+	if len(t.Tagger) > 0 {
+		b.WriteString("tagger ")
+		b.Write(appendCommitAuthor(nil, t.Tagger, t.TaggerTime))
+		b.WriteByte('\n')
+	}
+	for _, h := range t.ExtraHeaders {
+		b.WriteString(h.Key)
+		b.WriteByte(' ')
+		b.WriteString(strings.ReplaceAll(h.Value, "\n", "\n "))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	writeMessage(&b, t.Message, t.rawMessage)
+	return b.Bytes(), nil
+}
+
+// writeMessage writes the message of a commit or tag. Parsing trims the
+// trailing newlines of the message so the raw message is used if the message
+// was not changed since.
+func writeMessage(b *bytes.Buffer, message string, raw []byte) {
+	if raw != nil && string(trimMessage(raw)) == message {
+		b.Write(raw)
+		return
+	}
+	if len(message) > 0 {
+		b.WriteString(message)
+		b.WriteByte('\n')
+	}
+}
+
+func trimMessage(raw []byte) []byte { return bytes.TrimRight(raw, "\n") }
+
 const TreeMode = fs.FileMode(040000)
 
 type TreeEntry struct {
 	Mode fs.FileMode
 	Name string
-	Hash [HashSize]byte
+	Hash Hash
+}
+
+func (e TreeEntry) equal(other TreeEntry) bool {
+	return e.Mode == other.Mode && e.Name == other.Name && e.Hash.Equal(other.Hash)
 }
 
+// LogFlag marks special reflog entries.
 type LogFlag uint
 
 const (
-	LogFlagInitial LogFlag = iota
+	LogFlagNone LogFlag = iota
+	// LogFlagInitial is set for the first commit of a branch.
+	LogFlagInitial
+	// LogFlagAmend is set for commits made with 'git commit --amend'.
 	LogFlagAmend
 )
 
+// Log is a single reflog entry.
 type Log struct {
-	Hash      [HashSize]byte
-	Prev      [HashSize]byte
+	Hash      Hash
+	Prev      Hash
 	Author    string
 	TimeStamp time.Time
 	Message   string
 	Flag      LogFlag
 }
 
-func objectHash(typ ObjectType, size uint64, r io.Reader) []byte {
-	h := sha1.New()
+// IsCreation returns true if the entry created the ref.
+func (l *Log) IsCreation() bool { return l.Prev.IsZero() }
+
+func objectHash(algo HashAlgo, typ ObjectType, size uint64, r io.Reader) []byte {
+	h := algo.New()
 	h.Write([]byte(typ.String()))
 	h.Write([]byte{' '})
 	h.Write([]byte(strconv.FormatUint(size, 10)))
@@ -198,7 +326,7 @@ func parseCommit(r io.Reader, dst *Commit) (err error) {
 	if buf, ok = r.(*bufio.Reader); !ok {
 		buf = bufio.NewReader(r)
 	}
-loop:
+	var last *CommitHeader
 	for {
 		line, err := buf.ReadBytes('\n')
 		if err != nil {
@@ -207,25 +335,37 @@ loop:
 		if line[len(line)-1] == '\n' {
 			line = line[:len(line)-1]
 		}
-		parts := bytes.SplitN(line, []byte{' '}, 2)
-		switch len(parts) {
-		case 0:
-			continue
-		case 1:
-			if len(parts[0]) == 0 {
-				// this is the commit message
-				break loop
+		if len(line) == 0 {
+			// this is the commit message
+			break
+		}
+		if line[0] == ' ' {
+			// continuation of a multi-line header
+			if last == nil {
+				return errors.New("invalid commit header continuation")
 			}
+			last.Value += "\n" + string(line[1:])
+			continue
 		}
-		switch string(parts[0]) {
+		last = nil
+		key, value, _ := bytes.Cut(line, []byte{' '})
+		switch string(key) {
 		case "tree":
-			_, err = hex.Decode(dst.Tree[:], parts[1])
+			dst.Tree, err = ParseHash(string(value))
 		case "parent":
-			_, err = hex.Decode(dst.Parent[:], parts[1])
+			var p Hash
+			p, err = ParseHash(string(value))
+			dst.Parents = append(dst.Parents, p)
 		case "author":
-			dst.Author, dst.AuthorTime, err = parseCommitAuthor(parts[1])
+			dst.Author, dst.AuthorTime, err = parseCommitAuthor(value)
 		case "committer":
-			dst.Commiter, dst.CommiterTime, err = parseCommitAuthor(parts[1])
+			dst.Commiter, dst.CommiterTime, err = parseCommitAuthor(value)
+		default:
+			dst.ExtraHeaders = append(dst.ExtraHeaders, CommitHeader{
+				Key:   string(key),
+				Value: string(value),
+			})
+			last = &dst.ExtraHeaders[len(dst.ExtraHeaders)-1]
 		}
 		if err != nil {
 			return err
@@ -235,39 +375,96 @@ loop:
 	if err != nil {
 		return err
 	}
-	dst.Message = string(bytes.TrimRight(all, "\n"))
+	dst.Message = string(trimMessage(all))
+	dst.rawMessage = all
+	return nil
+}
+
+// parseTag parses the contents of a tag object. See 'parse_tag_buffer' in
+// "tag.c".
+func parseTag(raw []byte, dst *Tag) (err error) {
+	var last *CommitHeader
+	for {
+		line, rest, ok := bytes.Cut(raw, []byte{'\n'})
+		if !ok {
+			return errors.New("invalid tag object: missing message")
+		}
+		raw = rest
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' {
+			if last == nil {
+				return errors.New("invalid tag header continuation")
+			}
+			last.Value += "\n" + string(line[1:])
+			continue
+		}
+		last = nil
+		key, value, _ := bytes.Cut(line, []byte{' '})
+		switch string(key) {
+		case "object":
+			dst.Object, err = ParseHash(string(value))
+		case "type":
+			if dst.Type = objectType(string(value)); dst.Type == ObjUnknown {
+				err = fmt.Errorf("invalid tag object type %q", value)
+			}
+		case "tag":
+			dst.Name = string(value)
+		case "tagger":
+			dst.Tagger, dst.TaggerTime, err = parseCommitAuthor(value)
+		default:
+			dst.ExtraHeaders = append(dst.ExtraHeaders, CommitHeader{
+				Key:   string(key),
+				Value: string(value),
+			})
+			last = &dst.ExtraHeaders[len(dst.ExtraHeaders)-1]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if dst.Object == nil || len(dst.Name) == 0 {
+		return errors.New("invalid tag object: missing object or name")
+	}
+	dst.Message = string(trimMessage(raw))
+	dst.rawMessage = bytes.Clone(raw)
 	return nil
 }
 
-func parseTree(raw []byte) ([]TreeEntry, error) {
+// parseTree parses the contents of a tree object. Each entry has the format
+//
+//	<mode> SP <name> NUL <hash>
+func parseTree(raw []byte, hashSize int) ([]TreeEntry, error) {
 	entries := make([]TreeEntry, 0)
-	start := 0
-	l := len(raw)
-	for i := bytes.IndexByte(raw, 0); i > 0 && i < l && start < l; {
-		parts := bytes.SplitN(raw[start:i], []byte{' '}, 2)
-		if len(parts) != 2 {
+	for len(raw) > 0 {
+		i := bytes.IndexByte(raw, 0)
+		if i < 0 || len(raw) < i+1+hashSize {
+			return nil, errors.New("truncated tree entry")
+		}
+		mode, name, ok := bytes.Cut(raw[:i], []byte{' '})
+		if !ok {
 			return nil, errors.New("invalid tree entry header")
 		}
-		i++
-		mode, err := strconv.ParseUint(string(parts[0]), 8, 32)
+		m, err := strconv.ParseUint(string(mode), 8, 32)
 		if err != nil {
 			return nil, err
 		}
-		entry := TreeEntry{
-			Mode: fs.FileMode(mode),
-			Name: string(parts[1]),
-		}
-		if i >= l {
-			break
-		}
-		start = i + HashSize
-		copy(entry.Hash[:], raw[i:i+HashSize])
-		i = start + bytes.IndexByte(raw[start:], 0)
-		entries = append(entries, entry)
+		entries = append(entries, TreeEntry{
+			Mode: fs.FileMode(m),
+			Name: string(name),
+			Hash: Hash(raw[i+1 : i+1+hashSize]),
+		})
+		raw = raw[i+1+hashSize:]
 	}
 	return entries, nil
 }
 
+// parseLogs parses a reflog file. Each line has the format
+//
+//	<old hash> SP <new hash> SP <committer> SP <timestamp> SP <tz> TAB <message> LF
+//
+// See 'show_one_reflog_ent' in "refs/files-backend.c".
 func parseLogs(r io.Reader) ([]Log, error) {
 	buf := bufio.NewReader(r)
 	logs := make([]Log, 0)
@@ -288,17 +485,30 @@ func parseLogs(r io.Reader) ([]Log, error) {
 			line = line[:len(line)-1]
 		}
 		var log Log
-		ix := bytes.IndexByte(line, '\t')
-		if ix < 0 {
-			continue
-		}
-		log.Message = string(line[ix:])
-		parts := bytes.SplitN(line[:ix], []byte{' '}, 3)
+		head, msg, _ := bytes.Cut(line, []byte{'\t'})
+		parts := bytes.SplitN(head, []byte{' '}, 3)
 		if len(parts) < 3 {
-			continue
+			return nil, fmt.Errorf("invalid reflog entry: %q", line)
+		}
+		if log.Prev, err = ParseHash(string(parts[0])); err != nil {
+			return nil, err
+		}
+		if log.Hash, err = ParseHash(string(parts[1])); err != nil {
+			return nil, err
+		}
+		log.Author, log.TimeStamp, err = parseCommitAuthor(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		log.Author = strings.TrimSuffix(log.Author, " ")
+		log.Message = string(msg)
+		action, _, _ := strings.Cut(log.Message, ": ")
+		switch action {
+		case "commit (initial)":
+			log.Flag = LogFlagInitial
+		case "commit (amend)":
+			log.Flag = LogFlagAmend
 		}
-		copy(log.Prev[:], parts[0])
-		copy(log.Hash[:], parts[1])
 		logs = append(logs, log)
 	}
 	return logs, nil
@@ -327,6 +537,14 @@ func parseCommitAuthor(line []byte) (author string, ts time.Time, err error) {
 	return string(line[:tsix]), ts.In(loc), nil
 }
 
+// appendCommitAuthor is the inverse of parseCommitAuthor.
+func appendCommitAuthor(buf []byte, author string, ts time.Time) []byte {
+	buf = append(buf, author...)
+	buf = strconv.AppendInt(buf, ts.Unix(), 10)
+	buf = append(buf, ' ')
+	return ts.AppendFormat(buf, "-0700")
+}
+
 func parseTimeOffset(b []byte) (int, error) {
 	sign := 1
 	if len(b) < 5 {
@@ -0,0 +1,542 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pack object types. See "packfile.h" and 'enum object_type' in "object.h".
+const (
+	packObjCommit   = 1
+	packObjTree     = 2
+	packObjBlob     = 3
+	packObjTag      = 4
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+const (
+	packSignature    = 0x5041434b // "PACK"
+	packIdxSignature = 0xff744f63 // "\377tOc"
+	// The maximum number of delta bases kept in memory by the pack cache.
+	packCacheEntries = 64
+	// The maximum number of bytes held by the pack cache.
+	packCacheBytes = 16 << 20
+	// Guards against corrupted packs with delta cycles.
+	maxDeltaDepth = 4096
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// packfile is a single "objects/pack/pack-*.pack" file and its v2 index.
+//
+// See "Documentation/gitformat-pack.txt" in the git source for the details of
+// the on-disk format.
+type packfile struct {
+	path      string // path of the .pack file
+	hashSize  int
+	fanout    [256]uint32
+	names     []byte // sorted object names, hashSize bytes each
+	offsets   []uint32
+	offsets64 []uint64
+	file      *os.File
+}
+
+func openPackIndex(idxPath string, hashSize int) (*packfile, error) {
+	raw, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	p := packfile{
+		path:     strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		hashSize: hashSize,
+	}
+	if err = p.unmarshalIndex(raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(idxPath), err)
+	}
+	return &p, nil
+}
+
+func (p *packfile) unmarshalIndex(raw []byte) error {
+	const headerSize = 8 + 256*4
+	if len(raw) < headerSize {
+		return errors.New("pack index too short")
+	}
+	if binary.BigEndian.Uint32(raw) != packIdxSignature {
+		return errors.New("unsupported pack index version 1")
+	}
+	if v := binary.BigEndian.Uint32(raw[4:]); v != 2 {
+		return fmt.Errorf("unsupported pack index version %d", v)
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(raw[8+i*4:])
+	}
+	n := int(p.fanout[255])
+	names := headerSize
+	crcs := names + n*p.hashSize
+	offsets := crcs + n*4
+	large := offsets + n*4
+	if len(raw) < large+2*p.hashSize {
+		return errors.New("pack index truncated")
+	}
+	p.names = raw[names:crcs]
+	p.offsets = make([]uint32, n)
+	nlarge := 0
+	for i := range p.offsets {
+		p.offsets[i] = binary.BigEndian.Uint32(raw[offsets+i*4:])
+		if p.offsets[i]&0x80000000 != 0 {
+			nlarge++
+		}
+	}
+	if len(raw) < large+nlarge*8+2*p.hashSize {
+		return errors.New("pack index truncated")
+	}
+	p.offsets64 = make([]uint64, nlarge)
+	for i := range p.offsets64 {
+		p.offsets64[i] = binary.BigEndian.Uint64(raw[large+i*8:])
+	}
+	return nil
+}
+
+func (p *packfile) count() int { return len(p.offsets) }
+
+func (p *packfile) name(i int) []byte {
+	return p.names[i*p.hashSize : (i+1)*p.hashSize]
+}
+
+func (p *packfile) offset(i int) int64 {
+	off := p.offsets[i]
+	if off&0x80000000 == 0 {
+		return int64(off)
+	}
+	return int64(p.offsets64[off&0x7fffffff])
+}
+
+// find returns the offset of an object in the pack file.
+func (p *packfile) find(hash []byte) (int64, bool) {
+	var lo uint32
+	if hash[0] > 0 {
+		lo = p.fanout[hash[0]-1]
+	}
+	hi := p.fanout[hash[0]]
+	i := sort.Search(int(hi-lo), func(i int) bool {
+		return bytes.Compare(p.name(int(lo)+i), hash) >= 0
+	}) + int(lo)
+	if i < int(hi) && bytes.Equal(p.name(i), hash) {
+		return p.offset(i), true
+	}
+	return 0, false
+}
+
+func (p *packfile) open() error {
+	if p.file != nil {
+		return nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	var hdr [12]byte
+	if _, err = f.ReadAt(hdr[:], 0); err != nil {
+		f.Close()
+		return err
+	}
+	if binary.BigEndian.Uint32(hdr[:]) != packSignature {
+		f.Close()
+		return fmt.Errorf("%s: invalid pack signature", filepath.Base(p.path))
+	}
+	if v := binary.BigEndian.Uint32(hdr[4:]); v != 2 && v != 3 {
+		f.Close()
+		return fmt.Errorf("%s: unsupported pack version %d", filepath.Base(p.path), v)
+	}
+	p.file = f
+	return nil
+}
+
+func (p *packfile) close() error {
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// packEntryHeader is the header in front of every object in a pack file.
+type packEntryHeader struct {
+	typ  int
+	size uint64
+	// data is the offset of the compressed object data.
+	data int64
+	// base is the offset of the delta base for OFS_DELTA entries.
+	base int64
+	// baseHash is the name of the delta base for REF_DELTA entries.
+	baseHash []byte
+}
+
+func (p *packfile) readHeader(offset int64) (*packEntryHeader, error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(p.file, offset, 1<<62), 64)
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	hdr := packEntryHeader{
+		typ:  int(c>>4) & 7,
+		size: uint64(c & 0x0f),
+	}
+	n := int64(1)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		hdr.size |= uint64(c&0x7f) << shift
+		n++
+	}
+	switch hdr.typ {
+	case packObjOfsDelta:
+		// See 'get_delta_base' in "packfile.c"
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		n++
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			n++
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		if rel <= 0 || rel > offset {
+			return nil, errors.New("invalid delta base offset")
+		}
+		hdr.base = offset - rel
+	case packObjRefDelta:
+		hdr.baseHash = make([]byte, p.hashSize)
+		if _, err = io.ReadFull(r, hdr.baseHash); err != nil {
+			return nil, err
+		}
+		n += int64(p.hashSize)
+	case packObjCommit, packObjTree, packObjBlob, packObjTag:
+	default:
+		return nil, fmt.Errorf("unknown pack object type %d at offset %d", hdr.typ, offset)
+	}
+	hdr.data = offset + n
+	return &hdr, nil
+}
+
+func (p *packfile) inflate(hdr *packEntryHeader) ([]byte, error) {
+	zr, err := zlib.NewReader(io.NewSectionReader(p.file, hdr.data, 1<<62))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// The size comes from the pack so it is not trusted for the allocation,
+	// the buffer only grows as the object is inflated.
+	if hdr.size >= math.MaxInt64 {
+		return nil, fmt.Errorf("corrupt pack object at offset %d: invalid size %d", hdr.data, hdr.size)
+	}
+	buf, err := io.ReadAll(io.LimitReader(zr, int64(hdr.size)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate pack object: %w", err)
+	}
+	if uint64(len(buf)) != hdr.size {
+		return nil, fmt.Errorf("corrupt pack object at offset %d: inflated %d bytes instead of %d", hdr.data, len(buf), hdr.size)
+	}
+	return buf, nil
+}
+
+func packObjectType(t int) ObjectType {
+	switch t {
+	case packObjCommit:
+		return ObjCommit
+	case packObjTree:
+		return ObjTree
+	case packObjBlob:
+		return ObjBlob
+	case packObjTag:
+		return ObjTag
+	default:
+		return ObjUnknown
+	}
+}
+
+// packStore holds all the pack files of a repository.
+type packStore struct {
+	dir   string
+	algo  HashAlgo
+	packs []*packfile
+	cache *packCache
+}
+
+func (g *Git) packStore() (*packStore, error) {
+	if g.packs != nil {
+		return g.packs, nil
+	}
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	ps := packStore{
+		dir:   filepath.Join(g.gitDir, "objects", "pack"),
+		algo:  algo,
+		cache: newPackCache(packCacheEntries, packCacheBytes),
+	}
+	if err := ps.scan(); err != nil {
+		return nil, err
+	}
+	g.packs = &ps
+	return g.packs, nil
+}
+
+// scan loads any pack index that has not been loaded yet.
+func (ps *packStore) scan() error {
+	matches, err := filepath.Glob(filepath.Join(ps.dir, "pack-*.idx"))
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]struct{}, len(ps.packs))
+	for _, p := range ps.packs {
+		loaded[p.path] = struct{}{}
+	}
+	for _, idx := range matches {
+		if _, ok := loaded[strings.TrimSuffix(idx, ".idx")+".pack"]; ok {
+			continue
+		}
+		p, err := openPackIndex(idx, ps.algo.Size())
+		if err != nil {
+			return err
+		}
+		ps.packs = append(ps.packs, p)
+	}
+	return nil
+}
+
+func (ps *packStore) close() (err error) {
+	for _, p := range ps.packs {
+		if e := p.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (ps *packStore) find(hash []byte) (*packfile, int64, bool) {
+	for _, p := range ps.packs {
+		if off, ok := p.find(hash); ok {
+			return p, off, true
+		}
+	}
+	return nil, 0, false
+}
+
+// read returns the fully resolved object with the given name. It rescans the
+// pack directory once if the object is not found in case a new pack was
+// written since the last scan.
+func (ps *packStore) read(hash []byte) (*Object, error) {
+	p, off, ok := ps.find(hash)
+	if !ok {
+		if err := ps.scan(); err != nil {
+			return nil, err
+		}
+		if p, off, ok = ps.find(hash); !ok {
+			return nil, errObjectNotFound
+		}
+	}
+	typ, data, err := ps.readAt(p, off, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		Type: typ,
+		Size: uint64(len(data)),
+		Data: data,
+		Hash: hex.EncodeToString(hash),
+	}, nil
+}
+
+func (ps *packStore) readAt(p *packfile, offset int64, depth int) (ObjectType, []byte, error) {
+	if depth > maxDeltaDepth {
+		return ObjUnknown, nil, errors.New("pack delta chain too deep")
+	}
+	if e, ok := ps.cache.get(p, offset); ok {
+		return e.typ, e.data, nil
+	}
+	if err := p.open(); err != nil {
+		return ObjUnknown, nil, err
+	}
+	hdr, err := p.readHeader(offset)
+	if err != nil {
+		return ObjUnknown, nil, err
+	}
+	var (
+		typ        ObjectType
+		base, data []byte
+	)
+	switch hdr.typ {
+	case packObjOfsDelta:
+		typ, base, err = ps.readAt(p, hdr.base, depth+1)
+	case packObjRefDelta:
+		bp, boff, ok := ps.find(hdr.baseHash)
+		if !ok {
+			return ObjUnknown, nil, fmt.Errorf(
+				"missing delta base %s", hex.EncodeToString(hdr.baseHash))
+		}
+		typ, base, err = ps.readAt(bp, boff, depth+1)
+	default:
+		typ = packObjectType(hdr.typ)
+	}
+	if err != nil {
+		return ObjUnknown, nil, err
+	}
+	data, err = p.inflate(hdr)
+	if err != nil {
+		return ObjUnknown, nil, err
+	}
+	if base != nil {
+		data, err = applyDelta(base, data)
+		if err != nil {
+			return ObjUnknown, nil, err
+		}
+	}
+	ps.cache.add(p, offset, typ, data)
+	return typ, data, nil
+}
+
+// applyDelta applies a git delta to a base object. See "patch-delta.c".
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta := deltaHeaderSize(delta)
+	if srcSize != uint64(len(base)) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	dstSize, delta := deltaHeaderSize(delta)
+	// The size is only a hint for the allocation since the delta may be
+	// corrupt, the result is checked against it once it is applied.
+	out := make([]byte, 0, min(dstSize, uint64(len(base)+len(delta))))
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			var off, size uint64
+			for i := uint(0); i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("truncated delta copy instruction")
+					}
+					off |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("truncated delta copy instruction")
+					}
+					size |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size < off || off+size > uint64(len(base)) {
+				return nil, errors.New("delta copy out of bounds")
+			}
+			out = append(out, base[off:off+size]...)
+		case cmd != 0:
+			if int(cmd) > len(delta) {
+				return nil, errors.New("truncated delta insert instruction")
+			}
+			out = append(out, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, errors.New("unexpected delta opcode 0")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
+
+func deltaHeaderSize(b []byte) (uint64, []byte) {
+	var size uint64
+	for i, shift := 0, uint(0); i < len(b); i, shift = i+1, shift+7 {
+		size |= uint64(b[i]&0x7f) << shift
+		if b[i]&0x80 == 0 {
+			return size, b[i+1:]
+		}
+	}
+	return size, nil
+}
+
+type packCacheKey struct {
+	pack   *packfile
+	offset int64
+}
+
+type packCacheEntry struct {
+	key  packCacheKey
+	typ  ObjectType
+	data []byte
+}
+
+// packCache is a small LRU cache of inflated pack objects used to avoid
+// resolving the same delta bases over and over again.
+type packCache struct {
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	lru        *list.List
+	items      map[packCacheKey]*list.Element
+}
+
+func newPackCache(maxEntries, maxBytes int) *packCache {
+	return &packCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		items:      make(map[packCacheKey]*list.Element),
+	}
+}
+
+func (pc *packCache) get(p *packfile, offset int64) (*packCacheEntry, bool) {
+	el, ok := pc.items[packCacheKey{p, offset}]
+	if !ok {
+		return nil, false
+	}
+	pc.lru.MoveToFront(el)
+	return el.Value.(*packCacheEntry), true
+}
+
+func (pc *packCache) add(p *packfile, offset int64, typ ObjectType, data []byte) {
+	if len(data) > pc.maxBytes {
+		return
+	}
+	key := packCacheKey{p, offset}
+	if el, ok := pc.items[key]; ok {
+		pc.lru.MoveToFront(el)
+		return
+	}
+	pc.items[key] = pc.lru.PushFront(&packCacheEntry{key: key, typ: typ, data: data})
+	pc.bytes += len(data)
+	for pc.lru.Len() > pc.maxEntries || pc.bytes > pc.maxBytes {
+		el := pc.lru.Back()
+		e := pc.lru.Remove(el).(*packCacheEntry)
+		delete(pc.items, e.key)
+		pc.bytes -= len(e.data)
+	}
+}
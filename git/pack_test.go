@@ -0,0 +1,155 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestOpenObject_Packed(t *testing.T) {
+	for _, ofsDelta := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ofs-delta=%v", ofsDelta), func(t *testing.T) {
+			is := is.New(t)
+			git := testgit(t)
+			is.NoErr(setupTestRepoCommits(
+				git,
+				newfile("one", "this is the first file\n"),
+				fileFromTo("git.go", "git/git.go"),
+				fileFromTo("objects.go", "git/objects.go"),
+			))
+			// Commit a bunch of similar versions of the same file so that
+			// repack has something to deltify.
+			var content strings.Builder
+			for i := range 20 {
+				fmt.Fprintf(&content, "line number %d of a file that keeps growing\n", i)
+				is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "growing"), []byte(content.String()), 0644))
+				is.NoErr(git.Add("growing"))
+				is.NoErr(git.Commit(fmt.Sprintf("version %d", i)))
+			}
+			expected := make(map[string][]byte)
+			files := must(git.Files())
+			for _, f := range files {
+				obj, err := git.OpenObject(Ref(f.Hash))
+				is.NoErr(err)
+				expected[f.Hash] = obj.Data
+			}
+			head := must(git.HeadCommitHash())
+
+			is.NoErr(run(git.Cmd(
+				"-c", fmt.Sprintf("repack.usedeltabaseoffset=%v", ofsDelta),
+				"repack", "-a", "-d", "-f", "-q",
+			)))
+			is.NoErr(run(git.Cmd("prune-packed")))
+			is.True(!exists(git.objectFilename(string(head)))) // commit should only be packed
+			packs, err := filepath.Glob(filepath.Join(git.GitDir(), "objects/pack/*.pack"))
+			is.NoErr(err)
+			is.Equal(len(packs), 1)
+
+			git = New(git.GitDir(), git.WorkingTree())
+			defer git.Close()
+			for hash, data := range expected {
+				obj, err := git.OpenObject(Ref(hash))
+				is.NoErr(err)
+				is.Equal(obj.Type, ObjBlob)
+				is.Equal(obj.Hash, hash)
+				is.Equal(obj.Size, uint64(len(data)))
+				is.True(bytes.Equal(obj.Data, data))
+			}
+			c, err := git.HeadCommit()
+			is.NoErr(err)
+			is.Equal(c.Message, "version 19")
+			entries, err := git.CommitTree(c)
+			is.NoErr(err)
+			is.True(len(entries) > 0)
+			n := 1
+			for !c.IsRoot() {
+				c, err = git.CommitParent(c)
+				is.NoErr(err)
+				n++
+			}
+			is.Equal(n, 24)
+
+			_, err = git.OpenObject(Ref(strings.Repeat("ab", HashSize)))
+			is.True(os.IsNotExist(err))
+		})
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	is := is.New(t)
+	base := []byte("the quick brown fox jumps over the lazy dog")
+	delta := []byte{
+		byte(len(base)), // source size
+		25,              // target size
+		// copy 10 bytes from offset 4 ("quick brow")
+		0x80 | 0x01 | 0x10, 4, 10,
+		// insert "n cat"
+		5, 'n', ' ', 'c', 'a', 't',
+		// copy 10 bytes from offset 33 ("e lazy dog")
+		0x80 | 0x01 | 0x10, 33, 10,
+	}
+	out, err := applyDelta(base, delta)
+	is.NoErr(err)
+	is.Equal(string(out), "quick brown cate lazy dog")
+
+	_, err = applyDelta([]byte("short"), delta)
+	is.True(err != nil) // base size mismatch
+	_, err = applyDelta(base, append([]byte{byte(len(base)), 5}, 0x80|0x01|0x10, 40, 10))
+	is.True(err != nil) // copy out of bounds
+	_, err = applyDelta(base, []byte{byte(len(base)), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 1, 'x'})
+	is.True(err != nil) // target size is far larger than the delta
+}
+
+func TestInflate_Corrupt(t *testing.T) {
+	is := is.New(t)
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	_, err := zw.Write([]byte("hello"))
+	is.NoErr(err)
+	is.NoErr(zw.Close())
+	for _, size := range []uint64{1 << 50, 4, 6} {
+		// A blob entry header with the size in little endian groups of 7
+		// bits after the first 4.
+		hdr := []byte{byte(packObjBlob<<4) | byte(size&0x0f)}
+		for rest := size >> 4; rest > 0; rest >>= 7 {
+			hdr[len(hdr)-1] |= 0x80
+			hdr = append(hdr, byte(rest&0x7f))
+		}
+		name := filepath.Join(t.TempDir(), "pack")
+		is.NoErr(os.WriteFile(name, append(hdr, data.Bytes()...), 0644))
+		f, err := os.Open(name)
+		is.NoErr(err)
+		defer f.Close()
+		p := packfile{file: f}
+		entry, err := p.readHeader(0)
+		is.NoErr(err)
+		is.Equal(entry.size, size)
+		_, err = p.inflate(entry)
+		is.True(err != nil) // the size does not match the data
+	}
+}
+
+func TestPackCache(t *testing.T) {
+	is := is.New(t)
+	p := new(packfile)
+	c := newPackCache(2, 10)
+	c.add(p, 1, ObjBlob, []byte("abc"))
+	c.add(p, 2, ObjBlob, []byte("def"))
+	_, ok := c.get(p, 1) // make 1 the most recently used
+	is.True(ok)
+	c.add(p, 3, ObjBlob, []byte("ghi"))
+	_, ok = c.get(p, 2)
+	is.True(!ok) // least recently used should be evicted
+	_, ok = c.get(p, 1)
+	is.True(ok)
+	c.add(p, 4, ObjBlob, []byte("this is too large"))
+	_, ok = c.get(p, 4)
+	is.True(!ok)
+	is.True(c.bytes <= 10)
+}
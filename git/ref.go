@@ -2,41 +2,56 @@ package git
 
 import (
 	"bytes"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 )
 
 type Ref string
 
-func NewHashRef(b [HashSize]byte) Ref {
-	return Ref(hex.EncodeToString(b[:]))
+func NewHashRef(h Hash) Ref {
+	return Ref(h.String())
 }
 
+// IsHash returns true if the ref is a hex encoded SHA-1 or SHA-256 object
+// name.
 func (ref Ref) IsHash() bool {
-	dec, err := hex.DecodeString(string(ref))
-	if err == nil && len(dec) == HashSize {
-		return true
-	}
-	return false
+	_, err := ParseHash(string(ref))
+	return err == nil
 }
 
+// Follow resolves a ref by a single level. Symbolic refs return the name of
+// the ref they point to.
 func (ref Ref) Follow(g *Git) (Ref, error) {
 	if ref.IsHash() {
 		return "", errors.New("not a followable ref")
 	}
-	return readRef(filepath.Join(g.gitDir, string(ref)))
+	return g.readRefName(string(ref))
 }
 
+// maxSymrefDepth is the maximum number of symbolic refs followed when
+// resolving a ref. See 'SYMREF_MAXDEPTH' in "refs.h".
+const maxSymrefDepth = 5
+
 func (ref Ref) fullFollow(g *Git) (r Ref, err error) {
-	r = ref
-	if r.IsHash() {
-		return r, nil
+	if ref.IsHash() {
+		return ref, nil
+	}
+	r, err = g.dwimRef(string(ref))
+	if err != nil {
+		return "", err
 	}
-	for !r.IsHash() {
-		r, err = readRef(filepath.Join(g.gitDir, string(r)))
+	for depth := 0; !r.IsHash(); depth++ {
+		if depth >= maxSymrefDepth {
+			return "", fmt.Errorf("symbolic ref %q is too deep or has a loop", ref)
+		}
+		r, err = g.readRefName(string(r))
 		if err != nil {
 			return "", err
 		}
@@ -44,6 +59,79 @@ func (ref Ref) fullFollow(g *Git) (r Ref, err error) {
 	return r, nil
 }
 
+// refRevParseRules are the rules used to expand a short ref name. See
+// 'ref_rev_parse_rules' in "refs.c".
+var refRevParseRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// dwimRef reads the first ref that a short name expands to.
+func (g *Git) dwimRef(name string) (Ref, error) {
+	var firstErr error
+	for i, rule := range refRevParseRules {
+		full := fmt.Sprintf(rule, name)
+		if i == 0 && !strings.HasPrefix(full, "refs/") && !isRootRef(full) {
+			continue
+		}
+		r, err := g.readRefName(full)
+		if err == nil {
+			return r, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", firstErr
+}
+
+// isRootRef returns true for refs that live at the top of the git directory
+// like "HEAD" or "ORIG_HEAD".
+func isRootRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// readRefName reads a single ref by its full name from either a loose ref file
+// or the packed-refs file.
+func (g *Git) readRefName(name string) (Ref, error) {
+	filename := filepath.Join(g.gitDir, filepath.FromSlash(name))
+	r, err := readRef(filename)
+	if err == nil {
+		return r, nil
+	}
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.EISDIR) {
+		return "", err
+	}
+	if strings.HasPrefix(name, "refs/") {
+		packed, err := g.packedRefs()
+		if err != nil {
+			return "", err
+		}
+		i, ok := slices.BinarySearchFunc(packed, name, func(p packedRef, name string) int {
+			return strings.Compare(p.name, name)
+		})
+		if ok {
+			return packed[i].hash, nil
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+}
+
 func readRef(filename string) (Ref, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -61,3 +149,201 @@ func readRef(filename string) (Ref, error) {
 	all = bytes.Trim(all, " \t\r\n")
 	return Ref(all), nil
 }
+
+// Reflog reads the reflog of a ref with the most recent entry first so that
+// the entry at index i is "<ref>@{i}". An empty ref means "HEAD" and short
+// branch names such as "main" are looked up under "refs/heads/".
+func (g *Git) Reflog(ref string) ([]Log, error) {
+	f, err := g.openReflog(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	logs, err := parseLogs(f)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(logs)
+	return logs, nil
+}
+
+func (g *Git) openReflog(ref string) (*os.File, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	names := []string{ref}
+	if ref != "HEAD" && !strings.HasPrefix(ref, "refs/") {
+		names = append(names, "refs/heads/"+ref)
+	}
+	var err error
+	for _, name := range names {
+		var f *os.File
+		f, err = os.Open(filepath.Join(g.gitDir, "logs", filepath.FromSlash(name)))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// packedRef is a single entry in the "packed-refs" file.
+type packedRef struct {
+	name   string
+	hash   Ref
+	peeled Ref // object pointed to by an annotated tag
+}
+
+// packedRefs reads the "packed-refs" file sorted by ref name. See
+// "refs/packed-backend.c".
+func (g *Git) packedRefs() ([]packedRef, error) {
+	raw, err := os.ReadFile(filepath.Join(g.gitDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parsePackedRefs(raw)
+}
+
+func parsePackedRefs(raw []byte) ([]packedRef, error) {
+	refs := make([]packedRef, 0)
+	sorted := false
+	for len(raw) > 0 {
+		var line []byte
+		line, raw, _ = bytes.Cut(raw, []byte{'\n'})
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		switch {
+		case len(line) == 0:
+			continue
+		case line[0] == '#':
+			if traits, ok := bytes.CutPrefix(line, []byte("# pack-refs with:")); ok {
+				sorted = slices.Contains(strings.Fields(string(traits)), "sorted")
+			}
+			continue
+		case line[0] == '^':
+			if len(refs) == 0 {
+				return nil, errors.New("packed-refs: peeled line before a ref")
+			}
+			peeled := Ref(line[1:])
+			if !peeled.IsHash() {
+				return nil, fmt.Errorf("packed-refs: invalid peeled line %q", line)
+			}
+			refs[len(refs)-1].peeled = peeled
+			continue
+		}
+		hash, name, ok := bytes.Cut(line, []byte{' '})
+		if !ok || !Ref(hash).IsHash() {
+			return nil, fmt.Errorf("packed-refs: invalid line %q", line)
+		}
+		refs = append(refs, packedRef{name: string(name), hash: Ref(hash)})
+	}
+	if !sorted {
+		slices.SortFunc(refs, func(a, b packedRef) int { return strings.Compare(a.name, b.name) })
+	}
+	return refs, nil
+}
+
+// RefEntry is a ref returned by [Git.ListRefs].
+type RefEntry struct {
+	// Name is the full name of the ref, i.e. "refs/heads/main".
+	Name string
+	// Hash is the object the ref resolves to.
+	Hash Ref
+	// Target is the ref pointed to by a symbolic ref.
+	Target string
+	// Peeled is the object pointed to by an annotated tag and is empty for
+	// any other ref.
+	Peeled Ref
+}
+
+// ListRefs lists all the loose and packed refs that start with prefix sorted by
+// name. Refs that cannot be resolved are skipped.
+func (g *Git) ListRefs(prefix string) ([]RefEntry, error) {
+	packed, err := g.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]RefEntry, len(packed))
+	for _, p := range packed {
+		refs[p.name] = RefEntry{Name: p.name, Hash: p.hash, Peeled: p.peeled}
+	}
+	root := filepath.Join(g.gitDir, "refs")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(g.gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		r, err := readRef(path)
+		if err != nil {
+			return err
+		}
+		e := RefEntry{Name: name, Hash: r}
+		if !r.IsHash() {
+			e.Target = string(r)
+			if e.Hash, err = r.fullFollow(g); err != nil {
+				delete(refs, name)
+				return nil
+			}
+		}
+		refs[name] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]RefEntry, 0, len(refs))
+	for name, e := range refs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if e.Peeled == "" && strings.HasPrefix(name, "refs/tags/") {
+			if e.Peeled, err = g.peel(e.Hash); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b RefEntry) int { return strings.Compare(a.Name, b.Name) })
+	return entries, nil
+}
+
+// peel follows annotated tags until a non-tag object is found. It returns an
+// empty ref if the object is not a tag.
+func (g *Git) peel(ref Ref) (Ref, error) {
+	var peeled Ref
+	for depth := 0; ; depth++ {
+		obj, err := g.OpenObject(ref)
+		if err != nil {
+			return "", err
+		}
+		if obj.Type != ObjTag {
+			return peeled, nil
+		}
+		if depth >= maxSymrefDepth {
+			return "", errors.New("tag chain is too deep")
+		}
+		var t Tag
+		if err = parseTag(obj.Data, &t); err != nil {
+			return "", err
+		}
+		peeled = NewHashRef(t.Object)
+		ref = peeled
+	}
+}
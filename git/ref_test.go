@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParsePackedRefs(t *testing.T) {
+	is := is.New(t)
+	a := strings.Repeat("a", HashSize*2)
+	b := strings.Repeat("b", HashSize*2)
+	c := strings.Repeat("c", HashSize*2)
+	refs, err := parsePackedRefs([]byte("# pack-refs with: peeled fully-peeled \n" +
+		b + " refs/tags/v1\n" +
+		"^" + c + "\n" +
+		a + " refs/heads/main\n"))
+	is.NoErr(err)
+	is.Equal(len(refs), 2)
+	is.Equal(refs[0], packedRef{name: "refs/heads/main", hash: Ref(a)}) // should be sorted
+	is.Equal(refs[1], packedRef{name: "refs/tags/v1", hash: Ref(b), peeled: Ref(c)})
+
+	_, err = parsePackedRefs([]byte("^" + c + "\n"))
+	is.True(err != nil)
+	_, err = parsePackedRefs([]byte("not-a-hash refs/heads/main\n"))
+	is.True(err != nil)
+}
+
+func TestGit_PackedRefs(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+	))
+	branch := must(git.CurrentBranch())
+	head := must(git.HeadCommitHash())
+	is.NoErr(git.RunCmd("tag", "light", "HEAD~1"))
+	is.NoErr(git.RunCmd("tag", "-a", "-m", "annotated", "v1"))
+	is.NoErr(git.RunCmd("tag", "-a", "-m", "tag of a tag", "nested", "v1"))
+	is.NoErr(git.CreateRemoteRef("origin", branch, must(git.Head())))
+	is.NoErr(git.RunCmd("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/"+branch))
+	is.NoErr(git.RunCmd("pack-refs", "--all", "--prune"))
+	is.True(!exists(filepath.Join(git.GitDir(), "refs/heads", branch)))
+
+	is.Equal(must(git.HeadCommitHash()), head)
+	is.Equal(must(Ref("refs/remotes/origin/"+branch).fullFollow(git)), head)
+	is.Equal(must(Ref(branch).fullFollow(git)), head)
+	is.Equal(must(Ref("origin").fullFollow(git)), head) // refs/remotes/origin/HEAD
+	is.Equal(must(Ref("light").fullFollow(git)), NewHashRef(must(git.OpenCommit(head)).Parents[0]))
+	_, err := Ref("missing").fullFollow(git)
+	is.True(os.IsNotExist(err))
+	_, err = Ref("config").fullFollow(git)
+	is.True(os.IsNotExist(err))
+
+	refs, err := git.ListRefs("")
+	is.NoErr(err)
+	var out bytes.Buffer
+	cmd := git.Cmd("show-ref", "-d")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	var exp strings.Builder
+	for _, r := range refs {
+		fmt.Fprintf(&exp, "%s %s\n", r.Hash, r.Name)
+		if r.Peeled != "" {
+			fmt.Fprintf(&exp, "%s %s^{}\n", r.Peeled, r.Name)
+		}
+	}
+	is.Equal(exp.String(), out.String()) // should match git show-ref
+
+	// Loose refs take precedence over packed refs.
+	is.NoErr(git.RunCmd("commit", "-q", "--allow-empty", "-m", "new commit"))
+	tags, err := git.ListRefs("refs/heads/")
+	is.NoErr(err)
+	is.Equal(len(tags), 1)
+	is.Equal(tags[0].Hash, must(git.HeadCommitHash()))
+	is.True(tags[0].Hash != head)
+	remotes, err := git.ListRefs("refs/remotes/")
+	is.NoErr(err)
+	is.Equal(len(remotes), 2)
+	is.Equal(remotes[0].Name, "refs/remotes/origin/HEAD")
+	is.Equal(remotes[0].Target, "refs/remotes/origin/"+branch)
+	is.Equal(remotes[0].Hash, head)
+}
+
+func TestRef_SymrefLoop(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(git, newfile("one", "this is the first file\n")))
+	heads := filepath.Join(git.GitDir(), "refs", "heads")
+	is.NoErr(os.WriteFile(filepath.Join(heads, "a"), []byte("ref: refs/heads/b\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(heads, "b"), []byte("ref: refs/heads/a\n"), 0644))
+	_, err := Ref("a").fullFollow(git)
+	is.True(err != nil)
+	is.True(!os.IsNotExist(err))
+	_, err = git.OpenObject(Ref("refs/heads/b"))
+	is.True(err != nil)
+}
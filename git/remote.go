@@ -5,10 +5,17 @@ import (
 	"path/filepath"
 )
 
+// CreateRemoteRef writes the remote tracking ref
+// "refs/remotes/<remoteName>/<branchName>". Symbolic refs are resolved first
+// so that the remote ref records the commit that was pushed or fetched.
 func (g *Git) CreateRemoteRef(remoteName, branchName string, ref Ref) error {
+	hash, err := ref.fullFollow(g)
+	if err != nil {
+		return err
+	}
 	remoteDir := filepath.Join(g.gitDir, "refs/remotes", remoteName)
 	filename := filepath.Join(remoteDir, branchName)
-	err := os.MkdirAll(remoteDir, os.FileMode(0775))
+	err = os.MkdirAll(filepath.Dir(filename), os.FileMode(0775))
 	if err != nil {
 		return err
 	}
@@ -17,13 +24,7 @@ func (g *Git) CreateRemoteRef(remoteName, branchName string, ref Ref) error {
 		return err
 	}
 	defer f.Close()
-	if !ref.IsHash() {
-		_, err = f.WriteString("ref: ")
-		if err != nil {
-			return err
-		}
-	}
-	_, err = f.WriteString(string(ref))
+	_, err = f.WriteString(string(hash) + "\n")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,5 @@
+package git
+
+import "syscall"
+
+func statCtime(st *syscall.Stat_t) (sec, nsec int64) { return st.Ctim.Unix() }
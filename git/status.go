@@ -0,0 +1,207 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// indexDiff compares the HEAD tree with the index and the working tree. The
+// output matches 'git diff-index HEAD' except that files with stale stat data
+// are hashed before being reported so that files which were touched but not
+// changed are left out. Like git, a file that differs in the working tree is
+// reported with a zero destination hash.
+//
+// See 'run_diff_index' and 'do_oneway_diff' in "diff-lib.c".
+func (g *Git) indexDiff() ([]*ModifiedFile, error) {
+	head, err := g.headTree()
+	if err != nil {
+		return nil, err
+	}
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	zeroHash := algo.ZeroHash().String()
+	filename := g.indexFile()
+	ix, err := readIndexFile(filename, algo)
+	var indexInfo fs.FileInfo
+	switch {
+	case err == nil:
+		if indexInfo, err = os.Stat(filename); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		ix = &index{algo: algo}
+	default:
+		return nil, err
+	}
+
+	pf, err := g.worktreeFilters()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mods = make([]*ModifiedFile, 0)
+		seen = make(map[string]struct{}, len(ix.entries))
+	)
+	for i := range ix.entries {
+		ce := &ix.entries[i]
+		if _, ok := seen[ce.name]; ok {
+			continue // higher stages of an unmerged path
+		}
+		seen[ce.name] = struct{}{}
+		if ce.stage() != 0 {
+			mods = append(mods, &ModifiedFile{
+				Name: ce.name,
+				Type: ModUnmerged,
+				Src:  ObjModification{Hash: zeroHash},
+				Dst:  ObjModification{Hash: zeroHash},
+			})
+			continue
+		}
+		dst, removed, err := g.worktreeState(ce, algo, indexInfo, pf)
+		if err != nil {
+			return nil, err
+		}
+		src, inHead := head[ce.name]
+		switch {
+		case !inHead && removed:
+			continue
+		case !inHead:
+			mods = append(mods, &ModifiedFile{
+				Name: ce.name,
+				Type: ModAddition,
+				Src:  ObjModification{Hash: zeroHash},
+				Dst:  dst,
+			})
+		case removed:
+			mods = append(mods, &ModifiedFile{
+				Name: ce.name,
+				Type: ModDelete,
+				Src:  treeEntryModification(&src),
+				Dst:  ObjModification{Hash: zeroHash},
+			})
+		default:
+			m := treeEntryModification(&src)
+			if m == dst {
+				continue
+			}
+			typ := ModChanged
+			if fs.FileMode(m.Mode)&modeTypeMask != fs.FileMode(dst.Mode)&modeTypeMask {
+				typ = ModFileType
+			}
+			mods = append(mods, &ModifiedFile{Name: ce.name, Type: typ, Src: m, Dst: dst})
+		}
+	}
+	for name, e := range head {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		mods = append(mods, &ModifiedFile{
+			Name: name,
+			Type: ModDelete,
+			Src:  treeEntryModification(&e),
+			Dst:  ObjModification{Hash: zeroHash},
+		})
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Name < mods[j].Name })
+	return mods, nil
+}
+
+// worktreeState returns the mode and hash of an index entry as seen in the
+// working tree. The hash is zero if the file differs from the index entry.
+// See 'get_stat_data' in "diff-lib.c".
+func (g *Git) worktreeState(ce *indexCacheEntry, algo HashAlgo, indexInfo fs.FileInfo, pf *pathFilters) (m ObjModification, removed bool, err error) {
+	m = ObjModification{Mode: int(ce.mode), Hash: hex.EncodeToString(ce.oid)}
+	if ce.skipWorktree() {
+		return m, false, nil
+	}
+	p := filepath.Join(g.workTree, ce.name)
+	info, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return m, true, nil
+		}
+		return m, false, err
+	}
+	if ce.mode&modeTypeMask == modeGitlink {
+		// Submodules are compared using the index only.
+		if !info.IsDir() {
+			return m, true, nil
+		}
+		return m, false, nil
+	}
+	if info.IsDir() {
+		return m, true, nil
+	}
+	changed := ce.matchStat(info)
+	if changed == 0 && (indexInfo == nil || !ce.isRacy(indexInfo.ModTime())) && !ce.intentToAdd() {
+		return m, false, nil
+	}
+	m.Mode = int(gitFileMode(info))
+	if changed&typeChanged == 0 && !ce.intentToAdd() {
+		hash, err := hashWorktreeFile(algo, p, info, pf, ce.name)
+		if err != nil {
+			return m, false, err
+		}
+		if bytes.Equal(hash, ce.oid) {
+			return m, false, nil
+		}
+	}
+	m.Hash = algo.ZeroHash().String()
+	return m, false, nil
+}
+
+// headTree returns all the non-tree entries of the HEAD commit keyed by their
+// full path. An unborn branch has an empty tree.
+func (g *Git) headTree() (map[string]TreeEntry, error) {
+	c, err := g.HeadCommit()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]TreeEntry{}, nil
+		}
+		return nil, err
+	}
+	files := make(map[string]TreeEntry)
+	return files, g.treeFiles(NewHashRef(c.Tree), "", files)
+}
+
+func (g *Git) treeFiles(tree Ref, prefix string, files map[string]TreeEntry) error {
+	obj, err := g.OpenObject(tree)
+	if err != nil {
+		return err
+	}
+	if obj.Type != ObjTree {
+		return errors.New("object is not a tree object")
+	}
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return err
+	}
+	entries, err := parseTree(obj.Data, algo.Size())
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(prefix, e.Name)
+		if e.Mode == TreeMode {
+			if err = g.treeFiles(NewHashRef(e.Hash), name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = e
+	}
+	return nil
+}
+
+func treeEntryModification(e *TreeEntry) ObjModification {
+	return ObjModification{Mode: int(e.Mode), Hash: e.Hash.String()}
+}
@@ -0,0 +1,96 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_Modifications(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+		newfile("three", "this is the third file\n"),
+		newfile("script.sh", "#!/bin/sh\necho hello\n"),
+		newfile("dir/nested", "a nested file\n"),
+	))
+	tree := git.WorkingTree()
+	is.NoErr(os.Symlink("one", filepath.Join(tree, "link")))
+	is.NoErr(git.Add("link"))
+	is.NoErr(git.Commit("add link"))
+	mods, err := git.Modifications()
+	is.NoErr(err)
+	is.Equal(len(mods), 0)
+
+	// Touching a file changes its stat data but not its contents.
+	future := time.Now().Add(time.Hour)
+	is.NoErr(os.Chtimes(filepath.Join(tree, "one"), future, future))
+	mods, err = git.Modifications()
+	is.NoErr(err)
+	is.Equal(len(mods), 0)
+
+	is.NoErr(appendfile(filepath.Join(tree, "two"), "more content\n"))
+	is.NoErr(os.Remove(filepath.Join(tree, "three")))
+	is.NoErr(os.WriteFile(filepath.Join(tree, "new"), []byte("new file\n"), 0644))
+	is.NoErr(git.Add("new"))
+	is.NoErr(os.Remove(filepath.Join(tree, "link")))
+	is.NoErr(os.Symlink("two", filepath.Join(tree, "link")))
+	is.NoErr(os.Remove(filepath.Join(tree, "dir/nested")))
+	is.NoErr(os.Symlink("../one", filepath.Join(tree, "dir/nested")))
+	is.NoErr(os.Chmod(filepath.Join(tree, "script.sh"), 0755))
+
+	mods, err = git.Modifications()
+	is.NoErr(err)
+	expected := []struct {
+		name string
+		typ  ModType
+	}{
+		{"dir/nested", ModFileType},
+		{"link", ModChanged},
+		{"new", ModAddition},
+		{"script.sh", ModChanged},
+		{"three", ModDelete},
+		{"two", ModChanged},
+	}
+	is.Equal(len(mods), len(expected))
+	for i, exp := range expected {
+		is.Equal(mods[i].Name, exp.name)
+		is.Equal(mods[i].Type, exp.typ)
+	}
+	is.Equal(mods[3].Src.Mode, 0100644)
+	is.Equal(mods[3].Dst.Mode, 0100755)
+	is.Equal(mods[3].Src.Hash, mods[3].Dst.Hash) // contents did not change
+	is.Equal(mods[4].Dst.Hash, SHA1.ZeroHash().String())
+	is.Equal(mods[4].Dst.Mode, 0)
+
+	// Compare against git once the stat data is fresh.
+	is.NoErr(os.Chmod(filepath.Join(tree, "script.sh"), 0644))
+	_ = git.Cmd("update-index", "-q", "--refresh").Run()
+	mods, err = git.Modifications()
+	is.NoErr(err)
+	cmdMods, err := git.modificationsCmd()
+	is.NoErr(err)
+	is.Equal(len(mods), len(cmdMods))
+	for i := range mods {
+		is.Equal(*mods[i], *cmdMods[i])
+	}
+}
+
+func TestGit_Modifications_Unborn(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "file"), []byte("file\n"), 0644))
+	is.NoErr(git.Add("file"))
+	mods, err := git.Modifications()
+	is.NoErr(err)
+	is.Equal(len(mods), 1)
+	is.Equal(mods[0].Type, ModAddition)
+	is.Equal(mods[0].Src.Hash, SHA1.ZeroHash().String())
+}
@@ -0,0 +1,143 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TagOptions configures the tag made by [Git.CreateTag].
+type TagOptions struct {
+	// Message is the message of an annotated tag. A lightweight tag is
+	// created if it is empty.
+	Message string
+	// Tagger is the identity stored in an annotated tag.
+	Tagger Identity
+	// Force replaces an existing tag with the same name.
+	Force bool
+}
+
+// OpenTag opens an annotated tag object.
+func (g *Git) OpenTag(ref Ref) (*Tag, error) {
+	obj, err := g.OpenObject(ref)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != ObjTag {
+		return nil, fmt.Errorf("object %s is a %s, not a tag", obj.Hash, obj.Type)
+	}
+	var t Tag
+	if err = parseTag(obj.Data, &t); err != nil {
+		return nil, err
+	}
+	if t.Hash, err = ParseHash(obj.Hash); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTag creates the tag "refs/tags/<name>" pointing at target. If opts
+// has a message then an annotated tag object is written, otherwise the tag is
+// a lightweight tag that points directly at the target. It returns the hash
+// stored in the tag's ref.
+func (g *Git) CreateTag(name string, target Ref, opts *TagOptions) (Hash, error) {
+	if opts == nil {
+		opts = &TagOptions{}
+	}
+	if err := checkRefName(name); err != nil {
+		return nil, err
+	}
+	ref, err := target.fullFollow(g)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := g.OpenObject(ref)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := ParseHash(obj.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Message) > 0 {
+		tagger := opts.Tagger
+		if tagger.When.IsZero() {
+			tagger.When = time.Now()
+		}
+		t := Tag{
+			Type:       obj.Type,
+			Name:       name,
+			Tagger:     tagger.String() + " ",
+			TaggerTime: tagger.When,
+			Object:     hash,
+			Message:    strings.TrimRight(opts.Message, "\n"),
+		}
+		data, err := t.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if hash, err = g.writeObject(ObjTag, data); err != nil {
+			return nil, err
+		}
+	}
+	refname := "refs/tags/" + name
+	var old Hash
+	if opts.Force {
+		current, err := Ref(refname).fullFollow(g)
+		if err == nil {
+			old, err = ParseHash(string(current))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err = g.updateRef(refname, old, hash); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// PeelCommit opens the commit that a ref points to, following any annotated
+// tags.
+func (g *Git) PeelCommit(ref Ref) (*Commit, error) {
+	peeled, err := g.peel(ref)
+	if err != nil {
+		return nil, err
+	}
+	if peeled != "" {
+		ref = peeled
+	}
+	return g.OpenCommit(ref)
+}
+
+// checkRefName validates a branch or tag name using a subset of the rules in
+// 'git check-ref-format'.
+func checkRefName(name string) error {
+	bad := func(reason string) error {
+		return fmt.Errorf("%q is not a valid ref name: %s", name, reason)
+	}
+	switch {
+	case name == "" || name == "@":
+		return bad("empty name")
+	case strings.HasPrefix(name, "-"):
+		return bad("starts with '-'")
+	case strings.HasSuffix(name, "/") || strings.HasSuffix(name, "."):
+		return bad("ends with '/' or '.'")
+	case strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{"):
+		return bad("contains '..', '//' or '@{'")
+	case strings.ContainsAny(name, " ~^:?*[\\\x7f"):
+		return bad("contains a forbidden character")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return bad("a component starts with '.' or ends with '.lock'")
+		}
+	}
+	for _, c := range name {
+		if c < ' ' {
+			return bad("contains a control character")
+		}
+	}
+	return nil
+}
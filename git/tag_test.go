@@ -0,0 +1,98 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_OpenTag(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(git, newfile("one", "this is the first file\n")))
+	is.NoErr(git.RunCmd("tag", "-a", "-m", "first release\n\nwith notes", "v1"))
+	is.NoErr(git.RunCmd("tag", "-a", "-m", "tag of a tag", "nested", "v1"))
+	is.NoErr(git.RunCmd("tag", "light"))
+
+	tag, err := git.OpenTag("v1")
+	is.NoErr(err)
+	is.Equal(tag.Name, "v1")
+	is.Equal(tag.Type, ObjCommit)
+	is.Equal(NewHashRef(tag.Object), must(git.HeadCommitHash()))
+	is.Equal(tag.Tagger, "DotsTests <dots@example.com> ")
+	is.Equal(tag.Message, "first release\n\nwith notes")
+	obj, err := git.OpenObject("v1")
+	is.NoErr(err)
+	raw, err := tag.MarshalBinary()
+	is.NoErr(err)
+	is.Equal(string(raw), string(obj.Data))
+
+	nested, err := git.OpenTag("nested")
+	is.NoErr(err)
+	is.Equal(nested.Type, ObjTag)
+	is.True(nested.Object.Equal(tag.Hash))
+	c, err := git.PeelCommit("nested")
+	is.NoErr(err)
+	is.Equal(NewHashRef(c.Hash), must(git.HeadCommitHash()))
+
+	_, err = git.OpenTag("light")
+	is.True(err != nil) // lightweight tags have no tag object
+	var parsed Tag
+	is.True(parseTag([]byte("type commit\ntag v1\n\nmessage\n"), &parsed) != nil)
+}
+
+func TestGit_CreateTag(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepoCommits(
+		git,
+		newfile("one", "this is the first file\n"),
+		newfile("two", "this is the second file\n"),
+	))
+	head := must(git.HeadCommitHash())
+	parent := NewHashRef(must(git.HeadCommit()).Parents[0])
+
+	light, err := git.CreateTag("light", "HEAD", nil)
+	is.NoErr(err)
+	is.Equal(NewHashRef(light), head)
+	_, err = git.CreateTag("light", parent, nil)
+	is.True(err != nil) // tag already exists
+
+	tagger := Identity{Name: "Jane Doe", Email: "jane@example.com", When: time.Unix(1700000000, 0).UTC()}
+	annotated, err := git.CreateTag("snapshots/laptop", "HEAD", &TagOptions{
+		Message: "known good setup\n",
+		Tagger:  tagger,
+	})
+	is.NoErr(err)
+	is.NoErr(git.RunCmd("fsck", "--strict", "--no-dangling"))
+	var out bytes.Buffer
+	cmd := git.Cmd("for-each-ref", "--format=%(objecttype) %(*objectname) %(taggername) %(taggerdate:raw) %(contents)", "refs/tags/snapshots/")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	is.Equal(out.String(), "tag "+string(head)+" Jane Doe 1700000000 +0000 known good setup\n\n")
+	c, err := git.PeelCommit("snapshots/laptop")
+	is.NoErr(err)
+	is.Equal(NewHashRef(c.Hash), head)
+	for c, err := range git.Log("snapshots/laptop") {
+		is.NoErr(err)
+		is.Equal(NewHashRef(c.Hash), head)
+		break
+	}
+
+	// Force moves an existing tag.
+	moved, err := git.CreateTag("snapshots/laptop", parent, &TagOptions{Message: "moved", Tagger: tagger, Force: true})
+	is.NoErr(err)
+	is.True(!moved.Equal(annotated))
+	tag, err := git.OpenTag("snapshots/laptop")
+	is.NoErr(err)
+	is.Equal(tag.Message, "moved")
+
+	for _, name := range []string{"", "-v1", "a..b", "a b", "a/", ".hidden", "a.lock", "a~1", "x@{1}"} {
+		_, err = git.CreateTag(name, "HEAD", nil)
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "not a valid ref name"))
+	}
+}
@@ -0,0 +1,162 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"path"
+)
+
+// TreeFile is a file or directory found while walking a tree.
+type TreeFile struct {
+	// Path is the slash separated path of the file relative to the root of
+	// the tree.
+	Path string
+	// Mode is the git file mode as it is stored in the tree.
+	Mode fs.FileMode
+	Hash Hash
+	// Target is the target of a symlink.
+	Target string
+
+	objects objectReader
+	filters *pathFilters
+}
+
+// objectReader opens objects by their hash.
+type objectReader interface {
+	OpenObject(ref Ref) (*Object, error)
+}
+
+// IsDir returns true for trees and submodules. The contents of submodules are
+// not part of the tree so they are always empty.
+func (f *TreeFile) IsDir() bool { return f.Mode == TreeMode || f.Mode == modeGitlink }
+
+// IsSymlink returns true if the file is a symbolic link.
+func (f *TreeFile) IsSymlink() bool { return f.Mode == modeSymlink }
+
+// FileMode converts the git mode to the mode the file should have on disk.
+func (f *TreeFile) FileMode() fs.FileMode {
+	switch {
+	case f.IsDir():
+		return fs.ModeDir | 0755
+	case f.IsSymlink():
+		return fs.ModeSymlink | 0777
+	case f.Mode&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// Open returns a reader for the contents of a blob. Regular files are
+// converted by the filter set for them by the tree's attributes, see
+// [Git.SetFilter].
+func (f *TreeFile) Open() (io.ReadCloser, error) {
+	if f.IsDir() {
+		return nil, fmt.Errorf("%q is a directory", f.Path)
+	}
+	obj, err := f.objects.OpenObject(NewHashRef(f.Hash))
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != ObjBlob {
+		return nil, fmt.Errorf("%q: object %s is not a blob", f.Path, obj.Hash)
+	}
+	data := obj.Data
+	if !f.IsSymlink() {
+		if data, err = f.filters.smudge(f.Path, data); err != nil {
+			return nil, err
+		}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// WalkTree yields every file and directory in the tree of a commit, tag or
+// tree in the same order as 'git archive'. Directories are yielded before
+// their contents.
+func (g *Git) WalkTree(ref Ref) iter.Seq2[*TreeFile, error] {
+	return func(yield func(*TreeFile, error) bool) {
+		tree, err := g.peelTree(ref)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		algo, err := g.HashAlgo()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		pf, err := treeFilters(g.filters, g, algo, tree, g.gitDir)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		walkTree(g, algo, pf, tree, "", yield)
+	}
+}
+
+// walkTree returns false if the walk was stopped.
+func walkTree(objects objectReader, algo HashAlgo, pf *pathFilters, tree Hash, prefix string, yield func(*TreeFile, error) bool) bool {
+	obj, err := objects.OpenObject(NewHashRef(tree))
+	if err != nil {
+		yield(nil, err)
+		return false
+	}
+	if obj.Type != ObjTree {
+		yield(nil, fmt.Errorf("object %s is not a tree", obj.Hash))
+		return false
+	}
+	entries, err := parseTree(obj.Data, algo.Size())
+	if err != nil {
+		yield(nil, err)
+		return false
+	}
+	for _, e := range entries {
+		f := TreeFile{Path: path.Join(prefix, e.Name), Mode: e.Mode, Hash: e.Hash, objects: objects, filters: pf}
+		if f.IsSymlink() {
+			target, err := f.Open()
+			if err != nil {
+				yield(nil, err)
+				return false
+			}
+			b, _ := io.ReadAll(target)
+			f.Target = string(b)
+		}
+		if !yield(&f, nil) {
+			return false
+		}
+		if e.Mode == TreeMode && !walkTree(objects, algo, pf, e.Hash, f.Path, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// peelTree resolves a ref to a tree by following tags and commits.
+func (g *Git) peelTree(ref Ref) (Hash, error) {
+	peeled, err := g.peel(ref)
+	if err != nil {
+		return nil, err
+	}
+	if peeled != "" {
+		ref = peeled
+	}
+	obj, err := g.OpenObject(ref)
+	if err != nil {
+		return nil, err
+	}
+	switch obj.Type {
+	case ObjTree:
+		return ParseHash(obj.Hash)
+	case ObjCommit:
+		var c Commit
+		if err = parseCommit(bytes.NewReader(obj.Data), &c); err != nil {
+			return nil, err
+		}
+		return c.Tree, nil
+	default:
+		return nil, fmt.Errorf("object %s is a %s, not a tree", obj.Hash, obj.Type)
+	}
+}
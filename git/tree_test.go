@@ -0,0 +1,78 @@
+package git
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_WalkTree(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	tree := git.WorkingTree()
+	is.NoErr(os.MkdirAll(filepath.Join(tree, ".config/nvim/lua"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".bashrc"), []byte("export A=1\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".config/nvim/init.lua"), []byte("require('a')\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".config/nvim/lua/a.lua"), []byte("return {}\n"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".config/run.sh"), []byte("#!/bin/sh\n"), 0755))
+	is.NoErr(os.Symlink(".bashrc", filepath.Join(tree, ".profile")))
+	is.NoErr(git.Add(".bashrc", ".config", ".profile"))
+	is.NoErr(git.Commit("first"))
+	is.NoErr(git.RunCmd("tag", "-a", "-m", "v1", "v1"))
+	is.NoErr(os.WriteFile(filepath.Join(tree, ".bashrc"), []byte("export A=2\n"), 0644))
+	is.NoErr(git.Add(".bashrc"))
+	is.NoErr(git.Commit("second"))
+
+	for _, rev := range []string{"HEAD", "v1"} {
+		var out bytes.Buffer
+		cmd := git.Cmd("archive", "--format=tar", rev)
+		cmd.Stdout = &out
+		is.NoErr(run(cmd))
+		archive := tar.NewReader(&out)
+		for f, err := range git.WalkTree(Ref(rev)) {
+			is.NoErr(err)
+			hdr, err := archive.Next()
+			is.NoErr(err)
+			if hdr.Typeflag == tar.TypeXGlobalHeader {
+				hdr, err = archive.Next() // skip the commit id header
+				is.NoErr(err)
+			}
+			is.Equal(f.Path, strings.TrimSuffix(hdr.Name, "/")) // should be in the same order as git archive
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				is.True(f.IsDir())
+			case tar.TypeSymlink:
+				is.True(f.IsSymlink())
+				is.Equal(f.Target, hdr.Linkname)
+			case tar.TypeReg:
+				is.Equal(f.FileMode().Perm()&0111 != 0, hdr.FileInfo().Mode().Perm()&0111 != 0)
+				r, err := f.Open()
+				is.NoErr(err)
+				got, err := io.ReadAll(r)
+				is.NoErr(err)
+				exp, err := io.ReadAll(archive)
+				is.NoErr(err)
+				is.Equal(string(got), string(exp))
+			}
+		}
+		_, err := archive.Next()
+		is.Equal(err, io.EOF)
+	}
+
+	n := 0
+	for range git.WalkTree("HEAD") {
+		n++
+		break
+	}
+	is.Equal(n, 1)
+	for _, err := range git.WalkTree("missing") {
+		is.True(err != nil)
+	}
+}
@@ -0,0 +1,586 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNothingToCommit is returned when a commit would not change the tree of
+// the current commit.
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// WriteObject writes an object to the object store as a zlib compressed loose
+// object. The object's hash and size are computed from its data and objects
+// that already exist are not written again. See 'write_object_file' in
+// "object-file.c".
+func (g *Git) WriteObject(o *Object) error {
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return err
+	}
+	o.Size = uint64(len(o.Data))
+	var (
+		buf bytes.Buffer
+		h   = algo.New()
+		zw  = zlib.NewWriter(&buf)
+	)
+	if _, err := o.writeTo(&hashWriter{w: zw, hash: h}); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	hash := Hash(h.Sum(nil))
+	o.Hash = hash.String()
+	ok, err := g.hasObject(hash)
+	if err != nil || ok {
+		return err
+	}
+	filename := g.objectFilename(o.Hash)
+	dir := filepath.Dir(filename)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Objects are immutable so git makes them read-only.
+	if err = os.Chmod(tmp.Name(), 0444); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (g *Git) writeObject(typ ObjectType, data []byte) (Hash, error) {
+	o := Object{Type: typ, Data: data}
+	if err := g.WriteObject(&o); err != nil {
+		return nil, err
+	}
+	return ParseHash(o.Hash)
+}
+
+// hasObject returns true if an object exists as a loose object or in a pack.
+func (g *Git) hasObject(hash Hash) (bool, error) {
+	if exists(g.objectFilename(hash.String())) {
+		return true, nil
+	}
+	if !exists(filepath.Join(g.gitDir, "objects", "pack")) {
+		return false, nil
+	}
+	packs, err := g.packStore()
+	if err != nil {
+		return false, err
+	}
+	if _, _, ok := packs.find(hash); ok {
+		return true, nil
+	}
+	// A new pack may have been written since the last scan.
+	if err = packs.scan(); err != nil {
+		return false, err
+	}
+	_, _, ok := packs.find(hash)
+	return ok, nil
+}
+
+// WriteTree writes tree objects for a set of files keyed by their slash
+// separated path and returns the hash of the root tree. The name of each entry
+// is ignored and tree entries in the map are not allowed.
+func (g *Git) WriteTree(files map[string]TreeEntry) (Hash, error) {
+	return writeTree(files, g.writeObject)
+}
+
+func writeTree(files map[string]TreeEntry, writeObject func(ObjectType, []byte) (Hash, error)) (Hash, error) {
+	type dir struct {
+		entries []TreeEntry
+		subdirs map[string]*dir
+	}
+	newDir := func() *dir { return &dir{subdirs: make(map[string]*dir)} }
+	root := newDir()
+	for name, e := range files {
+		if e.Mode == TreeMode {
+			return nil, fmt.Errorf("%q: cannot write a tree entry", name)
+		}
+		parts := strings.Split(name, "/")
+		if slices.ContainsFunc(parts, func(p string) bool { return p == "" || p == "." || p == ".." }) {
+			return nil, fmt.Errorf("invalid path %q", name)
+		}
+		d := root
+		for _, p := range parts[:len(parts)-1] {
+			sub, ok := d.subdirs[p]
+			if !ok {
+				sub = newDir()
+				d.subdirs[p] = sub
+			}
+			d = sub
+		}
+		d.entries = append(d.entries, TreeEntry{
+			Mode: e.Mode,
+			Name: parts[len(parts)-1],
+			Hash: e.Hash,
+		})
+	}
+	var write func(d *dir) (Hash, error)
+	write = func(d *dir) (Hash, error) {
+		entries := d.entries
+		for name, sub := range d.subdirs {
+			hash, err := write(sub)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, TreeEntry{Mode: TreeMode, Name: name, Hash: hash})
+		}
+		data, err := encodeTree(entries)
+		if err != nil {
+			return nil, err
+		}
+		return writeObject(ObjTree, data)
+	}
+	return write(root)
+}
+
+// encodeTree sorts tree entries and encodes them into the contents of a tree
+// object.
+func encodeTree(entries []TreeEntry) ([]byte, error) {
+	// Directories sort as if their name ended with a '/'. See
+	// 'base_name_compare' in "tree.c".
+	sortName := func(e *TreeEntry) string {
+		if e.Mode == TreeMode {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	slices.SortFunc(entries, func(a, b TreeEntry) int {
+		return strings.Compare(sortName(&a), sortName(&b))
+	})
+	var buf []byte
+	for i, e := range entries {
+		if i > 0 && entries[i-1].Name == e.Name {
+			return nil, fmt.Errorf("duplicate tree entry %q", e.Name)
+		}
+		buf = strconv.AppendUint(buf, uint64(e.Mode), 8)
+		buf = append(buf, ' ')
+		buf = append(buf, e.Name...)
+		buf = append(buf, 0)
+		buf = append(buf, e.Hash...)
+	}
+	return buf, nil
+}
+
+// WriteCommit writes a commit object and sets the commit's hash.
+func (g *Git) WriteCommit(c *Commit) (Hash, error) {
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	c.Hash, err = g.writeObject(ObjCommit, data)
+	return c.Hash, err
+}
+
+// Identity is the author or committer of a commit.
+type Identity struct {
+	Name  string
+	Email string
+	// When is the time of the commit. The current time is used if it is
+	// zero.
+	When time.Time
+}
+
+func (id *Identity) String() string {
+	return fmt.Sprintf("%s <%s>", id.Name, id.Email)
+}
+
+// CommitFiles stages files from the working tree and commits them to the
+// current branch without running git. Paths are either absolute or relative
+// to the working tree and directories are added recursively. Paths that no
+// longer exist are removed from the index. Unlike 'git add', ignore rules are
+// not applied when adding directories.
+func (g *Git) CommitFiles(paths []string, message string, author Identity) (Hash, error) {
+	return g.commitIndex(message, author, func(ix *index) error {
+		pf, err := g.worktreeFilters()
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			name, err := g.indexPath(p)
+			if err != nil {
+				return err
+			}
+			if err = g.stagePath(ix, pf, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CommitRemoval removes files from the index and commits the result without
+// running git. The files are left in the working tree, the same as
+// 'git rm --cached'.
+func (g *Git) CommitRemoval(paths []string, message string, author Identity) (Hash, error) {
+	return g.commitIndex(message, author, func(ix *index) error {
+		for _, p := range paths {
+			name, err := g.indexPath(p)
+			if err != nil {
+				return err
+			}
+			if !ix.remove(name) {
+				return fmt.Errorf("pathspec %q did not match any files", p)
+			}
+		}
+		return nil
+	})
+}
+
+// indexPath converts a path into the slash separated path used by the index.
+func (g *Git) indexPath(p string) (string, error) {
+	if filepath.IsAbs(p) {
+		tree, err := filepath.Abs(g.workTree)
+		if err != nil {
+			return "", err
+		}
+		if p, err = filepath.Rel(tree, p); err != nil {
+			return "", err
+		}
+	}
+	p = filepath.ToSlash(filepath.Clean(p))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%q is outside of the working tree", p)
+	}
+	return p, nil
+}
+
+// stagePath updates the index entries for a path in the working tree.
+func (g *Git) stagePath(ix *index, pf *pathFilters, name string) error {
+	full := filepath.Join(g.workTree, filepath.FromSlash(name))
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		ix.remove(name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return g.stageFile(ix, pf, name, full, info)
+	}
+	// Remove tracked files that have been deleted from the directory.
+	prefix := name + "/"
+	for _, ce := range slices.Clone(ix.entries) {
+		if strings.HasPrefix(ce.name, prefix) && !exists(filepath.Join(g.workTree, ce.name)) {
+			ix.remove(ce.name)
+		}
+	}
+	return filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || p == g.gitDir || (p != full && exists(filepath.Join(p, ".git"))) {
+				return filepath.SkipDir // nested repository
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(g.workTree, p)
+		if err != nil {
+			return err
+		}
+		return g.stageFile(ix, pf, filepath.ToSlash(rel), p, info)
+	})
+}
+
+func (g *Git) stageFile(ix *index, pf *pathFilters, name, full string, info fs.FileInfo) error {
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		var target string
+		target, err = os.Readlink(full)
+		data = []byte(target)
+	case info.Mode().IsRegular():
+		if data, err = os.ReadFile(full); err == nil {
+			data, err = pf.clean(name, data)
+		}
+	default:
+		return fmt.Errorf("%q: unsupported file type %s", name, info.Mode().Type())
+	}
+	if err != nil {
+		return err
+	}
+	hash, err := g.writeObject(ObjBlob, data)
+	if err != nil {
+		return err
+	}
+	ce := indexCacheEntry{
+		mode:    gitFileMode(info),
+		oid:     hash,
+		name:    name,
+		nameLen: uint(min(len(name), ceNameMask)),
+	}
+	ce.fillStatData(info)
+	ix.add(ce)
+	return nil
+}
+
+// add inserts or replaces a stage zero entry keeping the entries sorted.
+func (ix *index) add(ce indexCacheEntry) {
+	// A file replaces a directory of the same name and the other way around.
+	ix.remove(ce.name + "/")
+	for dir := path.Dir(ce.name); dir != "."; dir = path.Dir(dir) {
+		ix.remove(dir)
+	}
+	i, found := slices.BinarySearchFunc(ix.entries, ce.name, func(e indexCacheEntry, name string) int {
+		return strings.Compare(e.name, name)
+	})
+	if found {
+		ix.entries[i] = ce
+		return
+	}
+	ix.entries = slices.Insert(ix.entries, i, ce)
+}
+
+// remove deletes all entries for a path. A path ending in a slash removes all
+// the entries in that directory. It returns false if nothing was removed.
+func (ix *index) remove(name string) bool {
+	n := len(ix.entries)
+	if strings.HasSuffix(name, "/") {
+		ix.entries = slices.DeleteFunc(ix.entries, func(e indexCacheEntry) bool {
+			return strings.HasPrefix(e.name, name)
+		})
+	} else {
+		dir := name + "/"
+		ix.entries = slices.DeleteFunc(ix.entries, func(e indexCacheEntry) bool {
+			return e.name == name || strings.HasPrefix(e.name, dir)
+		})
+	}
+	return len(ix.entries) != n
+}
+
+// commitIndex applies changes to the index, commits the resulting tree to the
+// current branch and then writes the index. The index is locked for the whole
+// commit and is only replaced once the branch has been updated.
+func (g *Git) commitIndex(message string, author Identity, update func(*index) error) (_ Hash, err error) {
+	algo, err := g.HashAlgo()
+	if err != nil {
+		return nil, err
+	}
+	filename := g.indexFile()
+	lock, err := newLockFile(filename, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = lock.Rollback()
+		}
+	}()
+	ix, err := readIndexFile(filename, algo)
+	if os.IsNotExist(err) {
+		ix, err = &index{algo: algo, header: indexCacheHeader{version: 2}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range ix.extensions {
+		if ext.signature == extSplitIndex || ext.signature == extSparseDir {
+			return nil, fmt.Errorf("index extension %q is not supported", ext.signature)
+		}
+	}
+	for i := range ix.entries {
+		if ix.entries[i].stage() != 0 {
+			return nil, fmt.Errorf("cannot commit with unmerged path %q", ix.entries[i].name)
+		}
+	}
+	if err = update(ix); err != nil {
+		return nil, err
+	}
+	files := make(map[string]TreeEntry, len(ix.entries))
+	for _, ce := range ix.entries {
+		if ce.intentToAdd() {
+			continue
+		}
+		files[ce.name] = TreeEntry{Mode: ce.mode, Hash: ce.oid}
+	}
+	tree, err := g.WriteTree(files)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := g.HeadCommit()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if author.When.IsZero() {
+		author.When = time.Now()
+	}
+	c := Commit{
+		Tree:         tree,
+		Author:       author.String() + " ",
+		AuthorTime:   author.When,
+		Commiter:     author.String() + " ",
+		CommiterTime: author.When,
+		Message:      strings.TrimRight(message, "\n"),
+	}
+	var old Hash
+	if head != nil {
+		if head.Tree.Equal(tree) {
+			return nil, ErrNothingToCommit
+		}
+		c.Parents = []Hash{head.Hash}
+		old = head.Hash
+	}
+	hash, err := g.WriteCommit(&c)
+	if err != nil {
+		return nil, err
+	}
+	// The cached trees and any caches that depend on the entries are now
+	// stale so only the resolve undo extension is kept.
+	ix.cacheTree, ix.untracked = nil, nil
+	ix.extensions = slices.DeleteFunc(ix.extensions, func(ext indexExtension) bool {
+		return ext.signature != extResolveUndo
+	})
+	if err = ix.writeLock(lock); err != nil {
+		return nil, err
+	}
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	action := "commit"
+	if head == nil {
+		action = "commit (initial)"
+	}
+	err = g.updateHead(old, hash, author, fmt.Sprintf("%s: %s", action, subject))
+	if err != nil {
+		return nil, err
+	}
+	if err = lock.Commit(); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// updateHead moves the ref that HEAD points to from old to hash and records
+// the change in the reflog.
+func (g *Git) updateHead(old, hash Hash, who Identity, msg string) error {
+	head, err := g.Head()
+	if err != nil {
+		return err
+	}
+	name := "HEAD"
+	if !head.IsHash() {
+		name = string(head)
+	}
+	if err = g.updateRef(name, old, hash); err != nil {
+		return err
+	}
+	if old == nil {
+		algo, err := g.HashAlgo()
+		if err != nil {
+			return err
+		}
+		old = algo.ZeroHash()
+	}
+	entry := appendReflogEntry(nil, old, hash, who, msg)
+	for _, ref := range []string{name, "HEAD"} {
+		if err = g.appendReflog(ref, entry); err != nil {
+			return err
+		}
+		if name == "HEAD" {
+			break
+		}
+	}
+	return nil
+}
+
+// updateRef sets a loose ref to a new hash if it is still at the old hash. A
+// nil old hash means the ref must not exist yet. See 'ref_transaction_commit'
+// in "refs.c".
+func (g *Git) updateRef(name string, old, hash Hash) error {
+	if !strings.HasPrefix(name, "refs/") && name != "HEAD" {
+		return fmt.Errorf("invalid ref %q", name)
+	}
+	filename := filepath.Join(g.gitDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	lock, err := newLockFile(filename, 0644)
+	if err != nil {
+		return err
+	}
+	current, err := Ref(name).fullFollow(g)
+	switch {
+	case err == nil:
+		if string(current) != old.String() {
+			_ = lock.Rollback()
+			if old == nil {
+				return fmt.Errorf("cannot lock ref %q: reference already exists", name)
+			}
+			return fmt.Errorf("cannot lock ref %q: is at %s but expected %s", name, current, old)
+		}
+	case !os.IsNotExist(err) || old != nil:
+		_ = lock.Rollback()
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("cannot lock ref %q: reference is missing", name)
+		}
+		return err
+	}
+	if _, err = lock.WriteString(hash.String() + "\n"); err != nil {
+		_ = lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+func appendReflogEntry(buf []byte, old, hash Hash, who Identity, msg string) []byte {
+	buf = append(buf, old.String()...)
+	buf = append(buf, ' ')
+	buf = append(buf, hash.String()...)
+	buf = append(buf, ' ')
+	buf = appendCommitAuthor(buf, who.String()+" ", who.When)
+	buf = append(buf, '\t')
+	buf = append(buf, strings.ReplaceAll(msg, "\n", " ")...)
+	return append(buf, '\n')
+}
+
+func (g *Git) appendReflog(ref string, entry []byte) error {
+	filename := filepath.Join(g.gitDir, "logs", filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
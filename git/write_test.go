@@ -0,0 +1,150 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestGit_WriteObject(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	o := Object{Type: ObjBlob, Data: []byte("hello world\n")}
+	is.NoErr(git.WriteObject(&o))
+	is.Equal(o.Size, uint64(12))
+	exp, err := gitHashObject(git, "hello world\n")
+	is.NoErr(err)
+	is.Equal(o.Hash, string(exp))
+	var out bytes.Buffer
+	cmd := git.Cmd("cat-file", "-p", o.Hash)
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	is.Equal(out.String(), "hello world\n")
+
+	// Existing objects are not rewritten.
+	filename := git.objectFilename(o.Hash)
+	before, err := os.Stat(filename)
+	is.NoErr(err)
+	is.NoErr(git.WriteObject(&Object{Type: ObjBlob, Data: []byte("hello world\n")}))
+	after, err := os.Stat(filename)
+	is.NoErr(err)
+	is.Equal(before.ModTime(), after.ModTime())
+	is.Equal(after.Mode().Perm(), os.FileMode(0444))
+}
+
+func TestGit_WriteTree(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git))
+	blob := func(s string) Hash {
+		h, err := git.writeObject(ObjBlob, []byte(s))
+		is.NoErr(err)
+		return h
+	}
+	// "a.b" sorts before the directory "a" since '.' < '/'.
+	tree, err := git.WriteTree(map[string]TreeEntry{
+		"a/b":      {Mode: modeRegular | 0644, Hash: blob("b\n")},
+		"a.b":      {Mode: modeRegular | 0755, Hash: blob("exec\n")},
+		"a/c/d":    {Mode: modeRegular | 0644, Hash: blob("d\n")},
+		"z":        {Mode: modeSymlink, Hash: blob("a/b")},
+		"a-config": {Mode: modeRegular | 0644, Hash: blob("x\n")},
+	})
+	is.NoErr(err)
+	var out bytes.Buffer
+	cmd := git.Cmd("ls-tree", "-r", "--name-only", tree.String())
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	is.Equal(out.String(), "a-config\na.b\na/b\na/c/d\nz\n")
+	is.NoErr(git.RunCmd("fsck", "--strict", "--no-dangling"))
+
+	_, err = git.WriteTree(map[string]TreeEntry{"a/../b": {Mode: modeRegular | 0644, Hash: blob("b\n")}})
+	is.True(err != nil)
+}
+
+func TestGit_CommitFiles(t *testing.T) {
+	is := is.New(t)
+	git := testgit(t)
+	is.NoErr(setupTestRepo(git,
+		newfile("one", "this is the first file\n"),
+		newfile("dir/two", "this is the second file\n"),
+	))
+	author := Identity{Name: "Jane Doe", Email: "jane@example.com", When: time.Unix(1700000000, 0)}
+	first, err := git.CommitFiles([]string{"one", filepath.Join(git.WorkingTree(), "dir")}, "add files", author)
+	is.NoErr(err)
+	is.NoErr(git.RunCmd("fsck", "--strict"))
+	is.Equal(must(git.HeadCommitHash()), NewHashRef(first))
+	is.Equal(must(git.LsFiles()), []string{"dir/two", "one"})
+	mods, err := git.Modifications()
+	is.NoErr(err)
+	is.Equal(len(mods), 0)
+	logs, err := git.Reflog("HEAD")
+	is.NoErr(err)
+	is.Equal(len(logs), 1)
+	is.Equal(logs[0].Message, "commit (initial): add files")
+	is.True(logs[0].Prev.IsZero())
+
+	_, err = git.CommitFiles([]string{"one"}, "nothing", author)
+	is.Equal(err, ErrNothingToCommit)
+	is.True(!exists(git.indexFile() + ".lock"))
+
+	is.NoErr(appendfile(filepath.Join(git.WorkingTree(), "one"), "more\n"))
+	is.NoErr(os.Symlink("one", filepath.Join(git.WorkingTree(), "link")))
+	is.NoErr(os.Remove(filepath.Join(git.WorkingTree(), "dir/two")))
+	is.NoErr(os.WriteFile(filepath.Join(git.WorkingTree(), "dir/three"), []byte("3\n"), 0755))
+	second, err := git.CommitFiles([]string{"one", "link", "dir"}, "update\n\nbody\n", author)
+	is.NoErr(err)
+	is.NoErr(git.RunCmd("fsck", "--strict"))
+	is.Equal(must(git.LsFiles()), []string{"dir/three", "link", "one"})
+	var out bytes.Buffer
+	cmd := git.Cmd("log", "--format=%H %P %an <%ae> %s")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	is.Equal(out.String(), second.String()+" "+first.String()+" Jane Doe <jane@example.com> update\n"+
+		first.String()+"  Jane Doe <jane@example.com> add files\n")
+	out.Reset()
+	cmd = git.Cmd("ls-files", "-s", "dir/three", "link")
+	cmd.Stdout = &out
+	is.NoErr(run(cmd))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	is.True(strings.HasPrefix(lines[0], "100755 "))
+	is.True(strings.HasPrefix(lines[1], "120000 "))
+	is.NoErr(git.RunCmd("diff-index", "--quiet", "HEAD"))
+
+	logs, err = git.Reflog(string(must(git.Head())))
+	is.NoErr(err)
+	is.Equal(len(logs), 2)
+	is.Equal(logs[0].Message, "commit: update")
+	is.True(logs[0].Prev.Equal(first))
+
+	removed, err := git.CommitRemoval([]string{"link"}, "remove link", author)
+	is.NoErr(err)
+	is.Equal(must(git.LsFiles()), []string{"dir/three", "one"})
+	is.True(exists(filepath.Join(git.WorkingTree(), "link")))
+	is.Equal(must(git.HeadCommitHash()), NewHashRef(removed))
+	_, err = git.CommitRemoval([]string{"link"}, "remove link", author)
+	is.True(err != nil)
+	_, err = git.CommitFiles([]string{"../outside"}, "outside", author)
+	is.True(err != nil)
+
+	// Nothing should change while another process holds the index lock or
+	// when the branch cannot be updated.
+	is.NoErr(appendfile(filepath.Join(git.WorkingTree(), "one"), "locked\n"))
+	index, err := os.ReadFile(git.indexFile())
+	is.NoErr(err)
+	for _, name := range []string{git.indexFile(), filepath.Join(git.gitDir, string(must(git.Head())))} {
+		lock, err := newLockFile(name, 0644)
+		is.NoErr(err)
+		_, err = git.CommitFiles([]string{"one"}, "locked", author)
+		is.True(err != nil)
+		is.NoErr(lock.Rollback())
+		is.Equal(must(git.HeadCommitHash()), NewHashRef(removed))
+		is.Equal(must(os.ReadFile(git.indexFile())), index)
+		is.True(!exists(git.indexFile() + ".lock"))
+	}
+}
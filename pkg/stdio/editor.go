@@ -0,0 +1,39 @@
+package stdio
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mattn/go-shellwords"
+)
+
+// Edit opens a file in an editor and waits for it to exit.
+func Edit(editor, file string) error {
+	args, err := shellwords.Parse(editor)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no editor")
+	}
+	cmd := exec.Command(args[0], append(args[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %q: %w", args[0], err)
+	}
+	return nil
+}
+
+// FindEditor returns the editor set by the environment the same way as git
+// or "vi".
+func FindEditor() string {
+	for _, key := range []string{"DOTS_EDITOR", "GIT_EDITOR", "VISUAL", "EDITOR"} {
+		if editor, ok := os.LookupEnv(key); ok && editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
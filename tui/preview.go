@@ -1,19 +1,15 @@
 package tui
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"log/slog"
-	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/harrybrwn/dots/diff"
 	"github.com/harrybrwn/dots/git"
 )
 
@@ -88,69 +84,49 @@ func (sv *StatPreview) View() string {
 	return b.String()
 }
 
-type ExecPreview struct {
-	proc  *exec.Cmd
-	entry *TreeEntry
+// DiffPreview shows the changes made to a modified file in the preview pane.
+type DiffPreview struct {
+	load  func(path string) (*diff.File, error)
 	mods  modSet
+	entry *TreeEntry
+	view  string
 }
 
-func NewExecPreview(proc *exec.Cmd, mods map[string]git.ModType) *ExecPreview {
-	return &ExecPreview{
-		proc: proc,
-		mods: mods,
-	}
+// NewDiffPreview creates a preview that uses load to compare a file with the
+// version in the repo. Load is given the file's path relative to the root of
+// the tree and may return a nil file if nothing changed.
+func NewDiffPreview(load func(path string) (*diff.File, error), mods map[string]git.ModType) *DiffPreview {
+	return &DiffPreview{load: load, mods: mods}
 }
 
-func (ep *ExecPreview) View() string      { return "" }
-func (ep *ExecPreview) Open(e *TreeEntry) { ep.entry = e }
-func (ep *ExecPreview) Close()            { ep.entry = nil }
-func (ep *ExecPreview) IsOpen() bool      { return ep.entry != nil }
+func (dp *DiffPreview) View() string { return dp.view }
+func (dp *DiffPreview) Open(e *TreeEntry) {
+	dp.entry = e
+	dp.view = ""
+}
+func (dp *DiffPreview) Close()       { dp.entry = nil }
+func (dp *DiffPreview) IsOpen() bool { return dp.entry != nil }
 
-func (ep *ExecPreview) Update(tea.Msg) (tea.Model, tea.Cmd) {
-	m := NoOpInitModel{ep}
-	if ep.entry == nil {
+func (dp *DiffPreview) Update(tea.Msg) (tea.Model, tea.Cmd) {
+	m := NoOpInitModel{dp}
+	if dp.entry == nil {
 		return &m, PreviewClose
 	}
-	stem := ep.entry.Path
-	if stem[0] == '/' {
-		stem = stem[1:]
-	}
-	if _, ok := ep.mods[stem]; !ok {
-		slog.Debug("path not in mod set", "path", stem, "keys", slices.Collect(maps.Keys(ep.mods)))
+	stem := strings.TrimPrefix(dp.entry.Path, "/")
+	if _, ok := dp.mods[stem]; !ok {
 		return &m, PreviewClose
 	}
-	home, err := os.UserHomeDir()
+	f, err := dp.load(stem)
 	if err != nil {
-		return &m, SendErrorf("could not get home directory: %w", err)
+		slog.Error("failed to diff file", "path", stem, "error", err)
+		return &m, tea.Batch(SendError(err), PreviewClose)
 	}
-	args := make([]string, len(ep.proc.Args))
-	copy(args, ep.proc.Args)
-	args = append(args, filepath.Join(home, stem))
-
-	proc := *ep.proc
-	var stderr bytes.Buffer
-	proc.Stderr = &stderr
-	proc.Stdout = os.Stdout
-	proc.Stdin = os.Stdin
-	proc.Args = args
-	slog.Info("exec preview", "cmd", args)
-	return &m, tea.ExecProcess(&proc, func(err error) tea.Msg {
-		ep.Close() // make sure we're auto closed
-		// write the process's stderr buffer to our own stderr
-		errmsg := stderr.String()
-		_, _ = io.Copy(os.Stderr, &stderr)
-		if err != nil {
-			slog.Error("failed exec preview",
-				"error", err,
-				"stderr", errmsg,
-				"cmd", args)
-			return tea.Batch(
-				SendError(fmt.Errorf("%w: %v", err, errmsg)),
-				PreviewClose,
-			)()
-		}
-		return PreviewCloseMsg{}
-	})
+	if f == nil {
+		return &m, PreviewClose
+	}
+	p := diff.Printer{Color: true}
+	dp.view = strings.TrimSuffix(p.String(f), "\n")
+	return &m, nil
 }
 
 type NoPreview struct{ path string }